@@ -1,27 +1,59 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
 
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
 	coreauth "github.com/jasonchiu/envlock/core/auth"
 	coreconfig "github.com/jasonchiu/envlock/core/config"
+	coredb "github.com/jasonchiu/envlock/core/db"
 	corerouter "github.com/jasonchiu/envlock/core/router"
+	"github.com/jasonchiu/envlock/feature/cliauth"
+	"github.com/jasonchiu/envlock/feature/enrollment"
+	"github.com/jasonchiu/envlock/feature/projects"
+	"github.com/jasonchiu/envlock/feature/secretblobs"
 )
 
 func main() {
 	coreconfig.LoadDotenvIfPresent()
 
 	cfg := coreconfig.Load()
-	store := coreauth.NewMemoryStore()
+	db, err := coredb.Open(context.Background(), cfg.DBPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "envlock-server: %v\n", err)
+		os.Exit(1)
+	}
+	defer db.Close()
+	store := coreauth.NewSQLiteStore(db)
+	idp, err := cliauth.NewIdentityProvider(context.Background(), cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "envlock-server: identity provider: %v\n", err)
+		os.Exit(1)
+	}
 	handler := corerouter.New(corerouter.Deps{
 		Config:        cfg,
 		CLILoginStore: store,
+		Identity:      idp,
+		WebSessions:   coreauth.NewWebSessions(db),
+		Projects:      projects.NewStore(db),
+		Secrets:       secretblobs.NewStore(db, cfg.BlobDir),
+		Enrollment:    enrollment.NewStore(db),
+		Audit:         coreaudit.NewLog(db),
 	})
 
 	fmt.Printf("envlock server listening on %s\n", cfg.Addr)
 	fmt.Printf("base url: %s\n", cfg.BaseURL)
+	fmt.Printf("database: %s\n", cfg.DBPath)
+	fmt.Printf("blobs: %s\n", cfg.BlobDir)
+	fmt.Printf("web ui: %s/ui\n", cfg.BaseURL)
+	if idp.Name() == coreconfig.IdentityProviderDev {
+		fmt.Printf("identity provider: dev (every browser login is %s; development only)\n", cfg.DevUserEmail)
+	} else {
+		fmt.Printf("identity provider: %s (%s)\n", idp.Name(), cfg.OIDCIssuer)
+	}
 	if err := http.ListenAndServe(cfg.Addr, handler); err != nil {
 		fmt.Fprintf(os.Stderr, "envlock-server: %v\n", err)
 		os.Exit(1)
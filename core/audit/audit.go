@@ -0,0 +1,186 @@
+// Package audit records security-relevant server events (logins, enrollment
+// decisions, secret pushes and pulls) in the server database and queries
+// them for compliance reviews.
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	"github.com/jasonchiu/envlock/core/serverapi"
+)
+
+// Actions. Project-scoped events carry the project ID; logins and logouts are
+// server-wide.
+const (
+	ActionLogin          = "login"
+	ActionLogout         = "logout"
+	ActionSessionsRevoke = "sessions.revoke"
+	ActionProjectCreate  = "project.create"
+	ActionProjectRename  = "project.rename"
+	ActionProjectDelete  = "project.delete"
+	ActionMemberSet      = "member.set"
+	ActionMemberRemove   = "member.remove"
+	ActionInviteCreate   = "invite.create"
+	ActionEnrollJoin     = "enroll.join"
+	ActionEnrollApprove  = "enroll.approve"
+	ActionEnrollReject   = "enroll.reject"
+	ActionDeviceRevoke   = "device.revoke"
+	ActionSecretPush     = "secret.push"
+	ActionSecretPull     = "secret.pull"
+	ActionSecretRekey    = "secret.rekey"
+	ActionSecretRollback = "secret.rollback"
+)
+
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Event is one audit record. Device is the fingerprint of the CLI device key
+// the request was made with, as reported by the client.
+type Event struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	ActorEmail string    `json:"actor_email,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+}
+
+// FromRequest starts an event for action performed by user over r.
+func FromRequest(r *http.Request, user coreauth.User, action string) Event {
+	return Event{
+		Time:       time.Now().UTC(),
+		Action:     action,
+		ActorID:    user.ID,
+		ActorEmail: user.Email,
+		Device:     strings.TrimSpace(r.Header.Get(serverapi.HeaderDevice)),
+		RemoteAddr: r.RemoteAddr,
+	}
+}
+
+// Filter selects events, newest first. Before is an event ID cursor from a
+// previous page; zero values match everything.
+type Filter struct {
+	ProjectID string
+	Since     time.Time
+	Until     time.Time
+	Actor     string
+	Action    string
+	Before    int64
+	Limit     int
+}
+
+// PageSize is Limit clamped to [1, MaxLimit], DefaultLimit when unset.
+func (f Filter) PageSize() int {
+	switch {
+	case f.Limit <= 0:
+		return DefaultLimit
+	case f.Limit > MaxLimit:
+		return MaxLimit
+	}
+	return f.Limit
+}
+
+// Log is the audit event table. A nil *Log discards events.
+type Log struct {
+	db *sql.DB
+}
+
+func NewLog(db *sql.DB) *Log {
+	return &Log{db: db}
+}
+
+func (l *Log) Record(ctx context.Context, e Event) error {
+	if l == nil {
+		return nil
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	_, err := l.db.ExecContext(ctx,
+		`INSERT INTO audit_events (created_at, action, project_id, actor_id, actor_email, device, target, detail, remote_addr)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		e.Time.UTC().UnixMilli(), e.Action, e.ProjectID, e.ActorID, e.ActorEmail, e.Device, e.Target, e.Detail, e.RemoteAddr,
+	)
+	return err
+}
+
+// TryRecord records e after the audited action already happened, logging
+// rather than failing if the write does not go through.
+func (l *Log) TryRecord(ctx context.Context, e Event) {
+	if err := l.Record(ctx, e); err != nil {
+		log.Printf("audit: record %s: %v", e.Action, err)
+	}
+}
+
+// Query returns up to f.PageSize() matching events, newest first.
+func (l *Log) Query(ctx context.Context, f Filter) ([]Event, error) {
+	out := []Event{}
+	if l == nil {
+		return out, nil
+	}
+	var (
+		where []string
+		args  []any
+	)
+	if f.ProjectID != "" {
+		where = append(where, "project_id = ?")
+		args = append(args, f.ProjectID)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, f.Since.UTC().UnixMilli())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, f.Until.UTC().UnixMilli())
+	}
+	if actor := strings.TrimSpace(f.Actor); actor != "" {
+		where = append(where, "(lower(actor_email) = lower(?) OR actor_id = ?)")
+		args = append(args, actor, actor)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.Before > 0 {
+		where = append(where, "id < ?")
+		args = append(args, f.Before)
+	}
+	q := `SELECT id, created_at, action, project_id, actor_id, actor_email, device, target, detail, remote_addr FROM audit_events`
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	q += " ORDER BY id DESC LIMIT ?"
+	args = append(args, f.PageSize())
+
+	rows, err := l.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			e         Event
+			createdAt int64
+		)
+		if err := rows.Scan(&e.ID, &createdAt, &e.Action, &e.ProjectID, &e.ActorID, &e.ActorEmail,
+			&e.Device, &e.Target, &e.Detail, &e.RemoteAddr); err != nil {
+			return nil, err
+		}
+		e.Time = time.UnixMilli(createdAt).UTC()
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -5,17 +5,24 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
 var (
+	ErrInvalidCallbackURL   = errors.New("callback_url must be http://127.0.0.1:<port>, http://localhost:<port> or http://[::1]:<port>")
 	ErrPendingLoginNotFound = errors.New("pending login not found")
 	ErrPendingLoginExpired  = errors.New("pending login expired")
 	ErrInvalidCode          = errors.New("invalid login code")
 	ErrCodeExpired          = errors.New("login code expired")
 	ErrTokenNotFound        = errors.New("access token not found")
 	ErrTokenExpired         = errors.New("access token expired")
+	ErrRefreshTokenInvalid  = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired  = errors.New("refresh token expired")
+	ErrRefreshTokenReused   = errors.New("refresh token reused; session revoked")
 )
 
 type User struct {
@@ -25,19 +32,21 @@ type User struct {
 }
 
 type PendingCLILogin struct {
-	State       string
-	CallbackURL string
-	CreatedAt   time.Time
-	ExpiresAt   time.Time
+	State         string
+	CallbackURL   string
+	CodeChallenge string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
 }
 
 type CLILoginCode struct {
-	Code      string
-	State     string
-	User      User
-	CreatedAt time.Time
-	ExpiresAt time.Time
-	UsedAt    *time.Time
+	Code          string
+	State         string
+	CodeChallenge string
+	User          User
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        *time.Time
 }
 
 type AccessToken struct {
@@ -48,12 +57,59 @@ type AccessToken struct {
 	RefreshToken string
 }
 
+// Store persists CLI login state: pending logins, one-time login codes and
+// issued tokens. MemoryStore is the in-process implementation used for
+// development; SQLiteStore survives server restarts.
+type Store interface {
+	// StartCLILogin records a pending login bound to a PKCE S256
+	// codeChallenge; ExchangeCode must present the matching verifier.
+	StartCLILogin(callbackURL, codeChallenge string, ttl time.Duration) (PendingCLILogin, error)
+	GetPendingCLILogin(state string, now time.Time) (PendingCLILogin, error)
+	IssueCodeForState(state string, user User, ttl time.Duration, now time.Time) (CLILoginCode, error)
+	ExchangeCode(code, state, codeVerifier string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error)
+	ValidateAccessToken(token string, now time.Time) (User, error)
+	// RefreshAccessToken redeems a refresh token for a new access/refresh
+	// pair. Each refresh token is single-use; presenting one that was already
+	// rotated revokes every token from the same login and returns
+	// ErrRefreshTokenReused.
+	RefreshAccessToken(refreshToken string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error)
+	// RevokeSession invalidates the login accessToken belongs to: every
+	// access and refresh token descended from it.
+	RevokeSession(accessToken string) error
+	// RevokeUserSessions invalidates every login of the users with email and
+	// returns how many logins were revoked.
+	RevokeUserSessions(email string) (int, error)
+
+	// Device authorization grant (see device.go).
+	StartDeviceLogin(ttl, interval time.Duration, now time.Time) (DeviceLogin, error)
+	GetDeviceLogin(userCode string, now time.Time) (DeviceLogin, error)
+	DecideDeviceLogin(userCode string, user User, approve bool, now time.Time) error
+	PollDeviceLogin(deviceCode string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error)
+}
+
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*SQLiteStore)(nil)
+)
+
+// refreshRecord is one link in a refresh-token rotation chain. Family is
+// shared by every token descended from the same login.
+type refreshRecord struct {
+	User      User
+	Family    string
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+}
+
 type MemoryStore struct {
 	mu            sync.Mutex
 	pending       map[string]PendingCLILogin
 	codes         map[string]CLILoginCode
 	accessTokens  map[string]AccessToken
-	refreshTokens map[string]AccessToken
+	accessFamily  map[string]string
+	refreshTokens map[string]refreshRecord
+	devices       map[string]*deviceRecord
+	deviceByUser  map[string]string
 }
 
 func NewMemoryStore() *MemoryStore {
@@ -61,24 +117,31 @@ func NewMemoryStore() *MemoryStore {
 		pending:       map[string]PendingCLILogin{},
 		codes:         map[string]CLILoginCode{},
 		accessTokens:  map[string]AccessToken{},
-		refreshTokens: map[string]AccessToken{},
+		accessFamily:  map[string]string{},
+		refreshTokens: map[string]refreshRecord{},
+		devices:       map[string]*deviceRecord{},
+		deviceByUser:  map[string]string{},
 	}
 }
 
-func (s *MemoryStore) StartCLILogin(callbackURL string, ttl time.Duration) (PendingCLILogin, error) {
+func (s *MemoryStore) StartCLILogin(callbackURL, codeChallenge string, ttl time.Duration) (PendingCLILogin, error) {
 	if ttl <= 0 {
 		return PendingCLILogin{}, fmt.Errorf("ttl must be > 0")
 	}
+	if err := ValidatePKCEChallenge(codeChallenge, PKCEMethodS256); err != nil {
+		return PendingCLILogin{}, err
+	}
 	state, err := randomHex(16)
 	if err != nil {
 		return PendingCLILogin{}, err
 	}
 	now := time.Now().UTC()
 	item := PendingCLILogin{
-		State:       state,
-		CallbackURL: callbackURL,
-		CreatedAt:   now,
-		ExpiresAt:   now.Add(ttl),
+		State:         state,
+		CallbackURL:   callbackURL,
+		CodeChallenge: codeChallenge,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -123,17 +186,18 @@ func (s *MemoryStore) IssueCodeForState(state string, user User, ttl time.Durati
 	}
 	code := "envlock-code-" + codeRaw
 	item := CLILoginCode{
-		Code:      code,
-		State:     state,
-		User:      user,
-		CreatedAt: now,
-		ExpiresAt: now.Add(ttl),
+		Code:          code,
+		State:         state,
+		CodeChallenge: p.CodeChallenge,
+		User:          user,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
 	}
 	s.codes[code] = item
 	return item, nil
 }
 
-func (s *MemoryStore) ExchangeCode(code, state string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+func (s *MemoryStore) ExchangeCode(code, state, codeVerifier string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
 	if accessTTL <= 0 || refreshTTL <= 0 {
 		return AccessToken{}, fmt.Errorf("token ttl must be > 0")
 	}
@@ -145,7 +209,7 @@ func (s *MemoryStore) ExchangeCode(code, state string, accessTTL, refreshTTL tim
 	if !ok {
 		return AccessToken{}, ErrInvalidCode
 	}
-	if state != "" && item.State != "" && item.State != state {
+	if item.State != state {
 		return AccessToken{}, ErrInvalidCode
 	}
 	if now.After(item.ExpiresAt) {
@@ -155,7 +219,52 @@ func (s *MemoryStore) ExchangeCode(code, state string, accessTTL, refreshTTL tim
 	if item.UsedAt != nil {
 		return AccessToken{}, ErrInvalidCode
 	}
+	if !VerifyPKCE(item.CodeChallenge, codeVerifier) {
+		return AccessToken{}, ErrInvalidCodeVerifier
+	}
+
+	family, err := randomHex(16)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	out, err := s.issueLocked(item.User, family, accessTTL, refreshTTL, now)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	usedAt := now
+	item.UsedAt = &usedAt
+	s.codes[code] = item
+	delete(s.pending, item.State)
+	return out, nil
+}
+
+func (s *MemoryStore) RefreshAccessToken(refreshToken string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return AccessToken{}, fmt.Errorf("token ttl must be > 0")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanupLocked(now)
+
+	rec, ok := s.refreshTokens[refreshToken]
+	if !ok {
+		return AccessToken{}, ErrRefreshTokenInvalid
+	}
+	if rec.UsedAt != nil {
+		s.revokeFamilyLocked(rec.Family)
+		return AccessToken{}, ErrRefreshTokenReused
+	}
+	if now.After(rec.ExpiresAt) {
+		delete(s.refreshTokens, refreshToken)
+		return AccessToken{}, ErrRefreshTokenExpired
+	}
+	usedAt := now
+	rec.UsedAt = &usedAt
+	s.refreshTokens[refreshToken] = rec
+	return s.issueLocked(rec.User, rec.Family, accessTTL, refreshTTL, now)
+}
 
+func (s *MemoryStore) issueLocked(user User, family string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
 	accessToken, err := randomToken("atk_")
 	if err != nil {
 		return AccessToken{}, err
@@ -166,25 +275,66 @@ func (s *MemoryStore) ExchangeCode(code, state string, accessTTL, refreshTTL tim
 	}
 	out := AccessToken{
 		Token:        accessToken,
-		User:         item.User,
+		User:         user,
 		CreatedAt:    now,
 		ExpiresAt:    now.Add(accessTTL),
 		RefreshToken: refreshToken,
 	}
 	s.accessTokens[accessToken] = out
-	s.refreshTokens[refreshToken] = AccessToken{
-		Token:     refreshToken,
-		User:      item.User,
-		CreatedAt: now,
+	s.accessFamily[accessToken] = family
+	s.refreshTokens[refreshToken] = refreshRecord{
+		User:      user,
+		Family:    family,
 		ExpiresAt: now.Add(refreshTTL),
 	}
-	usedAt := now
-	item.UsedAt = &usedAt
-	s.codes[code] = item
-	delete(s.pending, item.State)
 	return out, nil
 }
 
+func (s *MemoryStore) revokeFamilyLocked(family string) {
+	for k, v := range s.refreshTokens {
+		if v.Family == family {
+			delete(s.refreshTokens, k)
+		}
+	}
+	for k, f := range s.accessFamily {
+		if f == family {
+			delete(s.accessTokens, k)
+			delete(s.accessFamily, k)
+		}
+	}
+}
+
+func (s *MemoryStore) RevokeSession(accessToken string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	family, ok := s.accessFamily[accessToken]
+	if !ok {
+		return ErrTokenNotFound
+	}
+	s.revokeFamilyLocked(family)
+	return nil
+}
+
+func (s *MemoryStore) RevokeUserSessions(email string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	families := map[string]bool{}
+	for _, v := range s.refreshTokens {
+		if strings.EqualFold(v.User.Email, email) {
+			families[v.Family] = true
+		}
+	}
+	for k, v := range s.accessTokens {
+		if strings.EqualFold(v.User.Email, email) {
+			families[s.accessFamily[k]] = true
+		}
+	}
+	for family := range families {
+		s.revokeFamilyLocked(family)
+	}
+	return len(families), nil
+}
+
 func (s *MemoryStore) ValidateAccessToken(token string, now time.Time) (User, error) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -195,6 +345,7 @@ func (s *MemoryStore) ValidateAccessToken(token string, now time.Time) (User, er
 	}
 	if now.After(t.ExpiresAt) {
 		delete(s.accessTokens, token)
+		delete(s.accessFamily, token)
 		return User{}, ErrTokenExpired
 	}
 	return t.User, nil
@@ -214,6 +365,7 @@ func (s *MemoryStore) cleanupLocked(now time.Time) {
 	for k, v := range s.accessTokens {
 		if now.After(v.ExpiresAt) {
 			delete(s.accessTokens, k)
+			delete(s.accessFamily, k)
 		}
 	}
 	for k, v := range s.refreshTokens {
@@ -221,6 +373,12 @@ func (s *MemoryStore) cleanupLocked(now time.Time) {
 			delete(s.refreshTokens, k)
 		}
 	}
+	for k, v := range s.devices {
+		if now.After(v.ExpiresAt.Add(5 * time.Minute)) {
+			delete(s.devices, k)
+			delete(s.deviceByUser, v.UserCode)
+		}
+	}
 }
 
 func randomHex(nBytes int) (string, error) {
@@ -238,3 +396,25 @@ func randomToken(prefix string) (string, error) {
 	}
 	return prefix + s, nil
 }
+
+// ValidateLoopbackCallback checks a start request's callback_url. Login codes
+// are redirected there, so only a listener on this machine's loopback
+// interface may receive them; an empty URL selects copy/paste.
+func ValidateLoopbackCallback(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "http" || u.User != nil || u.Opaque != "" || u.Fragment != "" {
+		return ErrInvalidCallbackURL
+	}
+	switch u.Hostname() {
+	case "127.0.0.1", "localhost", "::1":
+	default:
+		return ErrInvalidCallbackURL
+	}
+	if port, err := strconv.Atoi(u.Port()); err != nil || port < 1 || port > 65535 {
+		return ErrInvalidCallbackURL
+	}
+	return nil
+}
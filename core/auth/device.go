@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Device authorization grant (RFC 8628) for headless CLI logins: the CLI gets
+// a device code it polls with and a short user code that the user confirms in
+// a browser on any other machine.
+
+const (
+	DeviceStatusPending  = "pending"
+	DeviceStatusApproved = "approved"
+	DeviceStatusDenied   = "denied"
+	DeviceStatusConsumed = "consumed"
+
+	// deviceSlowDownStep is added to the poll interval each time a client
+	// polls too fast, as RFC 8628 section 3.5 requires.
+	deviceSlowDownStep = 5 * time.Second
+
+	// userCodeAlphabet omits vowels and look-alike characters so codes are
+	// easy to read aloud and never spell words.
+	userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+	userCodeLength   = 8
+)
+
+var (
+	ErrDeviceCodeNotFound    = errors.New("device code not found")
+	ErrUserCodeNotFound      = errors.New("user code not found")
+	ErrAuthorizationPending  = errors.New("authorization_pending")
+	ErrSlowDown              = errors.New("slow_down")
+	ErrDeviceCodeExpired     = errors.New("expired_token")
+	ErrDeviceAccessDenied    = errors.New("access_denied")
+	ErrDeviceLoginNotPending = errors.New("device login is no longer pending")
+)
+
+type DeviceLogin struct {
+	// DeviceCode is only populated when the login is started; stores keep a
+	// hash of it.
+	DeviceCode string
+	UserCode   string
+	Status     string
+	Interval   time.Duration
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+// NormalizeUserCode uppercases a user-entered code and strips separators, so
+// "bcdf-ghjk" and "BCDFGHJK" match the issued "BCDF-GHJK".
+func NormalizeUserCode(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		if r == '-' || r == ' ' {
+			continue
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if len(s) != userCodeLength {
+		return s
+	}
+	return s[:userCodeLength/2] + "-" + s[userCodeLength/2:]
+}
+
+func newUserCode() (string, error) {
+	b := make([]byte, userCodeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	out := make([]byte, userCodeLength)
+	for i := range b {
+		out[i] = userCodeAlphabet[int(b[i])%len(userCodeAlphabet)]
+	}
+	return NormalizeUserCode(string(out)), nil
+}
+
+func newDeviceLogin(ttl, interval time.Duration, now time.Time) (DeviceLogin, error) {
+	if ttl <= 0 || interval <= 0 {
+		return DeviceLogin{}, fmt.Errorf("device login ttl and interval must be > 0")
+	}
+	deviceCode, err := randomToken("dvc_")
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+	userCode, err := newUserCode()
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+	return DeviceLogin{
+		DeviceCode: deviceCode,
+		UserCode:   userCode,
+		Status:     DeviceStatusPending,
+		Interval:   interval,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(ttl),
+	}, nil
+}
+
+type deviceRecord struct {
+	DeviceLogin
+	User       User
+	LastPolled time.Time
+}
+
+func (s *MemoryStore) StartDeviceLogin(ttl, interval time.Duration, now time.Time) (DeviceLogin, error) {
+	item, err := newDeviceLogin(ttl, interval, now)
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cleanupLocked(now)
+	if _, taken := s.deviceByUser[item.UserCode]; taken {
+		return DeviceLogin{}, fmt.Errorf("user code collision; retry")
+	}
+	rec := &deviceRecord{DeviceLogin: item}
+	rec.DeviceCode = ""
+	s.devices[item.DeviceCode] = rec
+	s.deviceByUser[item.UserCode] = item.DeviceCode
+	return item, nil
+}
+
+func (s *MemoryStore) GetDeviceLogin(userCode string, now time.Time) (DeviceLogin, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.deviceByUserCodeLocked(userCode, now)
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+	return rec.DeviceLogin, nil
+}
+
+func (s *MemoryStore) DecideDeviceLogin(userCode string, user User, approve bool, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, err := s.deviceByUserCodeLocked(userCode, now)
+	if err != nil {
+		return err
+	}
+	if rec.Status != DeviceStatusPending {
+		return ErrDeviceLoginNotPending
+	}
+	if approve {
+		rec.Status = DeviceStatusApproved
+		rec.User = user
+	} else {
+		rec.Status = DeviceStatusDenied
+	}
+	return nil
+}
+
+func (s *MemoryStore) PollDeviceLogin(deviceCode string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return AccessToken{}, fmt.Errorf("token ttl must be > 0")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec, ok := s.devices[deviceCode]
+	if !ok || rec.Status == DeviceStatusConsumed {
+		return AccessToken{}, ErrDeviceCodeNotFound
+	}
+	if now.After(rec.ExpiresAt) {
+		return AccessToken{}, ErrDeviceCodeExpired
+	}
+	if !rec.LastPolled.IsZero() && now.Sub(rec.LastPolled) < rec.Interval {
+		rec.Interval += deviceSlowDownStep
+		rec.LastPolled = now
+		return AccessToken{}, ErrSlowDown
+	}
+	rec.LastPolled = now
+	switch rec.Status {
+	case DeviceStatusPending:
+		return AccessToken{}, ErrAuthorizationPending
+	case DeviceStatusDenied:
+		return AccessToken{}, ErrDeviceAccessDenied
+	}
+	family, err := randomHex(16)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	out, err := s.issueLocked(rec.User, family, accessTTL, refreshTTL, now)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	rec.Status = DeviceStatusConsumed
+	return out, nil
+}
+
+func (s *MemoryStore) deviceByUserCodeLocked(userCode string, now time.Time) (*deviceRecord, error) {
+	s.cleanupLocked(now)
+	deviceCode, ok := s.deviceByUser[NormalizeUserCode(userCode)]
+	if !ok {
+		return nil, ErrUserCodeNotFound
+	}
+	rec := s.devices[deviceCode]
+	if now.After(rec.ExpiresAt) {
+		return nil, ErrDeviceCodeExpired
+	}
+	return rec, nil
+}
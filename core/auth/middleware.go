@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type userContextKey struct{}
+
+// BearerToken returns the token from an "Authorization: Bearer" header.
+func BearerToken(r *http.Request) (string, bool) {
+	authz := strings.TrimSpace(r.Header.Get("Authorization"))
+	const prefix = "Bearer "
+	if !strings.HasPrefix(authz, prefix) {
+		return "", false
+	}
+	token := strings.TrimSpace(strings.TrimPrefix(authz, prefix))
+	return token, token != ""
+}
+
+// RequireBearer rejects requests without a valid CLI access token with a
+// JSON 401 and makes the authenticated user available via UserFromContext.
+func RequireBearer(store Store) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := BearerToken(r)
+			if !ok {
+				unauthorized(w, "missing bearer token")
+				return
+			}
+			user, err := store.ValidateAccessToken(token, time.Now().UTC())
+			if err != nil {
+				unauthorized(w, err.Error())
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey{}, user)))
+		})
+	}
+}
+
+// UserFromContext returns the user authenticated by RequireBearer.
+func UserFromContext(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userContextKey{}).(User)
+	return u, ok
+}
+
+func unauthorized(w http.ResponseWriter, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	_ = json.NewEncoder(w).Encode(map[string]any{"error": msg})
+}
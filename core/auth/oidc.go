@@ -0,0 +1,359 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oidcClockSkew is how far exp/iat may be off from the local clock.
+const oidcClockSkew = time.Minute
+
+var (
+	ErrInvalidIDToken     = errors.New("invalid id_token")
+	ErrEmailNotVerified   = errors.New("email address is not verified by the identity provider")
+	ErrEmailDomainDenied  = errors.New("email domain is not allowed on this server")
+	errUnsupportedIDToken = errors.New("id_token must be signed with RS256")
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// AllowedDomains restricts logins to these email domains; empty allows
+	// any verified email.
+	AllowedDomains []string
+}
+
+// OIDCProvider is an OpenID Connect relying party using the authorization
+// code flow with PKCE. ID tokens must be RS256-signed by a key in the
+// issuer's JWKS.
+type OIDCProvider struct {
+	cfg  OIDCConfig
+	http *http.Client
+
+	authEndpoint  string
+	tokenEndpoint string
+	jwksURI       string
+
+	mu   sync.Mutex
+	keys map[string]*rsa.PublicKey
+}
+
+var _ IdentityProvider = (*OIDCProvider)(nil)
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDCProvider fetches the issuer's discovery document.
+func NewOIDCProvider(ctx context.Context, cfg OIDCConfig) (*OIDCProvider, error) {
+	cfg.Issuer = strings.TrimRight(strings.TrimSpace(cfg.Issuer), "/")
+	if cfg.Issuer == "" || strings.TrimSpace(cfg.ClientID) == "" || strings.TrimSpace(cfg.RedirectURL) == "" {
+		return nil, errors.New("oidc issuer, client id and redirect url are required")
+	}
+	p := &OIDCProvider{
+		cfg:  cfg,
+		http: &http.Client{Timeout: 10 * time.Second},
+		keys: map[string]*rsa.PublicKey{},
+	}
+	var doc oidcDiscovery
+	if err := p.getJSON(ctx, cfg.Issuer+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(doc.Issuer, "/") != cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match configured %q", doc.Issuer, cfg.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: authorization_endpoint, token_endpoint and jwks_uri are required")
+	}
+	p.authEndpoint = doc.AuthorizationEndpoint
+	p.tokenEndpoint = doc.TokenEndpoint
+	p.jwksURI = doc.JWKSURI
+	return p, nil
+}
+
+func (p *OIDCProvider) Name() string { return "oidc" }
+
+func (p *OIDCProvider) AuthURL(state, nonce, codeChallenge string) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.cfg.ClientID)
+	q.Set("redirect_uri", p.cfg.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", codeChallenge)
+	q.Set("code_challenge_method", PKCEMethodS256)
+	sep := "?"
+	if strings.Contains(p.authEndpoint, "?") {
+		sep = "&"
+	}
+	return p.authEndpoint + sep + q.Encode()
+}
+
+type oidcTokenResponse struct {
+	IDToken string `json:"id_token"`
+}
+
+// Exchange redeems code at the token endpoint and validates the ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, nonce, codeVerifier string) (User, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.cfg.RedirectURL)
+	form.Set("code_verifier", codeVerifier)
+	form.Set("client_id", p.cfg.ClientID)
+	if p.cfg.ClientSecret != "" {
+		form.Set("client_secret", p.cfg.ClientSecret)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return User{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	var tok oidcTokenResponse
+	if err := p.doJSON(req, &tok); err != nil {
+		return User{}, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	if tok.IDToken == "" {
+		return User{}, fmt.Errorf("%w: token response has no id_token", ErrInvalidIDToken)
+	}
+	return p.verifyIDToken(ctx, tok.IDToken, nonce, time.Now().UTC())
+}
+
+type idTokenClaims struct {
+	Issuer        string          `json:"iss"`
+	Subject       string          `json:"sub"`
+	Audience      json.RawMessage `json:"aud"`
+	AuthorizedBy  string          `json:"azp"`
+	ExpiresAt     int64           `json:"exp"`
+	IssuedAt      int64           `json:"iat"`
+	Nonce         string          `json:"nonce"`
+	Email         string          `json:"email"`
+	EmailVerified *bool           `json:"email_verified"`
+	Name          string          `json:"name"`
+}
+
+func (p *OIDCProvider) verifyIDToken(ctx context.Context, raw, nonce string, now time.Time) (User, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return User{}, fmt.Errorf("%w: malformed", ErrInvalidIDToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return User{}, fmt.Errorf("%w: header: %v", ErrInvalidIDToken, err)
+	}
+	if header.Alg != "RS256" {
+		return User{}, errUnsupportedIDToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return User{}, fmt.Errorf("%w: signature encoding", ErrInvalidIDToken)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return User{}, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return User{}, fmt.Errorf("%w: bad signature", ErrInvalidIDToken)
+	}
+
+	var c idTokenClaims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return User{}, fmt.Errorf("%w: claims: %v", ErrInvalidIDToken, err)
+	}
+	if strings.TrimRight(c.Issuer, "/") != p.cfg.Issuer {
+		return User{}, fmt.Errorf("%w: unexpected issuer %q", ErrInvalidIDToken, c.Issuer)
+	}
+	aud, err := audiences(c.Audience)
+	if err != nil || !contains(aud, p.cfg.ClientID) {
+		return User{}, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+	if len(aud) > 1 && c.AuthorizedBy != "" && c.AuthorizedBy != p.cfg.ClientID {
+		return User{}, fmt.Errorf("%w: not issued for this client", ErrInvalidIDToken)
+	}
+	if c.ExpiresAt == 0 || now.After(time.Unix(c.ExpiresAt, 0).Add(oidcClockSkew)) {
+		return User{}, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	}
+	if c.IssuedAt != 0 && time.Unix(c.IssuedAt, 0).After(now.Add(oidcClockSkew)) {
+		return User{}, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	}
+	if c.Nonce == "" || c.Nonce != nonce {
+		return User{}, fmt.Errorf("%w: nonce mismatch", ErrInvalidIDToken)
+	}
+	if c.Subject == "" || c.Email == "" {
+		return User{}, fmt.Errorf("%w: sub and email claims are required", ErrInvalidIDToken)
+	}
+	// Without email_verified the email is whatever the account claims, and
+	// the allowed-domains check would trust it.
+	if c.EmailVerified == nil || !*c.EmailVerified {
+		return User{}, ErrEmailNotVerified
+	}
+	if !p.domainAllowed(c.Email) {
+		return User{}, ErrEmailDomainDenied
+	}
+	name := strings.TrimSpace(c.Name)
+	if name == "" {
+		name = c.Email
+	}
+	return User{ID: "oidc:" + c.Subject, Email: c.Email, DisplayName: name}, nil
+}
+
+func (p *OIDCProvider) domainAllowed(email string) bool {
+	if len(p.cfg.AllowedDomains) == 0 {
+		return true
+	}
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return false
+	}
+	domain := email[at+1:]
+	for _, d := range p.cfg.AllowedDomains {
+		if strings.EqualFold(strings.TrimPrefix(strings.TrimSpace(d), "@"), domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the signing key for kid, refetching the JWKS once when kid is
+// unknown so that key rotation at the issuer is picked up.
+func (p *OIDCProvider) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.lookupLocked(kid); ok {
+		return k, nil
+	}
+	if err := p.fetchKeysLocked(ctx); err != nil {
+		return nil, err
+	}
+	if k, ok := p.lookupLocked(kid); ok {
+		return k, nil
+	}
+	return nil, fmt.Errorf("%w: unknown signing key %q", ErrInvalidIDToken, kid)
+}
+
+// lookupLocked finds kid, or the only key when the token names none.
+func (p *OIDCProvider) lookupLocked(kid string) (*rsa.PublicKey, bool) {
+	if kid == "" {
+		if len(p.keys) != 1 {
+			return nil, false
+		}
+		for _, k := range p.keys {
+			return k, true
+		}
+	}
+	k, ok := p.keys[kid]
+	return k, ok
+}
+
+type jwks struct {
+	Keys []struct {
+		Kty string `json:"kty"`
+		Kid string `json:"kid"`
+		Use string `json:"use"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+func (p *OIDCProvider) fetchKeysLocked(ctx context.Context) error {
+	var set jwks
+	if err := p.getJSON(ctx, p.jwksURI, &set); err != nil {
+		return fmt.Errorf("oidc jwks: %w", err)
+	}
+	keys := map[string]*rsa.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			continue
+		}
+		keys[k.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	p.keys = keys
+	return nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, dst any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return p.doJSON(req, dst)
+}
+
+func (p *OIDCProvider) doJSON(req *http.Request, dst any) error {
+	resp, err := p.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return fmt.Errorf("%s %s: %s: %s", req.Method, req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(dst)
+}
+
+func decodeSegment(seg string, dst any) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
+
+// audiences accepts the "aud" claim as either a string or an array.
+func audiences(raw json.RawMessage) ([]string, error) {
+	var one string
+	if err := json.Unmarshal(raw, &one); err == nil {
+		return []string{one}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, err
+	}
+	return many, nil
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
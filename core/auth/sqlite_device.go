@@ -0,0 +1,175 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func (s *SQLiteStore) StartDeviceLogin(ttl, interval time.Duration, now time.Time) (DeviceLogin, error) {
+	item, err := newDeviceLogin(ttl, interval, now)
+	if err != nil {
+		return DeviceLogin{}, err
+	}
+	ctx := context.Background()
+	if err := s.cleanup(ctx, now); err != nil {
+		return DeviceLogin{}, err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO cli_device_logins (device_code_hash, user_code, status, interval_ms, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		hashSecret(item.DeviceCode), item.UserCode, item.Status, item.Interval.Milliseconds(), millis(item.CreatedAt), millis(item.ExpiresAt),
+	); err != nil {
+		return DeviceLogin{}, fmt.Errorf("store device login: %w", err)
+	}
+	return item, nil
+}
+
+func (s *SQLiteStore) GetDeviceLogin(userCode string, now time.Time) (DeviceLogin, error) {
+	item, _, err := getDeviceByUserCode(context.Background(), s.db, userCode, now)
+	return item, err
+}
+
+func (s *SQLiteStore) DecideDeviceLogin(userCode string, user User, approve bool, now time.Time) error {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	item, deviceHash, err := getDeviceByUserCode(ctx, tx, userCode, now)
+	if err != nil {
+		return err
+	}
+	if item.Status != DeviceStatusPending {
+		return ErrDeviceLoginNotPending
+	}
+	if approve {
+		if err := upsertUser(ctx, tx, user, now); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx,
+			`UPDATE cli_device_logins SET status = ?, user_id = ? WHERE device_code_hash = ?`,
+			DeviceStatusApproved, user.ID, deviceHash)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			`UPDATE cli_device_logins SET status = ? WHERE device_code_hash = ?`,
+			DeviceStatusDenied, deviceHash)
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) PollDeviceLogin(deviceCode string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return AccessToken{}, fmt.Errorf("token ttl must be > 0")
+	}
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	defer tx.Rollback()
+
+	deviceHash := hashSecret(deviceCode)
+	var (
+		status     string
+		userID     sql.NullString
+		intervalMS int64
+		expiresAt  int64
+		lastPolled sql.NullInt64
+	)
+	err = tx.QueryRowContext(ctx,
+		`SELECT status, user_id, interval_ms, expires_at, last_polled_at FROM cli_device_logins WHERE device_code_hash = ?`,
+		deviceHash,
+	).Scan(&status, &userID, &intervalMS, &expiresAt, &lastPolled)
+	if errors.Is(err, sql.ErrNoRows) || status == DeviceStatusConsumed {
+		return AccessToken{}, ErrDeviceCodeNotFound
+	}
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if now.After(fromMillis(expiresAt)) {
+		return AccessToken{}, ErrDeviceCodeExpired
+	}
+	interval := time.Duration(intervalMS) * time.Millisecond
+	if lastPolled.Valid && now.Sub(fromMillis(lastPolled.Int64)) < interval {
+		// Slow-down is persisted, so commit rather than roll back.
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE cli_device_logins SET interval_ms = ?, last_polled_at = ? WHERE device_code_hash = ?`,
+			(interval + deviceSlowDownStep).Milliseconds(), millis(now), deviceHash,
+		); err != nil {
+			return AccessToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return AccessToken{}, err
+		}
+		return AccessToken{}, ErrSlowDown
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE cli_device_logins SET last_polled_at = ? WHERE device_code_hash = ?`, millis(now), deviceHash,
+	); err != nil {
+		return AccessToken{}, err
+	}
+
+	var out AccessToken
+	var pollErr error
+	switch status {
+	case DeviceStatusPending:
+		pollErr = ErrAuthorizationPending
+	case DeviceStatusDenied:
+		pollErr = ErrDeviceAccessDenied
+	default:
+		user, err := loadUser(ctx, tx, userID.String)
+		if err != nil {
+			return AccessToken{}, err
+		}
+		family, err := randomHex(16)
+		if err != nil {
+			return AccessToken{}, err
+		}
+		out, err = issueTokens(ctx, tx, user, family, accessTTL, refreshTTL, now)
+		if err != nil {
+			return AccessToken{}, err
+		}
+		if _, err := tx.ExecContext(ctx,
+			`UPDATE cli_device_logins SET status = ? WHERE device_code_hash = ?`, DeviceStatusConsumed, deviceHash,
+		); err != nil {
+			return AccessToken{}, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return AccessToken{}, err
+	}
+	return out, pollErr
+}
+
+func getDeviceByUserCode(ctx context.Context, q queryer, userCode string, now time.Time) (DeviceLogin, string, error) {
+	var (
+		item                 DeviceLogin
+		deviceHash           string
+		intervalMS           int64
+		createdAt, expiresAt int64
+	)
+	err := q.QueryRowContext(ctx,
+		`SELECT device_code_hash, user_code, status, interval_ms, created_at, expires_at FROM cli_device_logins WHERE user_code = ?`,
+		NormalizeUserCode(userCode),
+	).Scan(&deviceHash, &item.UserCode, &item.Status, &intervalMS, &createdAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return DeviceLogin{}, "", ErrUserCodeNotFound
+	}
+	if err != nil {
+		return DeviceLogin{}, "", err
+	}
+	item.Interval = time.Duration(intervalMS) * time.Millisecond
+	item.CreatedAt = fromMillis(createdAt)
+	item.ExpiresAt = fromMillis(expiresAt)
+	if now.After(item.ExpiresAt) {
+		return DeviceLogin{}, "", ErrDeviceCodeExpired
+	}
+	return item, deviceHash, nil
+}
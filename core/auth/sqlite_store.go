@@ -0,0 +1,436 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SQLiteStore is the durable Store used by the envlock server. Only hashes
+// of login codes and tokens are written to the database, so a leaked
+// database file does not yield usable credentials.
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore wraps an open, migrated database (see core/db).
+func NewSQLiteStore(db *sql.DB) *SQLiteStore {
+	return &SQLiteStore{db: db}
+}
+
+func (s *SQLiteStore) StartCLILogin(callbackURL, codeChallenge string, ttl time.Duration) (PendingCLILogin, error) {
+	if ttl <= 0 {
+		return PendingCLILogin{}, fmt.Errorf("ttl must be > 0")
+	}
+	if err := ValidatePKCEChallenge(codeChallenge, PKCEMethodS256); err != nil {
+		return PendingCLILogin{}, err
+	}
+	state, err := randomHex(16)
+	if err != nil {
+		return PendingCLILogin{}, err
+	}
+	now := time.Now().UTC()
+	item := PendingCLILogin{
+		State:         state,
+		CallbackURL:   callbackURL,
+		CodeChallenge: codeChallenge,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	}
+	ctx := context.Background()
+	if err := s.cleanup(ctx, now); err != nil {
+		return PendingCLILogin{}, err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO cli_pending_logins (state, callback_url, code_challenge, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		item.State, item.CallbackURL, item.CodeChallenge, millis(item.CreatedAt), millis(item.ExpiresAt),
+	); err != nil {
+		return PendingCLILogin{}, err
+	}
+	return item, nil
+}
+
+func (s *SQLiteStore) GetPendingCLILogin(state string, now time.Time) (PendingCLILogin, error) {
+	return getPending(context.Background(), s.db, state, now)
+}
+
+func (s *SQLiteStore) IssueCodeForState(state string, user User, ttl time.Duration, now time.Time) (CLILoginCode, error) {
+	if ttl <= 0 {
+		return CLILoginCode{}, fmt.Errorf("ttl must be > 0")
+	}
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return CLILoginCode{}, err
+	}
+	defer tx.Rollback()
+
+	p, err := getPending(ctx, tx, state, now)
+	if err != nil {
+		return CLILoginCode{}, err
+	}
+	if err := upsertUser(ctx, tx, user, now); err != nil {
+		return CLILoginCode{}, err
+	}
+	codeRaw, err := randomHex(8)
+	if err != nil {
+		return CLILoginCode{}, err
+	}
+	item := CLILoginCode{
+		Code:          "envlock-code-" + codeRaw,
+		State:         state,
+		CodeChallenge: p.CodeChallenge,
+		User:          user,
+		CreatedAt:     now,
+		ExpiresAt:     now.Add(ttl),
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO cli_login_codes (code_hash, state, code_challenge, user_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashSecret(item.Code), item.State, item.CodeChallenge, user.ID, millis(item.CreatedAt), millis(item.ExpiresAt),
+	); err != nil {
+		return CLILoginCode{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return CLILoginCode{}, err
+	}
+	return item, nil
+}
+
+func (s *SQLiteStore) ExchangeCode(code, state, codeVerifier string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return AccessToken{}, fmt.Errorf("token ttl must be > 0")
+	}
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	defer tx.Rollback()
+
+	codeHash := hashSecret(code)
+	var (
+		codeState string
+		challenge string
+		userID    string
+		expiresAt int64
+		usedAt    sql.NullInt64
+	)
+	err = tx.QueryRowContext(ctx,
+		`SELECT state, code_challenge, user_id, expires_at, used_at FROM cli_login_codes WHERE code_hash = ?`, codeHash,
+	).Scan(&codeState, &challenge, &userID, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccessToken{}, ErrInvalidCode
+	}
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if codeState != state {
+		return AccessToken{}, ErrInvalidCode
+	}
+	if now.After(fromMillis(expiresAt)) {
+		return AccessToken{}, ErrCodeExpired
+	}
+	if usedAt.Valid {
+		return AccessToken{}, ErrInvalidCode
+	}
+	if !VerifyPKCE(challenge, codeVerifier) {
+		return AccessToken{}, ErrInvalidCodeVerifier
+	}
+	user, err := loadUser(ctx, tx, userID)
+	if err != nil {
+		return AccessToken{}, err
+	}
+
+	res, err := tx.ExecContext(ctx,
+		`UPDATE cli_login_codes SET used_at = ? WHERE code_hash = ? AND used_at IS NULL`, millis(now), codeHash)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return AccessToken{}, err
+	} else if n != 1 {
+		return AccessToken{}, ErrInvalidCode
+	}
+	family, err := randomHex(16)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	out, err := issueTokens(ctx, tx, user, family, accessTTL, refreshTTL, now)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM cli_pending_logins WHERE state = ?`, codeState); err != nil {
+		return AccessToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AccessToken{}, err
+	}
+	return out, nil
+}
+
+func (s *SQLiteStore) RefreshAccessToken(refreshToken string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+	if accessTTL <= 0 || refreshTTL <= 0 {
+		return AccessToken{}, fmt.Errorf("token ttl must be > 0")
+	}
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	defer tx.Rollback()
+
+	tokenHash := hashSecret(refreshToken)
+	var (
+		userID, family string
+		expiresAt      int64
+		usedAt         sql.NullInt64
+	)
+	err = tx.QueryRowContext(ctx,
+		`SELECT user_id, family_id, expires_at, used_at FROM refresh_tokens WHERE token_hash = ?`, tokenHash,
+	).Scan(&userID, &family, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return AccessToken{}, ErrRefreshTokenInvalid
+	}
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if usedAt.Valid {
+		// A rotated token came back: either the legitimate client or an
+		// attacker holds a copy. Revoke the whole login so both must sign in
+		// again.
+		if err := revokeFamily(ctx, tx, family); err != nil {
+			return AccessToken{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return AccessToken{}, err
+		}
+		return AccessToken{}, ErrRefreshTokenReused
+	}
+	if now.After(fromMillis(expiresAt)) {
+		return AccessToken{}, ErrRefreshTokenExpired
+	}
+	user, err := loadUser(ctx, tx, userID)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?`, millis(now), tokenHash,
+	); err != nil {
+		return AccessToken{}, err
+	}
+	out, err := issueTokens(ctx, tx, user, family, accessTTL, refreshTTL, now)
+	if err != nil {
+		return AccessToken{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return AccessToken{}, err
+	}
+	return out, nil
+}
+
+func issueTokens(ctx context.Context, q queryer, user User, family string, accessTTL, refreshTTL time.Duration, now time.Time) (AccessToken, error) {
+	accessToken, err := randomToken("atk_")
+	if err != nil {
+		return AccessToken{}, err
+	}
+	refreshToken, err := randomToken("rtk_")
+	if err != nil {
+		return AccessToken{}, err
+	}
+	out := AccessToken{
+		Token:        accessToken,
+		User:         user,
+		CreatedAt:    now,
+		ExpiresAt:    now.Add(accessTTL),
+		RefreshToken: refreshToken,
+	}
+	if _, err := q.ExecContext(ctx,
+		`INSERT INTO refresh_tokens (token_hash, user_id, family_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		hashSecret(refreshToken), user.ID, family, millis(now), millis(now.Add(refreshTTL)),
+	); err != nil {
+		return AccessToken{}, err
+	}
+	if _, err := q.ExecContext(ctx,
+		`INSERT INTO access_tokens (token_hash, user_id, refresh_token_hash, family_id, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)`,
+		hashSecret(accessToken), user.ID, hashSecret(refreshToken), family, millis(now), millis(out.ExpiresAt),
+	); err != nil {
+		return AccessToken{}, err
+	}
+	return out, nil
+}
+
+func revokeFamily(ctx context.Context, q queryer, family string) error {
+	if _, err := q.ExecContext(ctx, `DELETE FROM access_tokens WHERE family_id = ?`, family); err != nil {
+		return err
+	}
+	_, err := q.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE family_id = ?`, family)
+	return err
+}
+
+func (s *SQLiteStore) RevokeSession(accessToken string) error {
+	ctx := context.Background()
+	var family string
+	err := s.db.QueryRowContext(ctx,
+		`SELECT family_id FROM access_tokens WHERE token_hash = ?`, hashSecret(accessToken),
+	).Scan(&family)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrTokenNotFound
+	}
+	if err != nil {
+		return err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := revokeFamily(ctx, tx, family); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLiteStore) RevokeUserSessions(email string) (int, error) {
+	ctx := context.Background()
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const users = `SELECT id FROM users WHERE email = ? COLLATE NOCASE`
+	var n int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COUNT(DISTINCT family_id) FROM (
+			SELECT family_id FROM refresh_tokens WHERE user_id IN (`+users+`)
+			UNION SELECT family_id FROM access_tokens WHERE user_id IN (`+users+`)
+		)`, email, email,
+	).Scan(&n); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM access_tokens WHERE user_id IN (`+users+`)`, email); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM refresh_tokens WHERE user_id IN (`+users+`)`, email); err != nil {
+		return 0, err
+	}
+	// Web UI sessions (see WebSessions) go too, but are not counted.
+	if _, err := tx.ExecContext(ctx, `DELETE FROM web_sessions WHERE user_id IN (`+users+`)`, email); err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (s *SQLiteStore) ValidateAccessToken(token string, now time.Time) (User, error) {
+	var (
+		u         User
+		expiresAt int64
+	)
+	err := s.db.QueryRowContext(context.Background(),
+		`SELECT u.id, u.email, u.display_name, t.expires_at
+		FROM access_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, hashSecret(token),
+	).Scan(&u.ID, &u.Email, &u.DisplayName, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return User{}, ErrTokenNotFound
+	}
+	if err != nil {
+		return User{}, err
+	}
+	if now.After(fromMillis(expiresAt)) {
+		return User{}, ErrTokenExpired
+	}
+	return u, nil
+}
+
+// cleanup drops expired rows. Codes are kept for a few minutes past expiry,
+// matching MemoryStore, so late exchanges report "expired" not "invalid".
+func (s *SQLiteStore) cleanup(ctx context.Context, now time.Time) error {
+	stmts := []struct {
+		query  string
+		cutoff time.Time
+	}{
+		{`DELETE FROM cli_pending_logins WHERE expires_at < ?`, now},
+		{`DELETE FROM cli_login_codes WHERE expires_at < ?`, now.Add(-5 * time.Minute)},
+		{`DELETE FROM access_tokens WHERE expires_at < ?`, now},
+		{`DELETE FROM refresh_tokens WHERE expires_at < ?`, now},
+		{`DELETE FROM cli_device_logins WHERE expires_at < ?`, now.Add(-5 * time.Minute)},
+	}
+	for _, st := range stmts {
+		if _, err := s.db.ExecContext(ctx, st.query, millis(st.cutoff)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func getPending(ctx context.Context, q queryer, state string, now time.Time) (PendingCLILogin, error) {
+	var (
+		item                 PendingCLILogin
+		createdAt, expiresAt int64
+	)
+	err := q.QueryRowContext(ctx,
+		`SELECT state, callback_url, code_challenge, created_at, expires_at FROM cli_pending_logins WHERE state = ?`, state,
+	).Scan(&item.State, &item.CallbackURL, &item.CodeChallenge, &createdAt, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return PendingCLILogin{}, ErrPendingLoginNotFound
+	}
+	if err != nil {
+		return PendingCLILogin{}, err
+	}
+	item.CreatedAt = fromMillis(createdAt)
+	item.ExpiresAt = fromMillis(expiresAt)
+	if now.After(item.ExpiresAt) {
+		if _, err := q.ExecContext(ctx, `DELETE FROM cli_pending_logins WHERE state = ?`, state); err != nil {
+			return PendingCLILogin{}, err
+		}
+		return PendingCLILogin{}, ErrPendingLoginExpired
+	}
+	return item, nil
+}
+
+func upsertUser(ctx context.Context, q queryer, u User, now time.Time) error {
+	_, err := q.ExecContext(ctx,
+		`INSERT INTO users (id, email, display_name, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET email = excluded.email, display_name = excluded.display_name, updated_at = excluded.updated_at`,
+		u.ID, u.Email, u.DisplayName, millis(now), millis(now),
+	)
+	return err
+}
+
+func loadUser(ctx context.Context, q queryer, id string) (User, error) {
+	var u User
+	err := q.QueryRowContext(ctx,
+		`SELECT id, email, display_name FROM users WHERE id = ?`, id,
+	).Scan(&u.ID, &u.Email, &u.DisplayName)
+	if err != nil {
+		return User{}, err
+	}
+	return u, nil
+}
+
+func hashSecret(v string) string {
+	sum := sha256.Sum256([]byte(v))
+	return hex.EncodeToString(sum[:])
+}
+
+func millis(t time.Time) int64 {
+	return t.UTC().UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// WebSessionCookie carries a browser session for the admin web UI.
+const WebSessionCookie = "envlock_session"
+
+var ErrWebSessionNotFound = errors.New("web session not found or expired")
+
+// WebSession is a signed-in browser. CSRF is the token its forms must echo.
+type WebSession struct {
+	User      User
+	CSRF      string
+	ExpiresAt time.Time
+}
+
+// WebSessions keeps browser sessions in the server database next to the CLI
+// tokens. Like them, only a hash of the cookie value is stored.
+type WebSessions struct {
+	db *sql.DB
+}
+
+func NewWebSessions(db *sql.DB) *WebSessions {
+	return &WebSessions{db: db}
+}
+
+// Create signs user in and returns the cookie value for the new session.
+func (s *WebSessions) Create(ctx context.Context, user User, ttl time.Duration, now time.Time) (string, WebSession, error) {
+	token, err := randomToken("ews_")
+	if err != nil {
+		return "", WebSession{}, err
+	}
+	csrf, err := randomHex(24)
+	if err != nil {
+		return "", WebSession{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", WebSession{}, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, `DELETE FROM web_sessions WHERE expires_at < ?`, millis(now)); err != nil {
+		return "", WebSession{}, err
+	}
+	if err := upsertUser(ctx, tx, user, now); err != nil {
+		return "", WebSession{}, err
+	}
+	sess := WebSession{User: user, CSRF: csrf, ExpiresAt: now.Add(ttl)}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO web_sessions (token_hash, user_id, csrf_token, created_at, expires_at) VALUES (?, ?, ?, ?, ?)`,
+		hashSecret(token), user.ID, csrf, millis(now), millis(sess.ExpiresAt),
+	); err != nil {
+		return "", WebSession{}, err
+	}
+	return token, sess, tx.Commit()
+}
+
+func (s *WebSessions) Validate(ctx context.Context, token string, now time.Time) (WebSession, error) {
+	var (
+		sess      WebSession
+		expiresAt int64
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT u.id, u.email, u.display_name, w.csrf_token, w.expires_at
+		FROM web_sessions w JOIN users u ON u.id = w.user_id
+		WHERE w.token_hash = ?`, hashSecret(token),
+	).Scan(&sess.User.ID, &sess.User.Email, &sess.User.DisplayName, &sess.CSRF, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return WebSession{}, ErrWebSessionNotFound
+	}
+	if err != nil {
+		return WebSession{}, err
+	}
+	sess.ExpiresAt = fromMillis(expiresAt)
+	if now.After(sess.ExpiresAt) {
+		return WebSession{}, ErrWebSessionNotFound
+	}
+	return sess, nil
+}
+
+func (s *WebSessions) Delete(ctx context.Context, token string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM web_sessions WHERE token_hash = ?`, hashSecret(token))
+	return err
+}
+
+// CheckCSRF reports whether token matches the session's CSRF token.
+func (s WebSession) CheckCSRF(token string) bool {
+	return s.CSRF != "" && subtle.ConstantTimeCompare([]byte(s.CSRF), []byte(token)) == 1
+}
+
+type webSessionContextKey struct{}
+
+// RequireWebSession lets signed-in browsers through and makes the session
+// available via WebSessionFromContext (and its user via UserFromContext).
+// Other GET requests are sent to loginPath with the page as ?next=; anything
+// else gets a plain 401.
+func RequireWebSession(sessions *WebSessions, loginPath string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var sess WebSession
+			c, err := r.Cookie(WebSessionCookie)
+			if err == nil {
+				sess, err = sessions.Validate(r.Context(), c.Value, time.Now().UTC())
+			}
+			if err != nil {
+				if r.Method != http.MethodGet {
+					http.Error(w, "sign in required", http.StatusUnauthorized)
+					return
+				}
+				http.Redirect(w, r, loginPath+"?next="+url.QueryEscape(r.URL.RequestURI()), http.StatusSeeOther)
+				return
+			}
+			ctx := context.WithValue(r.Context(), userContextKey{}, sess.User)
+			ctx = context.WithValue(ctx, webSessionContextKey{}, sess)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func WebSessionFromContext(ctx context.Context) (WebSession, bool) {
+	s, ok := ctx.Value(webSessionContextKey{}).(WebSession)
+	return s, ok
+}
+
+// SetWebSessionCookie stores token in the browser. secure should be set when
+// the server is reached over https.
+func SetWebSessionCookie(w http.ResponseWriter, token string, expires time.Time, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     WebSessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func ClearWebSessionCookie(w http.ResponseWriter, secure bool) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     WebSessionCookie,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   secure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SafeRedirectPath returns next if it is a path on this server, else
+// fallback. It keeps login redirects from leaving the site.
+func SafeRedirectPath(next, fallback string) string {
+	next = strings.TrimSpace(next)
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+	return next
+}
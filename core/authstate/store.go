@@ -92,6 +92,14 @@ func Write(path string, s State) error {
 	return toml.NewEncoder(f).Encode(s)
 }
 
+// Delete removes the auth state file. A missing file is not an error.
+func Delete(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
 func WriteDefault(s State) (string, error) {
 	path, err := DefaultPath()
 	if err != nil {
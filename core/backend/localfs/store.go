@@ -0,0 +1,520 @@
+// Package localfs implements backend.Store on a local directory. It is meant
+// for offline use, shared network drives and exercising the CLI without a
+// bucket or server.
+//
+// The on-disk layout mirrors the Tigris object layout under <root>/<prefix>,
+// except that enrollment invites and requests use the directory layout of the
+// enroll package (_enroll/invites, _enroll/requests).
+package localfs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/feature/enroll"
+	"github.com/jasonchiu/envlock/feature/recipients"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+const (
+	lockName     = ".lock"
+	lockTimeout  = 10 * time.Second
+	lockStaleAge = 30 * time.Second
+)
+
+type Store struct {
+	dir string
+}
+
+// New opens the localfs store for proj. Relative Path values are resolved
+// against root, the project root directory.
+func New(proj config.Project, root string) (*Store, error) {
+	base := strings.TrimSpace(proj.Path)
+	if base == "" {
+		return nil, errors.New("project path is required for the localfs backend")
+	}
+	if !filepath.IsAbs(base) {
+		base = filepath.Join(root, base)
+	}
+	pfx := strings.Trim(strings.TrimSpace(proj.Prefix), "/")
+	if pfx == "" {
+		pfx = config.DefaultPrefix(proj.AppName)
+	}
+	if pfx == "" {
+		return nil, errors.New("project prefix is required")
+	}
+	return &Store{dir: filepath.Join(base, filepath.FromSlash(pfx))}, nil
+}
+
+// Dir returns the directory holding this project's objects.
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) metaDir() string {
+	return filepath.Join(s.dir, "_envlock")
+}
+
+func (s *Store) recipientsPath() string {
+	return filepath.Join(s.metaDir(), "recipients.json")
+}
+
+func (s *Store) recipientChainPath() string {
+	return filepath.Join(s.metaDir(), "recipients.sig.json")
+}
+
+func (s *Store) manifestPath() string {
+	return filepath.Join(s.metaDir(), "manifest.json")
+}
+
+func (s *Store) secretDir(name string) string {
+	return filepath.Join(s.metaDir(), "secrets", name)
+}
+
+func (s *Store) historyPath(name string) string {
+	return filepath.Join(s.secretDir(name), "history.json")
+}
+
+func (s *Store) versionPath(name, digest string) string {
+	return filepath.Join(s.secretDir(name), digest+secrets.BlobSuffix)
+}
+
+func (s *Store) secretPath(name string) string {
+	return filepath.Join(s.dir, name+secrets.BlobSuffix)
+}
+
+func (s *Store) LoadRecipients(ctx context.Context) (recipients.Store, error) {
+	var rs recipients.Store
+	rev, err := readJSON(s.recipientsPath(), &rs)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return recipients.Store{Version: 1, Recipients: []recipients.Recipient{}}, nil
+		}
+		return recipients.Store{}, err
+	}
+	if rs.Version == 0 {
+		rs.Version = 1
+	}
+	rs.Revision = rev
+	return rs, nil
+}
+
+func (s *Store) WriteRecipients(ctx context.Context, rs recipients.Store) error {
+	if rs.Version == 0 {
+		rs.Version = 1
+	}
+	_, err := s.writeJSON(s.recipientsPath(), rs, rs.Revision)
+	return err
+}
+
+func (s *Store) LoadRecipientChain(ctx context.Context) (recipients.Chain, error) {
+	var c recipients.Chain
+	rev, err := readJSON(s.recipientChainPath(), &c)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return recipients.Chain{Version: 1, Links: []recipients.Link{}}, nil
+		}
+		return recipients.Chain{}, err
+	}
+	c.Revision = rev
+	return c, nil
+}
+
+func (s *Store) WriteRecipientChain(ctx context.Context, c recipients.Chain) error {
+	_, err := s.writeJSON(s.recipientChainPath(), c, c.Revision)
+	return err
+}
+
+func (s *Store) SaveInvite(ctx context.Context, invite enroll.Invite) error {
+	_, err := s.writeJSON(enroll.InvitePath(s.dir, strings.TrimSpace(invite.ID)), invite, invite.Revision)
+	return err
+}
+
+func (s *Store) LoadInvite(ctx context.Context, id string) (enroll.Invite, error) {
+	inv, err := readInvite(enroll.InvitePath(s.dir, strings.TrimSpace(id)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return enroll.Invite{}, enroll.ErrInviteNotFound
+		}
+		return enroll.Invite{}, err
+	}
+	return inv, nil
+}
+
+func (s *Store) ListInvites(ctx context.Context) ([]enroll.Invite, error) {
+	paths, err := listJSON(enroll.InvitesDir(s.dir))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]enroll.Invite, 0, len(paths))
+	for _, p := range paths {
+		inv, err := readInvite(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, inv)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func readInvite(path string) (enroll.Invite, error) {
+	var inv enroll.Invite
+	rev, err := readJSON(path, &inv)
+	if err != nil {
+		return enroll.Invite{}, err
+	}
+	if inv.Version == 0 {
+		inv.Version = 1
+	}
+	inv.Revision = rev
+	return inv, nil
+}
+
+func (s *Store) SaveRequest(ctx context.Context, req enroll.Request) error {
+	_, err := s.writeJSON(enroll.RequestPath(s.dir, strings.TrimSpace(req.ID)), req, req.Revision)
+	return err
+}
+
+func (s *Store) LoadRequest(ctx context.Context, id string) (enroll.Request, error) {
+	req, err := readRequest(enroll.RequestPath(s.dir, strings.TrimSpace(id)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return enroll.Request{}, enroll.ErrRequestNotFound
+		}
+		return enroll.Request{}, err
+	}
+	return req, nil
+}
+
+func (s *Store) ListRequests(ctx context.Context) ([]enroll.Request, error) {
+	paths, err := listJSON(enroll.RequestsDir(s.dir))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]enroll.Request, 0, len(paths))
+	for _, p := range paths {
+		req, err := readRequest(p)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, req)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func readRequest(path string) (enroll.Request, error) {
+	var req enroll.Request
+	rev, err := readJSON(path, &req)
+	if err != nil {
+		return enroll.Request{}, err
+	}
+	if req.Version == 0 {
+		req.Version = 1
+	}
+	req.Revision = rev
+	return req, nil
+}
+
+func (s *Store) LoadSecretHistory(ctx context.Context, name string) (secrets.History, error) {
+	if err := secrets.ValidateName(name); err != nil {
+		return secrets.History{}, err
+	}
+	var h secrets.History
+	rev, err := readJSON(s.historyPath(name), &h)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return secrets.History{}, secrets.ErrSecretNotFound
+		}
+		return secrets.History{}, err
+	}
+	if h.Version == 0 {
+		h.Version = 1
+	}
+	h.Revision = rev
+	return h, nil
+}
+
+func (s *Store) LoadSecret(ctx context.Context, name string) (secrets.Blob, error) {
+	h, err := s.LoadSecretHistory(ctx, name)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	latest, ok := h.Latest()
+	if !ok {
+		return secrets.Blob{}, secrets.ErrSecretNotFound
+	}
+	return s.loadVersion(name, latest)
+}
+
+func (s *Store) LoadSecretVersion(ctx context.Context, name string, version int) (secrets.Blob, error) {
+	h, err := s.LoadSecretHistory(ctx, name)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	v, err := h.Get(version)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	return s.loadVersion(name, v)
+}
+
+func (s *Store) loadVersion(name string, v secrets.Version) (secrets.Blob, error) {
+	data, err := os.ReadFile(s.versionPath(name, v.SHA256))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return secrets.Blob{}, fmt.Errorf("%w: %s v%d object missing", secrets.ErrVersionNotFound, name, v.Version)
+		}
+		return secrets.Blob{}, err
+	}
+	if err := secrets.VerifyDigest(v, data); err != nil {
+		return secrets.Blob{}, err
+	}
+	return secrets.Blob{
+		Name:                  name,
+		Ciphertext:            data,
+		Version:               v.Version,
+		RecipientSetHash:      v.RecipientSetHash,
+		RecipientFingerprints: v.RecipientFingerprints,
+		CreatedBy:             v.CreatedBy,
+		Source:                v.Source,
+		RestoredFrom:          v.RestoredFrom,
+	}, nil
+}
+
+// SaveSecret appends blob as a new immutable version. As with the Tigris
+// store, the conditional write of history.json is the commit point.
+func (s *Store) SaveSecret(ctx context.Context, blob secrets.Blob) (secrets.Version, error) {
+	if err := secrets.ValidateName(blob.Name); err != nil {
+		return secrets.Version{}, err
+	}
+	h, err := s.LoadSecretHistory(ctx, blob.Name)
+	if errors.Is(err, secrets.ErrSecretNotFound) {
+		h = secrets.NewHistory(blob.Name)
+	} else if err != nil {
+		return secrets.Version{}, err
+	}
+	v, err := h.Append(blob, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, secrets.ErrStaleVersion) {
+			return secrets.Version{}, &backend.ConflictError{Object: s.historyPath(blob.Name)}
+		}
+		return secrets.Version{}, err
+	}
+
+	if err := writeFileAtomic(s.versionPath(blob.Name, v.SHA256), blob.Ciphertext); err != nil {
+		return secrets.Version{}, err
+	}
+	if _, err := s.writeJSON(s.historyPath(blob.Name), h, h.Revision); err != nil {
+		return secrets.Version{}, err
+	}
+	if err := writeFileAtomic(s.secretPath(blob.Name), blob.Ciphertext); err != nil {
+		return secrets.Version{}, err
+	}
+	if err := s.recordManifest(ctx, blob.Name, v); err != nil {
+		return v, fmt.Errorf("%s v%d was saved but the manifest update failed: %w", blob.Name, v.Version, err)
+	}
+	return v, nil
+}
+
+// recordManifest holds the store lock across the read-modify-write, so unlike
+// the Tigris store it never needs to retry.
+func (s *Store) recordManifest(ctx context.Context, name string, v secrets.Version) error {
+	return s.withLock(func() error {
+		m, err := s.LoadManifest(ctx)
+		if err != nil {
+			return err
+		}
+		if !m.Record(name, v) {
+			return nil
+		}
+		data, err := marshalJSON(m)
+		if err != nil {
+			return err
+		}
+		return writeFileAtomic(s.manifestPath(), data)
+	})
+}
+
+func (s *Store) LoadManifest(ctx context.Context) (secrets.Manifest, error) {
+	var m secrets.Manifest
+	rev, err := readJSON(s.manifestPath(), &m)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return secrets.NewManifest(), nil
+		}
+		return secrets.Manifest{}, err
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	m.Revision = rev
+	return m, nil
+}
+
+func (s *Store) ListSecrets(ctx context.Context) ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return []string{}, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), secrets.BlobSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(e.Name(), secrets.BlobSuffix)
+		if secrets.ValidateName(name) != nil {
+			continue
+		}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// writeJSON writes v to path if the file's current revision still equals
+// revision (empty meaning the file must not exist yet). The check and the
+// write happen under the store lock.
+func (s *Store) writeJSON(path string, v any, revision string) (string, error) {
+	data, err := marshalJSON(v)
+	if err != nil {
+		return "", err
+	}
+	err = s.withLock(func() error {
+		current, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			if revision != "" {
+				return &backend.ConflictError{Object: path}
+			}
+		case err != nil:
+			return err
+		case revisionOf(current) != revision:
+			return &backend.ConflictError{Object: path}
+		}
+		return writeFileAtomic(path, data)
+	})
+	if err != nil {
+		return "", err
+	}
+	return revisionOf(data), nil
+}
+
+// withLock serializes conditional writes across processes with an
+// exclusively created lock file. Locks older than lockStaleAge are assumed to
+// belong to a crashed writer and are broken.
+func (s *Store) withLock(fn func() error) error {
+	if err := os.MkdirAll(s.metaDir(), 0o755); err != nil {
+		return err
+	}
+	lockPath := filepath.Join(s.metaDir(), lockName)
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			f.Close()
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > lockStaleAge {
+			_ = os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for lock %s (remove it if no other envlock process is running)", lockPath)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	defer os.Remove(lockPath)
+	return fn()
+}
+
+func readJSON(path string, dst any) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(data, dst); err != nil {
+		return "", fmt.Errorf("parse %s: %w", path, err)
+	}
+	return revisionOf(data), nil
+}
+
+func listJSON(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		out = append(out, filepath.Join(dir, e.Name()))
+	}
+	return out, nil
+}
+
+func marshalJSON(v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// revisionOf derives a revision from file contents, playing the role an ETag
+// plays for the Tigris store.
+func revisionOf(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	defer os.Remove(tmpName)
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, path)
+}
@@ -0,0 +1,304 @@
+// Package server implements backend.Store against the envlock server's
+// project resource API using a serverapi.Client that carries the CLI's login
+// session.
+//
+// Routes, all under /api/projects/{id}:
+//
+//	GET/PUT /recipients                 recipients.Store (ETag / If-Match)
+//	GET     /invites                    []enroll.Invite
+//	GET     /invites/{invite}           enroll.Invite
+//	GET     /requests                   []enroll.Request
+//	GET     /requests/{request}         enroll.Request
+//	GET     /secrets                    secrets.Manifest
+//	GET     /secrets/{name}?version=N   ciphertext (latest without ?version)
+//	PUT     /secrets/{name}             ciphertext + Envlock-* headers -> secrets.Version
+//	GET     /secrets/{name}/history     secrets.History
+//
+// Invites and requests are created and decided through the server's
+// enrollment API (serverapi.Client.CreateInvite and friends), not written as
+// objects, so SaveInvite and SaveRequest return ErrEnrollmentAPI.
+//
+// Recipient signature chains are not stored on the server, so
+// LoadRecipientChain and WriteRecipientChain return
+// ErrRecipientChainUnsupported.
+//
+// 409 and 412 responses map to *backend.ConflictError.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/core/serverapi"
+	"github.com/jasonchiu/envlock/feature/enroll"
+	"github.com/jasonchiu/envlock/feature/recipients"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+type Store struct {
+	client    *serverapi.Client
+	projectID string
+}
+
+// New returns a store for proj. client must point at proj's server and have
+// a login session (see serverapi.Client.UseSession).
+func New(proj config.Project, client *serverapi.Client) (*Store, error) {
+	if strings.TrimSpace(proj.Server) == "" {
+		return nil, errors.New("project server is required for the server backend")
+	}
+	projectID := strings.TrimSpace(proj.ProjectID)
+	if projectID == "" {
+		return nil, errors.New("project project_id is required for the server backend")
+	}
+	return &Store{client: client, projectID: projectID}, nil
+}
+
+func (s *Store) path(elem ...string) string {
+	return serverapi.ProjectPath(s.projectID, elem...)
+}
+
+// mapErr translates server status codes into backend and domain errors.
+// notFound is returned for 404 responses when non-nil.
+func mapErr(err error, object string, notFound error) error {
+	switch serverapi.StatusCode(err) {
+	case http.StatusNotFound:
+		if notFound != nil {
+			return notFound
+		}
+	case http.StatusConflict, http.StatusPreconditionFailed:
+		return &backend.ConflictError{Object: object}
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%w (run `envlock login`)", err)
+	}
+	return err
+}
+
+func (s *Store) LoadRecipients(ctx context.Context) (recipients.Store, error) {
+	var rs recipients.Store
+	etag, err := s.client.GetJSON(ctx, s.path("recipients"), &rs)
+	if err != nil {
+		if serverapi.StatusCode(err) == http.StatusNotFound {
+			return recipients.Store{Version: 1, Recipients: []recipients.Recipient{}}, nil
+		}
+		return recipients.Store{}, mapErr(err, "recipients", nil)
+	}
+	if rs.Version == 0 {
+		rs.Version = 1
+	}
+	rs.Revision = etag
+	return rs, nil
+}
+
+func (s *Store) WriteRecipients(ctx context.Context, rs recipients.Store) error {
+	if rs.Version == 0 {
+		rs.Version = 1
+	}
+	_, err := s.client.PutJSON(ctx, s.path("recipients"), rs, rs.Revision)
+	return mapErr(err, "recipients", nil)
+}
+
+// ErrRecipientChainUnsupported is returned for recipient signature chains:
+// the server only accepts recipient changes from project admins, so server
+// projects do not pin a trust root.
+var ErrRecipientChainUnsupported = errors.New("server projects do not support signed recipient sets; the server authorizes recipient changes")
+
+func (s *Store) LoadRecipientChain(ctx context.Context) (recipients.Chain, error) {
+	return recipients.Chain{}, ErrRecipientChainUnsupported
+}
+
+func (s *Store) WriteRecipientChain(ctx context.Context, c recipients.Chain) error {
+	return ErrRecipientChainUnsupported
+}
+
+// ErrEnrollmentAPI is returned for invite and request writes, which the
+// server only accepts through its enrollment endpoints.
+var ErrEnrollmentAPI = errors.New("server projects create and decide invites and requests through the enrollment API")
+
+func (s *Store) SaveInvite(ctx context.Context, invite enroll.Invite) error {
+	return ErrEnrollmentAPI
+}
+
+func (s *Store) LoadInvite(ctx context.Context, id string) (enroll.Invite, error) {
+	id = strings.TrimSpace(id)
+	var inv enroll.Invite
+	etag, err := s.client.GetJSON(ctx, s.path("invites", id), &inv)
+	if err != nil {
+		return enroll.Invite{}, mapErr(err, "invite "+id, enroll.ErrInviteNotFound)
+	}
+	if inv.Version == 0 {
+		inv.Version = 1
+	}
+	inv.Revision = etag
+	return inv, nil
+}
+
+// ListInvites returns invites without revisions; reload one with LoadInvite
+// before updating it.
+func (s *Store) ListInvites(ctx context.Context) ([]enroll.Invite, error) {
+	var out []enroll.Invite
+	if _, err := s.client.GetJSON(ctx, s.path("invites"), &out); err != nil {
+		return nil, mapErr(err, "invites", nil)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *Store) SaveRequest(ctx context.Context, req enroll.Request) error {
+	return ErrEnrollmentAPI
+}
+
+func (s *Store) LoadRequest(ctx context.Context, id string) (enroll.Request, error) {
+	id = strings.TrimSpace(id)
+	var req enroll.Request
+	etag, err := s.client.GetJSON(ctx, s.path("requests", id), &req)
+	if err != nil {
+		return enroll.Request{}, mapErr(err, "request "+id, enroll.ErrRequestNotFound)
+	}
+	if req.Version == 0 {
+		req.Version = 1
+	}
+	req.Revision = etag
+	return req, nil
+}
+
+// ListRequests returns requests without revisions; reload one with
+// LoadRequest before updating it.
+func (s *Store) ListRequests(ctx context.Context) ([]enroll.Request, error) {
+	var out []enroll.Request
+	if _, err := s.client.GetJSON(ctx, s.path("requests"), &out); err != nil {
+		return nil, mapErr(err, "requests", nil)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
+	return out, nil
+}
+
+func (s *Store) LoadSecretHistory(ctx context.Context, name string) (secrets.History, error) {
+	if err := secrets.ValidateName(name); err != nil {
+		return secrets.History{}, err
+	}
+	var h secrets.History
+	etag, err := s.client.GetJSON(ctx, s.path("secrets", name, "history"), &h)
+	if err != nil {
+		return secrets.History{}, mapErr(err, name, secrets.ErrSecretNotFound)
+	}
+	if h.Version == 0 {
+		h.Version = 1
+	}
+	h.Revision = etag
+	return h, nil
+}
+
+func (s *Store) LoadSecret(ctx context.Context, name string) (secrets.Blob, error) {
+	h, err := s.LoadSecretHistory(ctx, name)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	latest, ok := h.Latest()
+	if !ok {
+		return secrets.Blob{}, secrets.ErrSecretNotFound
+	}
+	return s.loadVersion(ctx, name, latest)
+}
+
+func (s *Store) LoadSecretVersion(ctx context.Context, name string, version int) (secrets.Blob, error) {
+	h, err := s.LoadSecretHistory(ctx, name)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	v, err := h.Get(version)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	return s.loadVersion(ctx, name, v)
+}
+
+// loadVersion downloads one version and checks it against the digest in the
+// history, so a misbehaving server cannot substitute ciphertext unnoticed.
+func (s *Store) loadVersion(ctx context.Context, name string, v secrets.Version) (secrets.Blob, error) {
+	p := s.path("secrets", name) + "?version=" + strconv.Itoa(v.Version)
+	data, _, err := s.client.GetBlob(ctx, p)
+	if err != nil {
+		notFound := fmt.Errorf("%w: %s v%d object missing", secrets.ErrVersionNotFound, name, v.Version)
+		return secrets.Blob{}, mapErr(err, name, notFound)
+	}
+	if err := secrets.VerifyDigest(v, data); err != nil {
+		return secrets.Blob{}, err
+	}
+	return secrets.Blob{
+		Name:                  name,
+		Ciphertext:            data,
+		Version:               v.Version,
+		RecipientSetHash:      v.RecipientSetHash,
+		RecipientFingerprints: v.RecipientFingerprints,
+		CreatedBy:             v.CreatedBy,
+		Source:                v.Source,
+		RestoredFrom:          v.RestoredFrom,
+	}, nil
+}
+
+// SaveSecret uploads blob as the version after blob.Version. The server
+// rejects the upload with 409 if another version landed first.
+func (s *Store) SaveSecret(ctx context.Context, blob secrets.Blob) (secrets.Version, error) {
+	if err := secrets.ValidateName(blob.Name); err != nil {
+		return secrets.Version{}, err
+	}
+	meta := http.Header{}
+	meta.Set(serverapi.HeaderBaseVersion, strconv.Itoa(blob.Version))
+	meta.Set(serverapi.HeaderSHA256, secrets.Digest(blob.Ciphertext))
+	meta.Set(serverapi.HeaderSize, strconv.Itoa(len(blob.Ciphertext)))
+	meta.Set(serverapi.HeaderRecipientSet, blob.RecipientSetHash)
+	meta.Set(serverapi.HeaderRecipients, strings.Join(blob.RecipientFingerprints, ","))
+	if blob.CreatedBy != "" {
+		meta.Set(serverapi.HeaderCreatedBy, blob.CreatedBy)
+	}
+	if blob.Source != "" {
+		meta.Set(serverapi.HeaderSource, blob.Source)
+	}
+	if blob.RestoredFrom != 0 {
+		meta.Set(serverapi.HeaderRestoredFrom, strconv.Itoa(blob.RestoredFrom))
+	}
+	var v secrets.Version
+	if err := s.client.PutBlob(ctx, s.path("secrets", blob.Name), blob.Ciphertext, meta, &v); err != nil {
+		return secrets.Version{}, mapErr(err, blob.Name, nil)
+	}
+	return v, nil
+}
+
+func (s *Store) LoadManifest(ctx context.Context) (secrets.Manifest, error) {
+	var m secrets.Manifest
+	etag, err := s.client.GetJSON(ctx, s.path("secrets"), &m)
+	if err != nil {
+		if serverapi.StatusCode(err) == http.StatusNotFound {
+			return secrets.NewManifest(), nil
+		}
+		return secrets.Manifest{}, mapErr(err, "manifest", nil)
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	if m.Secrets == nil {
+		m.Secrets = []secrets.ManifestEntry{}
+	}
+	m.Revision = etag
+	return m, nil
+}
+
+func (s *Store) ListSecrets(ctx context.Context) ([]string, error) {
+	m, err := s.LoadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, 0, len(m.Secrets))
+	for _, e := range m.Secrets {
+		out = append(out, e.Name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
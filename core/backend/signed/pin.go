@@ -0,0 +1,95 @@
+package signed
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+
+	"github.com/jasonchiu/envlock/feature/recipients"
+)
+
+// A Pin is the checkpoint file where this device keeps the newest chain link
+// it has verified. Signatures alone cannot tell an old recipients.json and
+// its old chain from the current pair; the pin can, so a rollback that would
+// bring back a revoked device is refused.
+type Pin struct {
+	path string
+}
+
+// NewPin returns the pin stored at path. An empty path disables the check.
+func NewPin(path string) *Pin {
+	return &Pin{path: path}
+}
+
+func (p *Pin) load() (recipients.Checkpoint, error) {
+	var cp recipients.Checkpoint
+	if p == nil || p.path == "" {
+		return cp, nil
+	}
+	b, err := os.ReadFile(p.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	if err := json.Unmarshal(b, &cp); err != nil {
+		return cp, err
+	}
+	return cp, nil
+}
+
+func (p *Pin) save(cp recipients.Checkpoint) error {
+	if p == nil || p.path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p.path), 0o700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cp, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := p.path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.path)
+}
+
+// Check returns recipients.ErrRolledBack if c does not extend the pinned
+// link. Callers verify c's signatures first.
+func (p *Pin) Check(c recipients.Chain) error {
+	cp, err := p.load()
+	if err != nil {
+		return err
+	}
+	return c.Extends(cp)
+}
+
+// Advance checks c and then pins its head if it is newer.
+func (p *Pin) Advance(c recipients.Chain) error {
+	cp, err := p.load()
+	if err != nil {
+		return err
+	}
+	if err := c.Extends(cp); err != nil {
+		return err
+	}
+	head, ok := c.Head()
+	if !ok || (cp.Hash != "" && head.Seq <= cp.Seq) {
+		return nil
+	}
+	return p.save(head.Checkpoint())
+}
+
+// Reset pins c's head even if it does not extend the pinned link, for a
+// chain deliberately replaced by `envlock trust init --force`.
+func (p *Pin) Reset(c recipients.Chain) error {
+	head, ok := c.Head()
+	if !ok {
+		return nil
+	}
+	return p.save(head.Checkpoint())
+}
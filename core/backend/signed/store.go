@@ -0,0 +1,163 @@
+// Package signed wraps a backend.Store for projects that pin a trust root in
+// project.toml. Recipient loads are verified against the signature chain
+// stored next to recipients.json, so a recipient added by anyone who merely
+// has write access to the backend is refused before anything is encrypted to
+// it. Recipient writes append a link signed with this device's admin key.
+// Every verified chain must also extend the newest one this device has seen
+// (see Pin), so an older, validly signed recipient set cannot be put back.
+//
+// recipients.json and its chain are separate objects: a reader racing an
+// admin's write can briefly see ErrSetMismatch and should retry.
+package signed
+
+import (
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/recipients"
+)
+
+// chainRetries bounds how often the chain append is replayed when another
+// admin appends concurrently.
+const chainRetries = 5
+
+// Signer returns the admin key recipient writes are signed with. It is only
+// called for writes.
+type Signer func() (ed25519.PrivateKey, error)
+
+type Store struct {
+	backend.Store
+	root   string
+	signer Signer
+	pin    *Pin
+}
+
+func New(inner backend.Store, root string, signer Signer, pin *Pin) *Store {
+	return &Store{Store: inner, root: root, signer: signer, pin: pin}
+}
+
+// VerificationError reports a recipient set that failed its signature check.
+type VerificationError struct {
+	Err error
+}
+
+func (e *VerificationError) Error() string {
+	return fmt.Sprintf("recipients failed the signature check against trust_root in project.toml: %v", e.Err)
+}
+
+func (e *VerificationError) Unwrap() error {
+	return e.Err
+}
+
+// Unverified returns the wrapped store, for inspecting and repairing a
+// recipient set that fails verification.
+func (s *Store) Unverified() backend.Store {
+	return s.Store
+}
+
+// LoadRecipients returns the recipient set only if the chain's latest link
+// signs it and the chain extends the pinned one. The pin then moves to the
+// chain's head.
+func (s *Store) LoadRecipients(ctx context.Context) (recipients.Store, error) {
+	rs, err := s.Store.LoadRecipients(ctx)
+	if err != nil {
+		return recipients.Store{}, err
+	}
+	c, err := s.Store.LoadRecipientChain(ctx)
+	if err != nil {
+		return recipients.Store{}, err
+	}
+	if _, err := c.Verify(s.root, rs); err != nil {
+		return recipients.Store{}, &VerificationError{Err: err}
+	}
+	if err := s.advance(c); err != nil {
+		return recipients.Store{}, err
+	}
+	return rs, nil
+}
+
+// verifyLinks checks c's signatures and that it extends the pin.
+func (s *Store) verifyLinks(c recipients.Chain) error {
+	if _, err := c.VerifyLinks(s.root); err != nil {
+		return &VerificationError{Err: err}
+	}
+	if err := s.pin.Check(c); err != nil {
+		if errors.Is(err, recipients.ErrRolledBack) {
+			return &VerificationError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *Store) advance(c recipients.Chain) error {
+	if err := s.pin.Advance(c); err != nil {
+		if errors.Is(err, recipients.ErrRolledBack) {
+			return &VerificationError{Err: err}
+		}
+		return err
+	}
+	return nil
+}
+
+// WriteRecipients writes rs and then signs its active set. The signer is
+// checked against the chain before rs is written, so a non-admin write fails
+// without touching recipients.json.
+func (s *Store) WriteRecipients(ctx context.Context, rs recipients.Store) error {
+	priv, err := s.signer()
+	if err != nil {
+		return err
+	}
+	c, err := s.Store.LoadRecipientChain(ctx)
+	if err != nil {
+		return err
+	}
+	if err := s.verifyLinks(c); err != nil {
+		return err
+	}
+	if !c.CanSign(s.root, keys.AdminPublicKey(priv)) {
+		return fmt.Errorf("%w: recipient changes in this project must be signed by an admin", recipients.ErrSignerNotAdmin)
+	}
+	if err := s.Store.WriteRecipients(ctx, rs); err != nil {
+		return err
+	}
+	return s.sign(ctx, priv, rs.ActiveSetHash(), c)
+}
+
+// sign appends a link for setHash, reloading the chain if another admin
+// appended first. A head that already signs setHash is left alone.
+func (s *Store) sign(ctx context.Context, priv ed25519.PrivateKey, setHash string, c recipients.Chain) error {
+	var err error
+	for attempt := 0; attempt < chainRetries; attempt++ {
+		if attempt > 0 {
+			if c, err = s.Store.LoadRecipientChain(ctx); err != nil {
+				break
+			}
+			if err = s.verifyLinks(c); err != nil {
+				break
+			}
+		}
+		if head, ok := c.Head(); ok && head.SetHash == setHash {
+			return s.advance(c)
+		}
+		if _, err = c.Append(s.root, priv, setHash, nil, "", time.Now().UTC()); err != nil {
+			break
+		}
+		err = s.Store.WriteRecipientChain(ctx, c)
+		if err == nil {
+			return s.advance(c)
+		}
+		if !errors.Is(err, backend.ErrConflict) {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("recipients were written but not signed (run `envlock trust sign`): %w", err)
+	}
+	return nil
+}
@@ -2,17 +2,27 @@ package backend
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/jasonchiu/envlock/feature/enroll"
 	"github.com/jasonchiu/envlock/feature/recipients"
+	"github.com/jasonchiu/envlock/feature/secrets"
 )
 
 // Store is the minimal remote metadata interface used by the current CLI.
 // It is intentionally small so we can swap Tigris for a server-backed API
 // implementation without rewriting command handlers all at once.
+//
+// Writes are optimistic: each value carries the Revision it was loaded at
+// (empty for new values) and implementations reject the write with a
+// *ConflictError if the stored revision has moved on. Secret blobs use their
+// monotonic Version for the same purpose.
 type Store interface {
 	LoadRecipients(ctx context.Context) (recipients.Store, error)
 	WriteRecipients(ctx context.Context, rs recipients.Store) error
+	LoadRecipientChain(ctx context.Context) (recipients.Chain, error)
+	WriteRecipientChain(ctx context.Context, c recipients.Chain) error
 
 	SaveInvite(ctx context.Context, invite enroll.Invite) error
 	LoadInvite(ctx context.Context, id string) (enroll.Invite, error)
@@ -21,4 +31,29 @@ type Store interface {
 	SaveRequest(ctx context.Context, req enroll.Request) error
 	LoadRequest(ctx context.Context, id string) (enroll.Request, error)
 	ListRequests(ctx context.Context) ([]enroll.Request, error)
+
+	LoadSecret(ctx context.Context, name string) (secrets.Blob, error)
+	LoadSecretVersion(ctx context.Context, name string, version int) (secrets.Blob, error)
+	LoadSecretHistory(ctx context.Context, name string) (secrets.History, error)
+	SaveSecret(ctx context.Context, blob secrets.Blob) (secrets.Version, error)
+	ListSecrets(ctx context.Context) ([]string, error)
+	LoadManifest(ctx context.Context) (secrets.Manifest, error)
+}
+
+// ErrConflict reports that a conditional write lost a race with another
+// writer. Callers should reload, reapply their change and retry.
+var ErrConflict = errors.New("remote object changed concurrently")
+
+// ConflictError is the typed form of ErrConflict returned by Store
+// implementations; Object names what was being written.
+type ConflictError struct {
+	Object string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("%s was modified by another writer", e.Object)
+}
+
+func (e *ConflictError) Is(target error) bool {
+	return target == ErrConflict
 }
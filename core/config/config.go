@@ -2,6 +2,7 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -11,12 +12,47 @@ import (
 
 var ErrProjectNotFound = errors.New("envlock project config not found")
 
+const (
+	BackendTigris  = "tigris"
+	BackendLocalFS = "localfs"
+	BackendServer  = "server"
+)
+
 type Project struct {
 	Version  int    `toml:"version"`
 	AppName  string `toml:"app_name"`
-	Bucket   string `toml:"bucket"`
+	Backend  string `toml:"backend,omitempty"`
+	Bucket   string `toml:"bucket,omitempty"`
 	Prefix   string `toml:"prefix"`
 	Endpoint string `toml:"endpoint,omitempty"`
+	// Path is the localfs backend root. Relative paths are resolved against
+	// the project root (the directory containing .envlock/).
+	Path string `toml:"path,omitempty"`
+	// Server and ProjectID select a project on an envlock server.
+	Server    string `toml:"server,omitempty"`
+	ProjectID string `toml:"project_id,omitempty"`
+	// TrustRoot is the admin public key (ed25519:...) that anchors the
+	// recipients signature chain. When set, recipients are verified before
+	// anything is encrypted to them.
+	TrustRoot string `toml:"trust_root,omitempty"`
+}
+
+// BackendName returns the configured storage backend. Without an explicit
+// backend, a project naming a server uses it and anything else is Tigris
+// (project files written before backends were selectable).
+func (p Project) BackendName() string {
+	if b := strings.ToLower(strings.TrimSpace(p.Backend)); b != "" {
+		return b
+	}
+	if strings.TrimSpace(p.Server) != "" {
+		return BackendServer
+	}
+	return BackendTigris
+}
+
+// ProjectRoot returns the directory containing .envlock/ for a project file.
+func ProjectRoot(projectFilePath string) string {
+	return filepath.Dir(filepath.Dir(projectFilePath))
 }
 
 func DefaultPrefix(appName string) string {
@@ -31,6 +67,12 @@ func ProjectFilePath(base string) string {
 	return filepath.Join(ProjectDirPath(base), "project.toml")
 }
 
+// RecipientsFilePath is the local recipients file used by the offline
+// encrypt/decrypt commands. Remote flows keep recipients in the backend.
+func RecipientsFilePath(base string) string {
+	return filepath.Join(ProjectDirPath(base), "recipients.json")
+}
+
 func WriteProject(path string, p Project) error {
 	if p.Version == 0 {
 		p.Version = 1
@@ -38,8 +80,21 @@ func WriteProject(path string, p Project) error {
 	if strings.TrimSpace(p.AppName) == "" {
 		return errors.New("project app_name is required")
 	}
-	if strings.TrimSpace(p.Bucket) == "" {
-		return errors.New("project bucket is required")
+	switch p.BackendName() {
+	case BackendTigris:
+		if strings.TrimSpace(p.Bucket) == "" {
+			return errors.New("project bucket is required")
+		}
+	case BackendLocalFS:
+		if strings.TrimSpace(p.Path) == "" {
+			return errors.New("project path is required for the localfs backend")
+		}
+	case BackendServer:
+		if strings.TrimSpace(p.Server) == "" || strings.TrimSpace(p.ProjectID) == "" {
+			return errors.New("project server and project_id are required for the server backend")
+		}
+	default:
+		return fmt.Errorf("unknown project backend %q", p.Backend)
 	}
 	if strings.TrimSpace(p.Prefix) == "" {
 		p.Prefix = DefaultPrefix(p.AppName)
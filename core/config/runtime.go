@@ -7,14 +7,34 @@ import (
 	"time"
 )
 
+// Identity providers for browser approval of CLI logins. The dev provider
+// signs everyone in as DevUserEmail and is meant for local development only.
+const (
+	IdentityProviderDev  = "dev"
+	IdentityProviderOIDC = "oidc"
+)
+
 type Runtime struct {
-	Addr              string
-	BaseURL           string
-	DevUserEmail      string
-	DevUserDisplay    string
-	CLILoginCodeTTL   time.Duration
-	AccessTokenTTL    time.Duration
-	RefreshTokenTTL   time.Duration
+	Addr               string
+	BaseURL            string
+	DBPath             string
+	BlobDir            string
+	AdminEmails        []string
+	IdentityProvider   string
+	OIDCIssuer         string
+	OIDCClientID       string
+	OIDCClientSecret   string
+	OIDCAllowedDomains []string
+	DevUserEmail       string
+	DevUserDisplay     string
+	CLILoginCodeTTL    time.Duration
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
+	AccessTokenTTL     time.Duration
+	RefreshTokenTTL    time.Duration
+	WebSessionTTL      time.Duration
+	// DevAutoApproveCLI skips the login confirmation pages. It is only
+	// honored with the dev identity provider.
 	DevAutoApproveCLI bool
 }
 
@@ -29,16 +49,63 @@ func Load() Runtime {
 		baseURL = "http://" + addr
 	}
 
+	oidcIssuer := strings.TrimSpace(os.Getenv("ENVLOCK_SERVER_OIDC_ISSUER"))
+	idp := IdentityProviderDev
+	if oidcIssuer != "" {
+		idp = IdentityProviderOIDC
+	}
+
 	return Runtime{
-		Addr:              addr,
-		BaseURL:           baseURL,
-		DevUserEmail:      envOrDefault("ENVLOCK_SERVER_DEV_USER_EMAIL", "dev@example.com"),
-		DevUserDisplay:    envOrDefault("ENVLOCK_SERVER_DEV_USER_NAME", "Envlock Dev User"),
-		CLILoginCodeTTL:   durationOrDefault("ENVLOCK_SERVER_CLI_CODE_TTL_SEC", 300),
-		AccessTokenTTL:    durationOrDefault("ENVLOCK_SERVER_ACCESS_TTL_SEC", 3600),
-		RefreshTokenTTL:   durationOrDefault("ENVLOCK_SERVER_REFRESH_TTL_SEC", 86400),
-		DevAutoApproveCLI: boolOrDefault("ENVLOCK_SERVER_DEV_AUTO_APPROVE_CLI_LOGIN", true),
+		Addr:               addr,
+		BaseURL:            baseURL,
+		DBPath:             envOrDefault("ENVLOCK_SERVER_DB_PATH", "data/envlock.db"),
+		BlobDir:            envOrDefault("ENVLOCK_SERVER_BLOB_DIR", "data/blobs"),
+		AdminEmails:        listOrEmpty("ENVLOCK_SERVER_ADMIN_EMAILS"),
+		IdentityProvider:   strings.ToLower(envOrDefault("ENVLOCK_SERVER_IDENTITY_PROVIDER", idp)),
+		OIDCIssuer:         oidcIssuer,
+		OIDCClientID:       strings.TrimSpace(os.Getenv("ENVLOCK_SERVER_OIDC_CLIENT_ID")),
+		OIDCClientSecret:   strings.TrimSpace(os.Getenv("ENVLOCK_SERVER_OIDC_CLIENT_SECRET")),
+		OIDCAllowedDomains: listOrEmpty("ENVLOCK_SERVER_OIDC_ALLOWED_DOMAINS"),
+		DevUserEmail:       envOrDefault("ENVLOCK_SERVER_DEV_USER_EMAIL", "dev@example.com"),
+		DevUserDisplay:     envOrDefault("ENVLOCK_SERVER_DEV_USER_NAME", "Envlock Dev User"),
+		CLILoginCodeTTL:    durationOrDefault("ENVLOCK_SERVER_CLI_CODE_TTL_SEC", 300),
+		DeviceCodeTTL:      durationOrDefault("ENVLOCK_SERVER_DEVICE_CODE_TTL_SEC", 600),
+		DevicePollInterval: durationOrDefault("ENVLOCK_SERVER_DEVICE_POLL_INTERVAL_SEC", 5),
+		AccessTokenTTL:     durationOrDefault("ENVLOCK_SERVER_ACCESS_TTL_SEC", 3600),
+		RefreshTokenTTL:    durationOrDefault("ENVLOCK_SERVER_REFRESH_TTL_SEC", 86400),
+		WebSessionTTL:      durationOrDefault("ENVLOCK_SERVER_WEB_SESSION_TTL_SEC", 43200),
+		DevAutoApproveCLI:  boolOrDefault("ENVLOCK_SERVER_DEV_AUTO_APPROVE_CLI_LOGIN", idp == IdentityProviderDev),
+	}
+}
+
+// SecureCookies reports whether browsers reach the server over https, so
+// session cookies can be marked Secure.
+func (r Runtime) SecureCookies() bool {
+	return strings.HasPrefix(r.BaseURL, "https://")
+}
+
+// IsAdmin reports whether email is listed in ENVLOCK_SERVER_ADMIN_EMAILS.
+func (r Runtime) IsAdmin(email string) bool {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return false
+	}
+	for _, a := range r.AdminEmails {
+		if strings.EqualFold(a, email) {
+			return true
+		}
+	}
+	return false
+}
+
+func listOrEmpty(envKey string) []string {
+	var out []string
+	for _, v := range strings.Split(os.Getenv(envKey), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
 	}
+	return out
 }
 
 func envOrDefault(key, fallback string) string {
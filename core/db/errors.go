@@ -0,0 +1,23 @@
+package db
+
+import (
+	"errors"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// IsWriteConflict reports whether err is a concurrent writer winning a race:
+// a primary key or unique constraint violation, or the database staying busy
+// past the busy timeout.
+func IsWriteConflict(err error) bool {
+	var se *sqlite.Error
+	if !errors.As(err, &se) {
+		return false
+	}
+	switch se.Code() {
+	case sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY, sqlite3.SQLITE_CONSTRAINT_UNIQUE:
+		return true
+	}
+	return se.Code()&0xff == sqlite3.SQLITE_BUSY
+}
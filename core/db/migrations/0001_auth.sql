@@ -0,0 +1,46 @@
+-- Users and CLI login state. Times are unix milliseconds (UTC). Login codes
+-- and tokens are stored as sha256 hex hashes only.
+
+CREATE TABLE users (
+	id TEXT PRIMARY KEY,
+	email TEXT NOT NULL,
+	display_name TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE INDEX users_email ON users (email);
+
+CREATE TABLE cli_pending_logins (
+	state TEXT PRIMARY KEY,
+	callback_url TEXT NOT NULL DEFAULT '',
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE TABLE cli_login_codes (
+	code_hash TEXT PRIMARY KEY,
+	state TEXT NOT NULL,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_at INTEGER
+);
+
+CREATE TABLE refresh_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE TABLE access_tokens (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	refresh_token_hash TEXT REFERENCES refresh_tokens (token_hash) ON DELETE CASCADE,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX access_tokens_user ON access_tokens (user_id);
+CREATE INDEX refresh_tokens_user ON refresh_tokens (user_id);
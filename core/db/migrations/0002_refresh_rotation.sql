@@ -0,0 +1,13 @@
+-- Refresh-token rotation. Every token issued from one login shares a
+-- family_id; a refresh token is single-use (used_at) and reuse of a rotated
+-- token revokes the whole family.
+
+ALTER TABLE refresh_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN used_at INTEGER;
+ALTER TABLE access_tokens ADD COLUMN family_id TEXT NOT NULL DEFAULT '';
+
+UPDATE refresh_tokens SET family_id = token_hash WHERE family_id = '';
+UPDATE access_tokens SET family_id = COALESCE(refresh_token_hash, token_hash) WHERE family_id = '';
+
+CREATE INDEX refresh_tokens_family ON refresh_tokens (family_id);
+CREATE INDEX access_tokens_family ON access_tokens (family_id);
@@ -0,0 +1,13 @@
+-- Device authorization grant. Only a hash of the device code is stored; the
+-- short user code is shown to the user and is looked up directly.
+
+CREATE TABLE cli_device_logins (
+	device_code_hash TEXT PRIMARY KEY,
+	user_code TEXT NOT NULL UNIQUE,
+	status TEXT NOT NULL,
+	user_id TEXT REFERENCES users (id) ON DELETE CASCADE,
+	interval_ms INTEGER NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	last_polled_at INTEGER
+);
@@ -0,0 +1,7 @@
+-- PKCE for the loopback login. The S256 challenge sent on login start is
+-- copied onto the issued code, since the pending login is deleted on
+-- exchange. Rows from before this migration have no challenge and can no
+-- longer be exchanged.
+
+ALTER TABLE cli_pending_logins ADD COLUMN code_challenge TEXT NOT NULL DEFAULT '';
+ALTER TABLE cli_login_codes ADD COLUMN code_challenge TEXT NOT NULL DEFAULT '';
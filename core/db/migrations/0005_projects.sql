@@ -0,0 +1,21 @@
+-- Server projects and their members. Every project keeps at least one admin;
+-- the creator starts as its admin.
+
+CREATE TABLE projects (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL UNIQUE,
+	created_by TEXT NOT NULL REFERENCES users (id),
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE project_members (
+	project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	role TEXT NOT NULL CHECK (role IN ('admin', 'member')),
+	created_at INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL,
+	PRIMARY KEY (project_id, user_id)
+);
+
+CREATE INDEX project_members_user ON project_members (user_id);
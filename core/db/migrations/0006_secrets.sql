@@ -0,0 +1,19 @@
+-- Pushed secret versions. The server only stores ciphertext metadata; the
+-- ciphertext itself is a content-addressed file under the blob directory.
+-- Versions are immutable and the latest row per secret is the current one.
+
+CREATE TABLE secret_versions (
+	project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	version INTEGER NOT NULL,
+	sha256 TEXT NOT NULL,
+	size_bytes INTEGER NOT NULL,
+	recipient_set_hash TEXT NOT NULL DEFAULT '',
+	recipient_fingerprints TEXT NOT NULL DEFAULT '',
+	created_by TEXT NOT NULL DEFAULT '',
+	source TEXT NOT NULL DEFAULT '',
+	restored_from INTEGER NOT NULL DEFAULT 0,
+	uploaded_by TEXT NOT NULL REFERENCES users (id),
+	created_at INTEGER NOT NULL,
+	PRIMARY KEY (project_id, name, version)
+);
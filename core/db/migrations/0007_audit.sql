@@ -0,0 +1,19 @@
+-- Append-only audit log. project_id is deliberately not a foreign key so a
+-- project's history survives its deletion; server-wide events (logins) have
+-- an empty project_id.
+
+CREATE TABLE audit_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	created_at INTEGER NOT NULL,
+	action TEXT NOT NULL,
+	project_id TEXT NOT NULL DEFAULT '',
+	actor_id TEXT NOT NULL DEFAULT '',
+	actor_email TEXT NOT NULL DEFAULT '',
+	device TEXT NOT NULL DEFAULT '',
+	target TEXT NOT NULL DEFAULT '',
+	detail TEXT NOT NULL DEFAULT '',
+	remote_addr TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX audit_events_project ON audit_events (project_id, id);
+CREATE INDEX audit_events_actor ON audit_events (actor_email, id);
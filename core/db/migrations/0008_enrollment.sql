@@ -0,0 +1,43 @@
+-- Server-side enrollment. Recipients are kept as the same JSON document the
+-- other backends store, versioned by revision for If-Match writes. Invites
+-- store only a hash of the token secret; join requests record the server
+-- user who submitted them.
+
+CREATE TABLE project_recipients (
+	project_id TEXT PRIMARY KEY REFERENCES projects (id) ON DELETE CASCADE,
+	doc TEXT NOT NULL,
+	revision INTEGER NOT NULL,
+	updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE enroll_invites (
+	id TEXT PRIMARY KEY,
+	project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+	secret_hash TEXT NOT NULL,
+	status TEXT NOT NULL,
+	created_by TEXT NOT NULL DEFAULT '',
+	created_by_user TEXT NOT NULL REFERENCES users (id),
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL,
+	used_by_request_id TEXT NOT NULL DEFAULT '',
+	used_at INTEGER
+);
+
+CREATE INDEX enroll_invites_project ON enroll_invites (project_id, created_at);
+
+CREATE TABLE enroll_requests (
+	id TEXT PRIMARY KEY,
+	project_id TEXT NOT NULL REFERENCES projects (id) ON DELETE CASCADE,
+	invite_id TEXT NOT NULL REFERENCES enroll_invites (id) ON DELETE CASCADE,
+	user_id TEXT NOT NULL REFERENCES users (id),
+	status TEXT NOT NULL,
+	device_name TEXT NOT NULL,
+	public_key TEXT NOT NULL,
+	fingerprint TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	decision_at INTEGER,
+	decision_note TEXT NOT NULL DEFAULT '',
+	decided_by TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX enroll_requests_project ON enroll_requests (project_id, created_at);
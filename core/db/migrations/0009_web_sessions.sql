@@ -0,0 +1,12 @@
+-- Browser sessions for the admin web UI. Only the hash of the session cookie
+-- is stored; csrf_token is echoed by every form the session submits.
+
+CREATE TABLE web_sessions (
+	token_hash TEXT PRIMARY KEY,
+	user_id TEXT NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+	csrf_token TEXT NOT NULL,
+	created_at INTEGER NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX web_sessions_user ON web_sessions (user_id);
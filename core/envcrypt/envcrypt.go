@@ -0,0 +1,58 @@
+package envcrypt
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"filippo.io/age"
+)
+
+var ErrNoRecipients = errors.New("no active recipients to encrypt to")
+
+// Encrypt encrypts plaintext once and wraps the file key for every recipient
+// public key. The payload is the raw input bytes, so comments, ordering and
+// trailing newlines in a .env file survive a round-trip unchanged.
+func Encrypt(plaintext []byte, publicKeys []string) ([]byte, error) {
+	if len(publicKeys) == 0 {
+		return nil, ErrNoRecipients
+	}
+	rcpts := make([]age.Recipient, 0, len(publicKeys))
+	for _, pub := range publicKeys {
+		r, err := age.ParseX25519Recipient(strings.TrimSpace(pub))
+		if err != nil {
+			return nil, fmt.Errorf("parse recipient %q: %w", pub, err)
+		}
+		rcpts = append(rcpts, r)
+	}
+
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, rcpts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func Decrypt(ciphertext []byte, identity age.Identity) ([]byte, error) {
+	if identity == nil {
+		return nil, errors.New("missing identity")
+	}
+	r, err := age.Decrypt(bytes.NewReader(ciphertext), identity)
+	if err != nil {
+		var noMatch *age.NoIdentityMatchError
+		if errors.As(err, &noMatch) {
+			return nil, errors.New("this device is not a recipient of the encrypted blob (ask an admin to approve and rekey)")
+		}
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
@@ -0,0 +1,97 @@
+package keys
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Admin keys are Ed25519 signing keys kept next to the age identity of the
+// same profile (<name>.adminkey beside <name>.agekey). They sign the project
+// recipient set; they never decrypt anything.
+
+const (
+	adminPublicPrefix = "ed25519:"
+	adminSecretPrefix = "ENVLOCK-ADMIN-SECRET-KEY-"
+)
+
+// AdminKeyPath returns the admin key file for a key profile.
+func AdminKeyPath(name string) (string, error) {
+	path, err := DefaultKeyPath(name)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(path, ".agekey") + ".adminkey", nil
+}
+
+func GenerateAdminKey() (ed25519.PrivateKey, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	return priv, err
+}
+
+func WriteAdminKey(path string, priv ed25519.PrivateKey, force bool) error {
+	if len(priv) != ed25519.PrivateKeySize {
+		return errors.New("missing admin key")
+	}
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("admin key already exists at %s", path)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	content := fmt.Sprintf("# envlock-admin-public: %s\n%s%s\n",
+		AdminPublicKey(priv), adminSecretPrefix, base64.RawURLEncoding.EncodeToString(priv.Seed()))
+	return os.WriteFile(path, []byte(content), 0o600)
+}
+
+func LoadAdminKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := bufio.NewScanner(strings.NewReader(string(data)))
+	for s.Scan() {
+		line := strings.TrimSpace(s.Text())
+		if !strings.HasPrefix(line, adminSecretPrefix) {
+			continue
+		}
+		seed, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(line, adminSecretPrefix))
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return nil, errors.New("malformed admin secret key")
+		}
+		return ed25519.NewKeyFromSeed(seed), nil
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("no " + adminSecretPrefix + " found")
+}
+
+// AdminPublicKey returns the shareable form of priv's public key, as pinned
+// in project.toml and listed in recipient signatures.
+func AdminPublicKey(priv ed25519.PrivateKey) string {
+	pub := priv.Public().(ed25519.PublicKey)
+	return adminPublicPrefix + base64.RawURLEncoding.EncodeToString(pub)
+}
+
+func ParseAdminPublicKey(s string) (ed25519.PublicKey, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, adminPublicPrefix) {
+		return nil, fmt.Errorf("admin public key must start with %q", adminPublicPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(s, adminPublicPrefix))
+	if err != nil || len(raw) != ed25519.PublicKeySize {
+		return nil, errors.New("malformed admin public key")
+	}
+	return ed25519.PublicKey(raw), nil
+}
@@ -3,14 +3,18 @@ package remote
 import (
 	"context"
 	"errors"
+	"fmt"
 	"path"
 	"sort"
 	"strings"
+	"time"
 
+	"github.com/jasonchiu/envlock/core/backend"
 	"github.com/jasonchiu/envlock/core/config"
 	"github.com/jasonchiu/envlock/core/tigris"
 	"github.com/jasonchiu/envlock/feature/enroll"
 	"github.com/jasonchiu/envlock/feature/recipients"
+	"github.com/jasonchiu/envlock/feature/secrets"
 )
 
 type Store struct {
@@ -37,6 +41,10 @@ func (s *Store) recipientsKey() string {
 	return path.Join(s.prefix, "_envlock", "recipients.json")
 }
 
+func (s *Store) recipientChainKey() string {
+	return path.Join(s.prefix, "_envlock", "recipients.sig.json")
+}
+
 func (s *Store) inviteKey(id string) string {
 	return path.Join(s.prefix, "_envlock", "enroll", "invites", strings.TrimSpace(id)+".json")
 }
@@ -45,6 +53,10 @@ func (s *Store) requestKey(id string) string {
 	return path.Join(s.prefix, "_envlock", "enroll", "requests", strings.TrimSpace(id)+".json")
 }
 
+func (s *Store) secretKey(name string) string {
+	return path.Join(s.prefix, name+secrets.BlobSuffix)
+}
+
 func (s *Store) invitesPrefix() string {
 	return path.Join(s.prefix, "_envlock", "enroll", "invites") + "/"
 }
@@ -53,9 +65,22 @@ func (s *Store) requestsPrefix() string {
 	return path.Join(s.prefix, "_envlock", "enroll", "requests") + "/"
 }
 
+// putJSON performs a conditional write guarded by revision and maps a failed
+// precondition to a backend conflict.
+func (s *Store) putJSON(ctx context.Context, key string, v any, revision string) (string, error) {
+	etag, err := s.client.PutJSON(ctx, key, v, tigris.IfMatch(revision))
+	if err != nil {
+		if errors.Is(err, tigris.ErrPreconditionFailed) {
+			return "", &backend.ConflictError{Object: key}
+		}
+		return "", err
+	}
+	return etag, nil
+}
+
 func (s *Store) LoadRecipients(ctx context.Context) (recipients.Store, error) {
 	var rs recipients.Store
-	err := s.client.GetJSON(ctx, s.recipientsKey(), &rs)
+	etag, err := s.client.GetJSON(ctx, s.recipientsKey(), &rs)
 	if err != nil {
 		if errors.Is(err, tigris.ErrObjectNotFound) {
 			return recipients.Store{Version: 1, Recipients: []recipients.Recipient{}}, nil
@@ -65,6 +90,7 @@ func (s *Store) LoadRecipients(ctx context.Context) (recipients.Store, error) {
 	if rs.Version == 0 {
 		rs.Version = 1
 	}
+	rs.Revision = etag
 	return rs, nil
 }
 
@@ -72,25 +98,41 @@ func (s *Store) WriteRecipients(ctx context.Context, rs recipients.Store) error
 	if rs.Version == 0 {
 		rs.Version = 1
 	}
-	return s.client.PutJSON(ctx, s.recipientsKey(), rs)
+	_, err := s.putJSON(ctx, s.recipientsKey(), rs, rs.Revision)
+	return err
+}
+
+func (s *Store) LoadRecipientChain(ctx context.Context) (recipients.Chain, error) {
+	var c recipients.Chain
+	etag, err := s.client.GetJSON(ctx, s.recipientChainKey(), &c)
+	if err != nil {
+		if errors.Is(err, tigris.ErrObjectNotFound) {
+			return recipients.Chain{Version: 1, Links: []recipients.Link{}}, nil
+		}
+		return recipients.Chain{}, err
+	}
+	c.Revision = etag
+	return c, nil
+}
+
+func (s *Store) WriteRecipientChain(ctx context.Context, c recipients.Chain) error {
+	_, err := s.putJSON(ctx, s.recipientChainKey(), c, c.Revision)
+	return err
 }
 
 func (s *Store) SaveInvite(ctx context.Context, invite enroll.Invite) error {
-	return s.client.PutJSON(ctx, s.inviteKey(invite.ID), invite)
+	_, err := s.putJSON(ctx, s.inviteKey(invite.ID), invite, invite.Revision)
+	return err
 }
 
 func (s *Store) LoadInvite(ctx context.Context, id string) (enroll.Invite, error) {
-	var inv enroll.Invite
-	err := s.client.GetJSON(ctx, s.inviteKey(id), &inv)
+	inv, err := s.getInvite(ctx, s.inviteKey(id))
 	if err != nil {
 		if errors.Is(err, tigris.ErrObjectNotFound) {
 			return enroll.Invite{}, enroll.ErrInviteNotFound
 		}
 		return enroll.Invite{}, err
 	}
-	if inv.Version == 0 {
-		inv.Version = 1
-	}
 	return inv, nil
 }
 
@@ -101,35 +143,42 @@ func (s *Store) ListInvites(ctx context.Context) ([]enroll.Invite, error) {
 	}
 	out := make([]enroll.Invite, 0, len(keys))
 	for _, key := range keys {
-		var inv enroll.Invite
-		if err := s.client.GetJSON(ctx, key, &inv); err != nil {
+		inv, err := s.getInvite(ctx, key)
+		if err != nil {
 			return nil, err
 		}
-		if inv.Version == 0 {
-			inv.Version = 1
-		}
 		out = append(out, inv)
 	}
 	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
 	return out, nil
 }
 
+func (s *Store) getInvite(ctx context.Context, key string) (enroll.Invite, error) {
+	var inv enroll.Invite
+	etag, err := s.client.GetJSON(ctx, key, &inv)
+	if err != nil {
+		return enroll.Invite{}, err
+	}
+	if inv.Version == 0 {
+		inv.Version = 1
+	}
+	inv.Revision = etag
+	return inv, nil
+}
+
 func (s *Store) SaveRequest(ctx context.Context, req enroll.Request) error {
-	return s.client.PutJSON(ctx, s.requestKey(req.ID), req)
+	_, err := s.putJSON(ctx, s.requestKey(req.ID), req, req.Revision)
+	return err
 }
 
 func (s *Store) LoadRequest(ctx context.Context, id string) (enroll.Request, error) {
-	var req enroll.Request
-	err := s.client.GetJSON(ctx, s.requestKey(id), &req)
+	req, err := s.getRequest(ctx, s.requestKey(id))
 	if err != nil {
 		if errors.Is(err, tigris.ErrObjectNotFound) {
 			return enroll.Request{}, enroll.ErrRequestNotFound
 		}
 		return enroll.Request{}, err
 	}
-	if req.Version == 0 {
-		req.Version = 1
-	}
 	return req, nil
 }
 
@@ -140,15 +189,239 @@ func (s *Store) ListRequests(ctx context.Context) ([]enroll.Request, error) {
 	}
 	out := make([]enroll.Request, 0, len(keys))
 	for _, key := range keys {
-		var req enroll.Request
-		if err := s.client.GetJSON(ctx, key, &req); err != nil {
+		req, err := s.getRequest(ctx, key)
+		if err != nil {
 			return nil, err
 		}
-		if req.Version == 0 {
-			req.Version = 1
-		}
 		out = append(out, req)
 	}
 	sort.Slice(out, func(i, j int) bool { return out[i].CreatedAt.After(out[j].CreatedAt) })
 	return out, nil
 }
+
+func (s *Store) getRequest(ctx context.Context, key string) (enroll.Request, error) {
+	var req enroll.Request
+	etag, err := s.client.GetJSON(ctx, key, &req)
+	if err != nil {
+		return enroll.Request{}, err
+	}
+	if req.Version == 0 {
+		req.Version = 1
+	}
+	req.Revision = etag
+	return req, nil
+}
+
+// recipientSetMetaKey records which recipient set a blob was encrypted to so
+// rekey can skip blobs that are already current without decrypting them.
+const recipientSetMetaKey = "envlock-recipient-set"
+
+func (s *Store) manifestKey() string {
+	return path.Join(s.prefix, "_envlock", "manifest.json")
+}
+
+func (s *Store) secretDir(name string) string {
+	return path.Join(s.prefix, "_envlock", "secrets", name)
+}
+
+func (s *Store) historyKey(name string) string {
+	return path.Join(s.secretDir(name), "history.json")
+}
+
+// versionKey content-addresses version objects, so concurrent writers can
+// never clobber each other's ciphertext and rollbacks reuse the same object.
+func (s *Store) versionKey(name, digest string) string {
+	return path.Join(s.secretDir(name), digest+secrets.BlobSuffix)
+}
+
+func (s *Store) LoadSecretHistory(ctx context.Context, name string) (secrets.History, error) {
+	if err := secrets.ValidateName(name); err != nil {
+		return secrets.History{}, err
+	}
+	var h secrets.History
+	etag, err := s.client.GetJSON(ctx, s.historyKey(name), &h)
+	if err != nil {
+		if errors.Is(err, tigris.ErrObjectNotFound) {
+			return secrets.History{}, secrets.ErrSecretNotFound
+		}
+		return secrets.History{}, err
+	}
+	if h.Version == 0 {
+		h.Version = 1
+	}
+	h.Revision = etag
+	return h, nil
+}
+
+// LoadSecret returns the latest version recorded in the secret's history.
+// Blobs pushed before version history existed are read from the top-level
+// object and reported as version 0.
+func (s *Store) LoadSecret(ctx context.Context, name string) (secrets.Blob, error) {
+	h, err := s.LoadSecretHistory(ctx, name)
+	if errors.Is(err, secrets.ErrSecretNotFound) {
+		return s.loadLegacySecret(ctx, name)
+	}
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	latest, ok := h.Latest()
+	if !ok {
+		return secrets.Blob{}, secrets.ErrSecretNotFound
+	}
+	return s.loadVersion(ctx, name, latest)
+}
+
+func (s *Store) LoadSecretVersion(ctx context.Context, name string, version int) (secrets.Blob, error) {
+	h, err := s.LoadSecretHistory(ctx, name)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	v, err := h.Get(version)
+	if err != nil {
+		return secrets.Blob{}, err
+	}
+	return s.loadVersion(ctx, name, v)
+}
+
+func (s *Store) loadVersion(ctx context.Context, name string, v secrets.Version) (secrets.Blob, error) {
+	obj, err := s.client.GetObject(ctx, s.versionKey(name, v.SHA256))
+	if err != nil {
+		if errors.Is(err, tigris.ErrObjectNotFound) {
+			return secrets.Blob{}, fmt.Errorf("%w: %s v%d object missing", secrets.ErrVersionNotFound, name, v.Version)
+		}
+		return secrets.Blob{}, err
+	}
+	if err := secrets.VerifyDigest(v, obj.Data); err != nil {
+		return secrets.Blob{}, err
+	}
+	return secrets.Blob{
+		Name:                  name,
+		Ciphertext:            obj.Data,
+		Version:               v.Version,
+		RecipientSetHash:      v.RecipientSetHash,
+		RecipientFingerprints: v.RecipientFingerprints,
+		CreatedBy:             v.CreatedBy,
+		Source:                v.Source,
+		RestoredFrom:          v.RestoredFrom,
+	}, nil
+}
+
+func (s *Store) loadLegacySecret(ctx context.Context, name string) (secrets.Blob, error) {
+	obj, err := s.client.GetObject(ctx, s.secretKey(name))
+	if err != nil {
+		if errors.Is(err, tigris.ErrObjectNotFound) {
+			return secrets.Blob{}, secrets.ErrSecretNotFound
+		}
+		return secrets.Blob{}, err
+	}
+	return secrets.Blob{
+		Name:             name,
+		Ciphertext:       obj.Data,
+		RecipientSetHash: obj.Metadata[recipientSetMetaKey],
+	}, nil
+}
+
+// SaveSecret appends blob as a new immutable version. The conditional write
+// of history.json is the commit point; the top-level <name>.envlock object is
+// refreshed afterwards as a convenience copy for bucket browsing and listing.
+func (s *Store) SaveSecret(ctx context.Context, blob secrets.Blob) (secrets.Version, error) {
+	if err := secrets.ValidateName(blob.Name); err != nil {
+		return secrets.Version{}, err
+	}
+	h, err := s.LoadSecretHistory(ctx, blob.Name)
+	if errors.Is(err, secrets.ErrSecretNotFound) {
+		h = secrets.NewHistory(blob.Name)
+	} else if err != nil {
+		return secrets.Version{}, err
+	}
+	v, err := h.Append(blob, time.Now().UTC())
+	if err != nil {
+		if errors.Is(err, secrets.ErrStaleVersion) {
+			return secrets.Version{}, &backend.ConflictError{Object: s.historyKey(blob.Name)}
+		}
+		return secrets.Version{}, err
+	}
+
+	meta := map[string]string{recipientSetMetaKey: blob.RecipientSetHash}
+	if _, err := s.client.PutObject(ctx, tigris.Object{
+		Key:      s.versionKey(blob.Name, v.SHA256),
+		Data:     blob.Ciphertext,
+		Metadata: meta,
+	}, tigris.WriteCondition{}); err != nil {
+		return secrets.Version{}, err
+	}
+	if _, err := s.putJSON(ctx, s.historyKey(blob.Name), h, h.Revision); err != nil {
+		return secrets.Version{}, err
+	}
+	if _, err := s.client.PutObject(ctx, tigris.Object{
+		Key:      s.secretKey(blob.Name),
+		Data:     blob.Ciphertext,
+		Metadata: meta,
+	}, tigris.WriteCondition{}); err != nil {
+		return secrets.Version{}, err
+	}
+	if err := s.recordManifest(ctx, blob.Name, v); err != nil {
+		return v, fmt.Errorf("%s v%d was saved but the manifest update failed: %w", blob.Name, v.Version, err)
+	}
+	return v, nil
+}
+
+// manifestRetries bounds the internal retry loop for manifest updates. The
+// version is already committed at that point, so a manifest race must not
+// surface as a push conflict.
+const manifestRetries = 5
+
+func (s *Store) recordManifest(ctx context.Context, name string, v secrets.Version) error {
+	var err error
+	for attempt := 0; attempt < manifestRetries; attempt++ {
+		var m secrets.Manifest
+		m, err = s.LoadManifest(ctx)
+		if err != nil {
+			return err
+		}
+		if !m.Record(name, v) {
+			return nil
+		}
+		if _, err = s.putJSON(ctx, s.manifestKey(), m, m.Revision); !errors.Is(err, backend.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
+
+func (s *Store) LoadManifest(ctx context.Context) (secrets.Manifest, error) {
+	var m secrets.Manifest
+	etag, err := s.client.GetJSON(ctx, s.manifestKey(), &m)
+	if err != nil {
+		if errors.Is(err, tigris.ErrObjectNotFound) {
+			return secrets.NewManifest(), nil
+		}
+		return secrets.Manifest{}, err
+	}
+	if m.Version == 0 {
+		m.Version = 1
+	}
+	m.Revision = etag
+	return m, nil
+}
+
+func (s *Store) ListSecrets(ctx context.Context) ([]string, error) {
+	keys, err := s.client.ListKeys(ctx, s.prefix+"/")
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, key := range keys {
+		rel := strings.TrimPrefix(key, s.prefix+"/")
+		if strings.Contains(rel, "/") || !strings.HasSuffix(rel, secrets.BlobSuffix) {
+			continue
+		}
+		name := strings.TrimSuffix(rel, secrets.BlobSuffix)
+		if secrets.ValidateName(name) != nil {
+			continue
+		}
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
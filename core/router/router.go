@@ -6,14 +6,26 @@ import (
 	"github.com/go-chi/chi/v5"
 	chimw "github.com/go-chi/chi/v5/middleware"
 
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
 	coreauth "github.com/jasonchiu/envlock/core/auth"
 	coreconfig "github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/feature/auditlog"
 	"github.com/jasonchiu/envlock/feature/cliauth"
+	"github.com/jasonchiu/envlock/feature/enrollment"
+	"github.com/jasonchiu/envlock/feature/projects"
+	"github.com/jasonchiu/envlock/feature/secretblobs"
+	"github.com/jasonchiu/envlock/feature/webui"
 )
 
 type Deps struct {
 	Config        coreconfig.Runtime
-	CLILoginStore *coreauth.MemoryStore
+	CLILoginStore coreauth.Store
+	Identity      coreauth.IdentityProvider
+	WebSessions   *coreauth.WebSessions
+	Projects      *projects.Store
+	Secrets       *secretblobs.Store
+	Enrollment    *enrollment.Store
+	Audit         *coreaudit.Log
 }
 
 func New(deps Deps) http.Handler {
@@ -21,6 +33,7 @@ func New(deps Deps) http.Handler {
 	r.Use(chimw.RequestID)
 	r.Use(chimw.RealIP)
 	r.Use(chimw.Recoverer)
+	r.Use(enrollment.RedactTokens)
 	r.Use(chimw.Logger)
 
 	r.Get("/healthz", func(w http.ResponseWriter, _ *http.Request) {
@@ -29,10 +42,59 @@ func New(deps Deps) http.Handler {
 	})
 
 	h := &cliauth.Handler{
-		Config: deps.Config,
-		Store:  deps.CLILoginStore,
+		Config:      deps.Config,
+		Store:       deps.CLILoginStore,
+		Identity:    deps.Identity,
+		Audit:       deps.Audit,
+		WebSessions: deps.WebSessions,
 	}
 	h.RegisterRoutes(r)
 
+	ph := &projects.Handler{
+		Auth:  deps.CLILoginStore,
+		Store: deps.Projects,
+		Audit: deps.Audit,
+	}
+	if deps.Secrets != nil {
+		ph.Deleted = deps.Secrets.DeleteProject
+	}
+	ph.RegisterRoutes(r)
+
+	sh := &secretblobs.Handler{
+		Auth:     deps.CLILoginStore,
+		Projects: deps.Projects,
+		Store:    deps.Secrets,
+		Audit:    deps.Audit,
+	}
+	sh.RegisterRoutes(r)
+
+	eh := &enrollment.Handler{
+		Auth:     deps.CLILoginStore,
+		Projects: deps.Projects,
+		Store:    deps.Enrollment,
+		Audit:    deps.Audit,
+	}
+	eh.RegisterRoutes(r)
+
+	ah := &auditlog.Handler{
+		Config:   deps.Config,
+		Auth:     deps.CLILoginStore,
+		Projects: deps.Projects,
+		Log:      deps.Audit,
+	}
+	ah.RegisterRoutes(r)
+
+	if deps.WebSessions != nil {
+		wh := &webui.Handler{
+			Config:     deps.Config,
+			Sessions:   deps.WebSessions,
+			Projects:   deps.Projects,
+			Enrollment: deps.Enrollment,
+			Secrets:    deps.Secrets,
+			Audit:      deps.Audit,
+		}
+		wh.RegisterRoutes(r)
+	}
+
 	return r
 }
@@ -0,0 +1,80 @@
+package serverapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+type AuditEvent struct {
+	ID         int64     `json:"id"`
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	ProjectID  string    `json:"project_id,omitempty"`
+	ActorID    string    `json:"actor_id,omitempty"`
+	ActorEmail string    `json:"actor_email,omitempty"`
+	Device     string    `json:"device,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Detail     string    `json:"detail,omitempty"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+}
+
+// AuditQuery filters audit events. Before is the NextCursor of a previous
+// page.
+type AuditQuery struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	Before string
+	Limit  int
+}
+
+type AuditPage struct {
+	Events     []AuditEvent `json:"events"`
+	NextCursor string       `json:"next_cursor,omitempty"`
+}
+
+// ProjectAudit lists a project's audit events, newest first. The caller must
+// be a project admin.
+func (c *Client) ProjectAudit(ctx context.Context, projectID string, q AuditQuery) (AuditPage, error) {
+	return c.audit(ctx, ProjectPath(projectID, "audit"), q)
+}
+
+// ServerAudit lists audit events across all projects, including logins. The
+// caller must be a server admin.
+func (c *Client) ServerAudit(ctx context.Context, q AuditQuery) (AuditPage, error) {
+	return c.audit(ctx, "/api/admin/audit", q)
+}
+
+func (c *Client) audit(ctx context.Context, path string, q AuditQuery) (AuditPage, error) {
+	v := url.Values{}
+	if !q.Since.IsZero() {
+		v.Set("since", q.Since.UTC().Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		v.Set("until", q.Until.UTC().Format(time.RFC3339))
+	}
+	if q.Actor != "" {
+		v.Set("actor", q.Actor)
+	}
+	if q.Action != "" {
+		v.Set("action", q.Action)
+	}
+	if q.Before != "" {
+		v.Set("cursor", q.Before)
+	}
+	if q.Limit > 0 {
+		v.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if len(v) > 0 {
+		path += "?" + v.Encode()
+	}
+	var out AuditPage
+	if err := c.doJSON(ctx, http.MethodGet, path, "", nil, &out); err != nil {
+		return AuditPage{}, err
+	}
+	return out, nil
+}
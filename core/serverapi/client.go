@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
@@ -14,6 +15,8 @@ import (
 type Client struct {
 	baseURL string
 	http    *http.Client
+	session *Session
+	device  string
 }
 
 func New(baseURL string) (*Client, error) {
@@ -27,8 +30,16 @@ func New(baseURL string) (*Client, error) {
 	}, nil
 }
 
+// SetDevice identifies this machine's device key (its fingerprint) to the
+// server on every request, for the audit log.
+func (c *Client) SetDevice(fingerprint string) {
+	c.device = strings.TrimSpace(fingerprint)
+}
+
 type CLILoginStartRequest struct {
-	CallbackURL string `json:"callback_url,omitempty"`
+	CallbackURL         string `json:"callback_url,omitempty"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
 }
 
 type CLILoginStartResponse struct {
@@ -37,8 +48,9 @@ type CLILoginStartResponse struct {
 }
 
 type CLILoginExchangeRequest struct {
-	Code  string `json:"code"`
-	State string `json:"state,omitempty"`
+	Code         string `json:"code"`
+	State        string `json:"state,omitempty"`
+	CodeVerifier string `json:"code_verifier"`
 }
 
 type User struct {
@@ -78,42 +90,120 @@ func (c *Client) WhoAmI(ctx context.Context, accessToken string) (User, error) {
 	return out, nil
 }
 
+type LogoutRequest struct {
+	AllSessions bool `json:"all_sessions,omitempty"`
+}
+
+type RevokeResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// Logout revokes the current login (or every login of the account with
+// AllSessions) on the server.
+func (c *Client) Logout(ctx context.Context, accessToken string, req LogoutRequest) (RevokeResponse, error) {
+	var out RevokeResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/api/cli/logout", accessToken, req, &out); err != nil {
+		return RevokeResponse{}, err
+	}
+	return out, nil
+}
+
+type AdminRevokeSessionsRequest struct {
+	Email string `json:"email"`
+}
+
+// AdminRevokeSessions revokes every login of another user. The caller must be
+// a server admin.
+func (c *Client) AdminRevokeSessions(ctx context.Context, accessToken string, req AdminRevokeSessionsRequest) (RevokeResponse, error) {
+	var out RevokeResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/api/admin/sessions/revoke", accessToken, req, &out); err != nil {
+		return RevokeResponse{}, err
+	}
+	return out, nil
+}
+
 func (c *Client) doJSON(ctx context.Context, method, path, accessToken string, reqBody any, dst any) error {
-	var body io.Reader
+	var body []byte
+	header := http.Header{}
 	if reqBody != nil {
 		b, err := json.Marshal(reqBody)
 		if err != nil {
 			return err
 		}
-		body = bytes.NewReader(b)
+		body = b
+		header.Set("Content-Type", "application/json")
 	}
+	header.Set("Accept", "application/json")
 
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	resp, err := c.do(ctx, method, path, accessToken, body, header)
 	if err != nil {
 		return err
 	}
-	if reqBody != nil {
-		req.Header.Set("Content-Type", "application/json")
+	defer resp.Body.Close()
+	return decodeJSON(resp, method, path, dst)
+}
+
+// do sends a request and returns the response for any 2xx status. Other
+// statuses are returned as *HTTPError with the response body as the message.
+//
+// Requests without an explicit accessToken use the client's Session, if any:
+// an expired or rejected (401) access token is refreshed once and the request
+// is replayed with the new token.
+func (c *Client) do(ctx context.Context, method, path, accessToken string, body []byte, header http.Header) (*http.Response, error) {
+	if accessToken != "" || c.session == nil {
+		return c.send(ctx, method, path, accessToken, body, header)
+	}
+	token, err := c.session.current(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.send(ctx, method, path, token, body, header)
+	if StatusCode(err) != http.StatusUnauthorized {
+		return resp, err
+	}
+	token, rerr := c.session.refresh(ctx, c, token)
+	if rerr != nil {
+		return nil, rerr
+	}
+	return c.send(ctx, method, path, token, body, header)
+}
+
+func (c *Client) send(ctx context.Context, method, path, accessToken string, body []byte, header http.Header) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if c.device != "" {
+		req.Header.Set(HeaderDevice, c.device)
 	}
-	req.Header.Set("Accept", "application/json")
 	if strings.TrimSpace(accessToken) != "" {
 		req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(accessToken))
 	}
 
 	resp, err := c.http.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
-
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
 		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 8<<10))
 		text := strings.TrimSpace(string(msg))
 		if text == "" {
 			text = resp.Status
 		}
-		return fmt.Errorf("server %s %s: %s", method, path, text)
+		return nil, &HTTPError{Method: method, Path: path, StatusCode: resp.StatusCode, Message: text}
 	}
+	return resp, nil
+}
+
+func decodeJSON(resp *http.Response, method, path string, dst any) error {
 	if dst == nil || resp.StatusCode == http.StatusNoContent {
 		return nil
 	}
@@ -122,3 +212,24 @@ func (c *Client) doJSON(ctx context.Context, method, path, accessToken string, r
 	}
 	return nil
 }
+
+// HTTPError is a non-2xx response from the server.
+type HTTPError struct {
+	Method     string
+	Path       string
+	StatusCode int
+	Message    string
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("server %s %s: %s", e.Method, e.Path, e.Message)
+}
+
+// StatusCode returns the HTTP status of err if it is an *HTTPError, or 0.
+func StatusCode(err error) int {
+	var he *HTTPError
+	if errors.As(err, &he) {
+		return he.StatusCode
+	}
+	return 0
+}
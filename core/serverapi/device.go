@@ -0,0 +1,79 @@
+package serverapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// Poll outcomes of the device authorization grant (RFC 8628 section 3.5).
+var (
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("polling too fast")
+	ErrDeviceCodeExpired    = errors.New("device code expired")
+	ErrAccessDenied         = errors.New("login was denied")
+)
+
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete,omitempty"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+type DeviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+func (c *Client) StartDeviceLogin(ctx context.Context) (DeviceCodeResponse, error) {
+	var out DeviceCodeResponse
+	if err := c.doJSON(ctx, http.MethodPost, "/api/cli/device/code", "", struct{}{}, &out); err != nil {
+		return DeviceCodeResponse{}, err
+	}
+	return out, nil
+}
+
+// PollDeviceLogin asks once whether the device login was approved. Until it
+// is, the error is one of ErrAuthorizationPending, ErrSlowDown,
+// ErrDeviceCodeExpired or ErrAccessDenied.
+func (c *Client) PollDeviceLogin(ctx context.Context, req DeviceTokenRequest) (CLILoginExchangeResponse, error) {
+	var out CLILoginExchangeResponse
+	err := c.doJSON(ctx, http.MethodPost, "/api/cli/device/token", "", req, &out)
+	if err == nil {
+		return out, nil
+	}
+	var he *HTTPError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusBadRequest {
+		return CLILoginExchangeResponse{}, err
+	}
+	var body struct {
+		Error string `json:"error"`
+	}
+	if json.Unmarshal([]byte(he.Message), &body) != nil {
+		return CLILoginExchangeResponse{}, err
+	}
+	switch body.Error {
+	case "authorization_pending":
+		return CLILoginExchangeResponse{}, ErrAuthorizationPending
+	case "slow_down":
+		return CLILoginExchangeResponse{}, ErrSlowDown
+	case "expired_token":
+		return CLILoginExchangeResponse{}, ErrDeviceCodeExpired
+	case "access_denied":
+		return CLILoginExchangeResponse{}, ErrAccessDenied
+	}
+	return CLILoginExchangeResponse{}, err
+}
+
+// PollInterval returns the server's requested poll interval, defaulting to
+// five seconds as RFC 8628 does.
+func (r DeviceCodeResponse) PollInterval() time.Duration {
+	if r.Interval <= 0 {
+		return 5 * time.Second
+	}
+	return time.Duration(r.Interval) * time.Second
+}
@@ -0,0 +1,101 @@
+package serverapi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Invite mirrors enroll.Invite as served by the enrollment API; the server
+// never returns the secret hash.
+type Invite struct {
+	ID              string    `json:"id"`
+	Status          string    `json:"status"`
+	CreatedAt       time.Time `json:"created_at"`
+	ExpiresAt       time.Time `json:"expires_at"`
+	CreatedBy       string    `json:"created_by,omitempty"`
+	UsedByRequestID string    `json:"used_by_request_id,omitempty"`
+}
+
+type CreateInviteRequest struct {
+	TTLSeconds int    `json:"ttl_seconds"`
+	CreatedBy  string `json:"created_by,omitempty"`
+}
+
+type CreateInviteResponse struct {
+	Invite Invite `json:"invite"`
+	Token  string `json:"token"`
+}
+
+type JoinRequest struct {
+	DeviceName string `json:"device_name"`
+	PublicKey  string `json:"public_key"`
+}
+
+// EnrollRequest is a join request together with the server user who filed
+// it.
+type EnrollRequest struct {
+	ID           string    `json:"id"`
+	ProjectID    string    `json:"project_id"`
+	ProjectName  string    `json:"project_name,omitempty"`
+	InviteID     string    `json:"invite_id"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"created_at"`
+	DecisionAt   time.Time `json:"decision_at,omitempty"`
+	DecisionNote string    `json:"decision_note,omitempty"`
+	DecidedBy    string    `json:"decided_by,omitempty"`
+	UserID       string    `json:"user_id"`
+	UserEmail    string    `json:"user_email"`
+	DeviceName   string    `json:"device_name"`
+	PublicKey    string    `json:"public_key"`
+	Fingerprint  string    `json:"fingerprint"`
+}
+
+type ApproveResponse struct {
+	Request        EnrollRequest `json:"request"`
+	RecipientAdded bool          `json:"recipient_added"`
+}
+
+// CreateInvite issues an invite for a project. The caller must be a project
+// admin; the token is only ever returned here.
+func (c *Client) CreateInvite(ctx context.Context, projectID string, req CreateInviteRequest) (CreateInviteResponse, error) {
+	var out CreateInviteResponse
+	if err := c.doJSON(ctx, http.MethodPost, ProjectPath(projectID, "invites"), "", req, &out); err != nil {
+		return CreateInviteResponse{}, err
+	}
+	return out, nil
+}
+
+// JoinInvite files an enrollment request for the logged-in user's device.
+// The invite token identifies the project.
+func (c *Client) JoinInvite(ctx context.Context, token string, req JoinRequest) (EnrollRequest, error) {
+	var out EnrollRequest
+	path := "/api/invites/" + url.PathEscape(token) + "/join"
+	if err := c.doJSON(ctx, http.MethodPost, path, "", req, &out); err != nil {
+		return EnrollRequest{}, err
+	}
+	return out, nil
+}
+
+// ApproveRequest adds the request's device to the project recipients and its
+// user to the project members. fingerprint is required and must match the
+// request's device, so the approval only applies to the key that was
+// verified.
+func (c *Client) ApproveRequest(ctx context.Context, projectID, requestID, fingerprint, note string) (ApproveResponse, error) {
+	var out ApproveResponse
+	body := map[string]string{"note": note, "fingerprint": fingerprint}
+	if err := c.doJSON(ctx, http.MethodPost, ProjectPath(projectID, "requests", requestID, "approve"), "", body, &out); err != nil {
+		return ApproveResponse{}, err
+	}
+	return out, nil
+}
+
+func (c *Client) RejectRequest(ctx context.Context, projectID, requestID, reason string) (EnrollRequest, error) {
+	var out EnrollRequest
+	body := map[string]string{"reason": reason}
+	if err := c.doJSON(ctx, http.MethodPost, ProjectPath(projectID, "requests", requestID, "reject"), "", body, &out); err != nil {
+		return EnrollRequest{}, err
+	}
+	return out, nil
+}
@@ -0,0 +1,38 @@
+package serverapi
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+type Project struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Role      string    `json:"role,omitempty"`
+}
+
+type CreateProjectRequest struct {
+	Name string `json:"name"`
+}
+
+// ListProjects returns the projects the logged-in user is a member of.
+func (c *Client) ListProjects(ctx context.Context) ([]Project, error) {
+	var out []Project
+	if err := c.doJSON(ctx, http.MethodGet, "/api/projects", "", nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// CreateProject creates a project with the logged-in user as its admin.
+func (c *Client) CreateProject(ctx context.Context, req CreateProjectRequest) (Project, error) {
+	var out Project
+	if err := c.doJSON(ctx, http.MethodPost, "/api/projects", "", req, &out); err != nil {
+		return Project{}, err
+	}
+	return out, nil
+}
@@ -0,0 +1,113 @@
+package serverapi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Resource calls authenticate with the client's Session (see UseSession).
+
+// Headers used by the project resource API. JSON documents (recipients,
+// invites, requests, secret histories) are versioned with ETags; ciphertext
+// uploads describe themselves with the Envlock-* headers below.
+const (
+	HeaderBaseVersion  = "Envlock-Base-Version"
+	HeaderVersion      = "Envlock-Version"
+	HeaderSHA256       = "Envlock-Sha256"
+	HeaderSize         = "Envlock-Size"
+	HeaderRecipientSet = "Envlock-Recipient-Set"
+	HeaderRecipients   = "Envlock-Recipients"
+	HeaderCreatedBy    = "Envlock-Created-By"
+	HeaderSource       = "Envlock-Source"
+	HeaderRestoredFrom = "Envlock-Restored-From"
+)
+
+// HeaderDevice carries the fingerprint of the CLI's device key on every
+// request (see Client.SetDevice) so the server can attribute audit events to
+// a machine, not just an account.
+const HeaderDevice = "Envlock-Device"
+
+// GetJSON decodes the document at path into dst and returns its ETag.
+func (c *Client) GetJSON(ctx context.Context, path string, dst any) (string, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/json")
+	resp, err := c.do(ctx, http.MethodGet, path, "", nil, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if err := decodeJSON(resp, http.MethodGet, path, dst); err != nil {
+		return "", err
+	}
+	return resp.Header.Get("ETag"), nil
+}
+
+// PutJSON writes v to path if the stored document is still at revision (an
+// ETag from GetJSON). An empty revision only creates. The server answers a
+// lost race with 412 Precondition Failed.
+func (c *Client) PutJSON(ctx context.Context, path string, v any, revision string) (string, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+	if revision == "" {
+		header.Set("If-None-Match", "*")
+	} else {
+		header.Set("If-Match", revision)
+	}
+	resp, err := c.do(ctx, http.MethodPut, path, "", b, header)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	return resp.Header.Get("ETag"), nil
+}
+
+// GetBlob downloads raw ciphertext and returns it with the response headers.
+func (c *Client) GetBlob(ctx context.Context, path string) ([]byte, http.Header, error) {
+	header := http.Header{}
+	header.Set("Accept", "application/octet-stream")
+	resp, err := c.do(ctx, http.MethodGet, path, "", nil, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, resp.Header, nil
+}
+
+// PutBlob uploads raw ciphertext with the given Envlock-* headers and decodes
+// the JSON response into dst.
+func (c *Client) PutBlob(ctx context.Context, path string, data []byte, meta http.Header, dst any) error {
+	header := http.Header{}
+	for k, v := range meta {
+		header[k] = v
+	}
+	header.Set("Content-Type", "application/octet-stream")
+	header.Set("Accept", "application/json")
+	resp, err := c.do(ctx, http.MethodPut, path, "", data, header)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decodeJSON(resp, http.MethodPut, path, dst)
+}
+
+// ProjectPath builds /api/projects/{id}/... with each element path-escaped.
+func ProjectPath(projectID string, elem ...string) string {
+	parts := []string{"/api/projects", url.PathEscape(projectID)}
+	for _, e := range elem {
+		parts = append(parts, url.PathEscape(e))
+	}
+	return strings.Join(parts, "/")
+}
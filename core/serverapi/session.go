@@ -0,0 +1,127 @@
+package serverapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// refreshSkew renews access tokens slightly before they expire so a request
+// does not race the expiry.
+const refreshSkew = 30 * time.Second
+
+// ErrSessionExpired reports that the stored login can no longer be renewed
+// and the user has to run `envlock login` again.
+var ErrSessionExpired = errors.New("login session expired (run `envlock login`)")
+
+// Session holds the CLI's tokens for a Client. Refresh tokens are single-use:
+// each refresh returns a new pair, which is handed to the OnRefresh callback
+// so it can be persisted before the old refresh token is lost.
+type Session struct {
+	mu           sync.Mutex
+	accessToken  string
+	refreshToken string
+	expiresAt    time.Time
+	onRefresh    func(CLILoginExchangeResponse) error
+}
+
+func NewSession(accessToken, refreshToken string, expiresAt time.Time, onRefresh func(CLILoginExchangeResponse) error) *Session {
+	return &Session{
+		accessToken:  strings.TrimSpace(accessToken),
+		refreshToken: strings.TrimSpace(refreshToken),
+		expiresAt:    expiresAt,
+		onRefresh:    onRefresh,
+	}
+}
+
+// UseSession makes calls without an explicit access token authenticate with s.
+func (c *Client) UseSession(s *Session) {
+	c.session = s
+}
+
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// RefreshCLIToken redeems refreshToken for a new access/refresh pair. The
+// presented refresh token is invalid afterwards; presenting it again revokes
+// the whole login.
+func (c *Client) RefreshCLIToken(ctx context.Context, refreshToken string) (CLILoginExchangeResponse, error) {
+	const path = "/api/cli/token/refresh"
+	b, err := json.Marshal(refreshRequest{RefreshToken: refreshToken})
+	if err != nil {
+		return CLILoginExchangeResponse{}, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+	resp, err := c.send(ctx, http.MethodPost, path, "", b, header)
+	if err != nil {
+		return CLILoginExchangeResponse{}, err
+	}
+	defer resp.Body.Close()
+	var out CLILoginExchangeResponse
+	if err := decodeJSON(resp, http.MethodPost, path, &out); err != nil {
+		return CLILoginExchangeResponse{}, err
+	}
+	if strings.TrimSpace(out.AccessToken) == "" {
+		return CLILoginExchangeResponse{}, errors.New("server returned empty access token")
+	}
+	return out, nil
+}
+
+// current returns the access token to use, refreshing first if it is known
+// to have expired.
+func (s *Session) current(ctx context.Context, c *Client) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != "" && (s.expiresAt.IsZero() || time.Now().Add(refreshSkew).Before(s.expiresAt)) {
+		return s.accessToken, nil
+	}
+	if s.refreshToken == "" {
+		if s.accessToken == "" {
+			return "", errors.New("not logged in (run `envlock login`)")
+		}
+		return s.accessToken, nil
+	}
+	return s.refreshLocked(ctx, c)
+}
+
+// refresh renews the access token after rejected was refused by the server.
+// If another request already replaced rejected, the newer token is reused
+// instead of spending the refresh token twice.
+func (s *Session) refresh(ctx context.Context, c *Client, rejected string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.accessToken != rejected {
+		return s.accessToken, nil
+	}
+	if s.refreshToken == "" {
+		return "", ErrSessionExpired
+	}
+	return s.refreshLocked(ctx, c)
+}
+
+func (s *Session) refreshLocked(ctx context.Context, c *Client) (string, error) {
+	out, err := c.RefreshCLIToken(ctx, s.refreshToken)
+	if err != nil {
+		if StatusCode(err) == http.StatusUnauthorized {
+			return "", fmt.Errorf("%w: %v", ErrSessionExpired, err)
+		}
+		return "", err
+	}
+	s.accessToken = out.AccessToken
+	s.refreshToken = out.RefreshToken
+	s.expiresAt = out.ExpiresAt
+	if s.onRefresh != nil {
+		if err := s.onRefresh(out); err != nil {
+			return "", fmt.Errorf("save refreshed login: %w", err)
+		}
+	}
+	return s.accessToken, nil
+}
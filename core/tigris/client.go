@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"strings"
 
@@ -19,7 +20,28 @@ import (
 	"github.com/jasonchiu/envlock/core/config"
 )
 
-var ErrObjectNotFound = errors.New("object not found")
+var (
+	ErrObjectNotFound     = errors.New("object not found")
+	ErrPreconditionFailed = errors.New("object precondition failed")
+)
+
+// WriteCondition makes a put conditional on the object's current state. The
+// zero value writes unconditionally.
+type WriteCondition struct {
+	// IfMatch only replaces the object if its current ETag matches.
+	IfMatch string
+	// IfNoneMatch only creates the object if it does not exist yet.
+	IfNoneMatch bool
+}
+
+// IfMatch returns a condition for replacing a previously loaded object, or
+// for creating it when etag is empty (the object did not exist at load time).
+func IfMatch(etag string) WriteCondition {
+	if strings.TrimSpace(etag) == "" {
+		return WriteCondition{IfNoneMatch: true}
+	}
+	return WriteCondition{IfMatch: etag}
+}
 
 type Client struct {
 	s3     *s3.Client
@@ -62,41 +84,84 @@ func NewFromProject(proj config.Project) (*Client, error) {
 	return &Client{s3: client, bucket: proj.Bucket}, nil
 }
 
-func (c *Client) GetJSON(ctx context.Context, key string, dst any) error {
+// GetJSON decodes the object at key into dst and returns its ETag for use
+// with a later conditional PutJSON.
+func (c *Client) GetJSON(ctx context.Context, key string, dst any) (string, error) {
+	obj, err := c.GetObject(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	if err := json.Unmarshal(obj.Data, dst); err != nil {
+		return "", fmt.Errorf("decode %s: %w", key, err)
+	}
+	return obj.ETag, nil
+}
+
+// PutJSON writes v as indented JSON and returns the new ETag. A failed
+// condition is reported as ErrPreconditionFailed.
+func (c *Client) PutJSON(ctx context.Context, key string, v any, cond WriteCondition) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	data = append(data, '\n')
+	return c.put(ctx, key, data, "application/json", nil, cond)
+}
+
+// Object is a raw (non-JSON) object such as an encrypted env blob. Metadata
+// is stored as S3 user metadata; keys are lowercased by the service.
+type Object struct {
+	Key      string
+	Data     []byte
+	Metadata map[string]string
+	ETag     string
+}
+
+func (c *Client) GetObject(ctx context.Context, key string) (Object, error) {
 	out, err := c.s3.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 	})
 	if err != nil {
 		if isNotFound(err) {
-			return ErrObjectNotFound
+			return Object{}, ErrObjectNotFound
 		}
-		return err
+		return Object{}, err
 	}
 	defer out.Body.Close()
 	data, err := io.ReadAll(out.Body)
 	if err != nil {
-		return err
+		return Object{}, err
 	}
-	if err := json.Unmarshal(data, dst); err != nil {
-		return fmt.Errorf("decode %s: %w", key, err)
-	}
-	return nil
+	return Object{Key: key, Data: data, Metadata: out.Metadata, ETag: aws.ToString(out.ETag)}, nil
 }
 
-func (c *Client) PutJSON(ctx context.Context, key string, v any) error {
-	data, err := json.MarshalIndent(v, "", "  ")
-	if err != nil {
-		return err
-	}
-	data = append(data, '\n')
-	_, err = c.s3.PutObject(ctx, &s3.PutObjectInput{
+func (c *Client) PutObject(ctx context.Context, obj Object, cond WriteCondition) (string, error) {
+	return c.put(ctx, obj.Key, obj.Data, "application/octet-stream", obj.Metadata, cond)
+}
+
+func (c *Client) put(ctx context.Context, key string, data []byte, contentType string, metadata map[string]string, cond WriteCondition) (string, error) {
+	in := &s3.PutObjectInput{
 		Bucket:      aws.String(c.bucket),
 		Key:         aws.String(key),
 		Body:        bytes.NewReader(data),
-		ContentType: aws.String("application/json"),
-	})
-	return err
+		ContentType: aws.String(contentType),
+		Metadata:    metadata,
+	}
+	if cond.IfMatch != "" {
+		in.IfMatch = aws.String(cond.IfMatch)
+	}
+	if cond.IfNoneMatch {
+		in.IfNoneMatch = aws.String("*")
+	}
+	out, err := c.s3.PutObject(ctx, in)
+	if err != nil {
+		if isPreconditionFailed(err) {
+			return "", fmt.Errorf("%w: %s", ErrPreconditionFailed, key)
+		}
+		return "", err
+	}
+	return aws.ToString(out.ETag), nil
 }
 
 func (c *Client) DeleteObject(ctx context.Context, key string) error {
@@ -128,6 +193,21 @@ func (c *Client) ListKeys(ctx context.Context, prefix string) ([]string, error)
 	return keys, nil
 }
 
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch strings.TrimSpace(apiErr.ErrorCode()) {
+		case "PreconditionFailed", "ConditionalRequestConflict":
+			return true
+		}
+	}
+	var respErr interface{ HTTPStatusCode() int }
+	if errors.As(err, &respErr) {
+		return respErr.HTTPStatusCode() == http.StatusPreconditionFailed
+	}
+	return false
+}
+
 func isNotFound(err error) bool {
 	var noSuchKey *s3types.NoSuchKey
 	if errors.As(err, &noSuchKey) {
@@ -0,0 +1,127 @@
+// Package auditlog serves the server's audit log (see core/audit).
+package auditlog
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	coreconfig "github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/feature/projects"
+)
+
+// Handler serves audit queries. Both routes accept the same filters:
+// since/until (RFC 3339), actor (email or user ID), action, limit and cursor
+// (the next_cursor of the previous page).
+//
+//	GET /api/projects/{projectID}/audit   project admins; that project's events
+//	GET /api/admin/audit                  server admins; every event incl. logins (?project=)
+type Handler struct {
+	Config   coreconfig.Runtime
+	Auth     coreauth.Store
+	Projects *projects.Store
+	Log      *coreaudit.Log
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(coreauth.RequireBearer(h.Auth))
+		r.With(projects.RequireRole(h.Projects, projects.RoleAdmin)).Get("/api/projects/{projectID}/audit", h.project)
+		r.Get("/api/admin/audit", h.server)
+	})
+}
+
+type page struct {
+	Events     []coreaudit.Event `json:"events"`
+	NextCursor string            `json:"next_cursor,omitempty"`
+}
+
+func (h *Handler) project(w http.ResponseWriter, r *http.Request) {
+	f, ok := parseFilter(w, r)
+	if !ok {
+		return
+	}
+	f.ProjectID, _, _ = projects.FromContext(r.Context())
+	h.query(w, r, f)
+}
+
+func (h *Handler) server(w http.ResponseWriter, r *http.Request) {
+	user, _ := coreauth.UserFromContext(r.Context())
+	if !h.Config.IsAdmin(user.Email) {
+		httpErrorJSON(w, http.StatusForbidden, "admin access required")
+		return
+	}
+	f, ok := parseFilter(w, r)
+	if !ok {
+		return
+	}
+	f.ProjectID = strings.TrimSpace(r.URL.Query().Get("project"))
+	h.query(w, r, f)
+}
+
+func (h *Handler) query(w http.ResponseWriter, r *http.Request, f coreaudit.Filter) {
+	events, err := h.Log.Query(r.Context(), f)
+	if err != nil {
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	out := page{Events: events}
+	if n := len(events); n > 0 && n == f.PageSize() {
+		out.NextCursor = strconv.FormatInt(events[n-1].ID, 10)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func parseFilter(w http.ResponseWriter, r *http.Request) (coreaudit.Filter, bool) {
+	q := r.URL.Query()
+	f := coreaudit.Filter{
+		Actor:  strings.TrimSpace(q.Get("actor")),
+		Action: strings.TrimSpace(q.Get("action")),
+	}
+	for _, p := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &f.Since}, {"until", &f.Until}} {
+		if v := strings.TrimSpace(q.Get(p.name)); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				httpErrorJSON(w, http.StatusBadRequest, p.name+" must be an RFC 3339 timestamp")
+				return coreaudit.Filter{}, false
+			}
+			*p.dst = t
+		}
+	}
+	if v := strings.TrimSpace(q.Get("limit")); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			httpErrorJSON(w, http.StatusBadRequest, "limit must be a positive integer")
+			return coreaudit.Filter{}, false
+		}
+		f.Limit = n
+	}
+	if v := strings.TrimSpace(q.Get("cursor")); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			httpErrorJSON(w, http.StatusBadRequest, "invalid cursor")
+			return coreaudit.Filter{}, false
+		}
+		f.Before = n
+	}
+	return f, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func httpErrorJSON(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"error": strings.TrimSpace(msg)})
+}
@@ -19,6 +19,9 @@ import (
 
 	"github.com/jasonchiu/envlock/core/authstate"
 	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/backend/localfs"
+	"github.com/jasonchiu/envlock/core/backend/server"
+	"github.com/jasonchiu/envlock/core/backend/signed"
 	"github.com/jasonchiu/envlock/core/config"
 	"github.com/jasonchiu/envlock/core/keys"
 	"github.com/jasonchiu/envlock/core/remote"
@@ -44,8 +47,16 @@ func Run(args []string) error {
 		return runLogin(args[1:])
 	case "whoami":
 		return runWhoami(args[1:])
+	case "logout":
+		return runLogout(args[1:])
 	case "secrets":
 		return runSecrets(args[1:])
+	case "encrypt":
+		return runEncrypt(args[1:])
+	case "decrypt":
+		return runDecrypt(args[1:])
+	case "run":
+		return runRun(args[1:])
 	case "invite":
 		return runInvite(args[1:])
 	case "devices":
@@ -56,6 +67,10 @@ func Run(args []string) error {
 		return runRecipients(args[1:])
 	case "enroll":
 		return runEnroll(args[1:])
+	case "audit":
+		return runAudit(args[1:])
+	case "trust":
+		return runTrust(args[1:])
 	case "help", "--help", "-h":
 		printRootUsage()
 		return nil
@@ -75,6 +90,9 @@ func printRootUsage() {
 	fmt.Println("  status                Show local/project setup status")
 	fmt.Println("  project init          Initialize project config")
 	fmt.Println("  project show          Show project config")
+	fmt.Println("  project create        Create a project on the logged-in server and select it")
+	fmt.Println("  project use           Select a server project for this directory")
+	fmt.Println("  project ls            List server projects you belong to")
 	fmt.Println("  invite create         Create invite token (alias of enroll invite)")
 	fmt.Println("  invite join           Join using invite token/url (alias of enroll join)")
 	fmt.Println("  devices ls            List devices (alias of recipients list)")
@@ -90,11 +108,28 @@ func printRootUsage() {
 	fmt.Println("  enroll list           List enrollment requests")
 	fmt.Println("  enroll approve        Approve enrollment request")
 	fmt.Println("  enroll reject         Reject enrollment request")
+	fmt.Println("  secrets push          Encrypt a .env file to active recipients and upload it")
+	fmt.Println("  secrets pull          Download and decrypt a secret to a local file")
+	fmt.Println("  secrets ls            List secrets from the project manifest")
+	fmt.Println("  secrets status        Show which secrets need rekeying (non-zero exit if any)")
+	fmt.Println("  secrets rekey         Re-encrypt secrets to the current active recipients")
+	fmt.Println("  secrets history       List pushed versions of a secret")
+	fmt.Println("  secrets rollback      Promote an earlier version as the latest")
+	fmt.Println("  encrypt               Encrypt a file locally to a recipients file (offline)")
+	fmt.Println("  decrypt               Decrypt a file locally with this device key (offline)")
+	fmt.Println("  run                   Run a command with a secret decrypted into its environment only")
+	fmt.Println("  audit ls              Show the server audit log for this project (project admins)")
+	fmt.Println("  trust init            Sign the recipient set and pin this device's admin key in project.toml")
+	fmt.Println("  trust status          Verify the recipients signature chain")
+	fmt.Println("  trust sign            Review and sign the current recipient set")
+	fmt.Println("  trust key             Show this device's admin public key")
+	fmt.Println("  trust admin add       Allow another admin key to sign recipient changes")
+	fmt.Println("  trust admin remove    Stop an admin key from signing recipient changes")
 	fmt.Println()
 	fmt.Println("Scaffolded (server-backed flow planned):")
 	fmt.Println("  login                 Browser login (server endpoints required)")
 	fmt.Println("  whoami                Show authenticated user (server endpoints required)")
-	fmt.Println("  secrets               push/pull/ls/status/rekey command family (not implemented yet)")
+	fmt.Println("  logout                Revoke this login on the server and remove local credentials")
 }
 
 func runLogin(args []string) error {
@@ -104,6 +139,7 @@ func runLogin(args []string) error {
 	noBrowser := fs.Bool("no-browser", false, "do not attempt to open browser automatically")
 	codeFlag := fs.String("code", "", "manual one-time login code (fallback flow)")
 	timeout := fs.Duration("timeout", 2*time.Minute, "wait time for localhost callback before prompting fallback")
+	device := fs.Bool("device", false, "headless login: show a code to approve from any browser (no localhost callback)")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
@@ -123,10 +159,17 @@ func runLogin(args []string) error {
 		return errors.New("server URL is required (pass --server on first login)")
 	}
 
-	client, err := serverapi.New(baseURL)
+	client, err := newServerClient(baseURL)
 	if err != nil {
 		return err
 	}
+	if *device {
+		exResp, err := deviceLogin(context.Background(), client, baseURL)
+		if err != nil {
+			return err
+		}
+		return saveLogin(state, statePath, baseURL, exResp)
+	}
 
 	var cb *cliLoginCallback
 	callbackURL := ""
@@ -142,8 +185,16 @@ func runLogin(args []string) error {
 		}
 	}
 
+	// The verifier never leaves this process until the exchange, so a code
+	// intercepted from the loopback redirect cannot be redeemed elsewhere.
+	pkce, err := serverapi.NewPKCE()
+	if err != nil {
+		return err
+	}
 	startResp, err := client.StartCLILogin(context.Background(), serverapi.CLILoginStartRequest{
-		CallbackURL: callbackURL,
+		CallbackURL:         callbackURL,
+		CodeChallenge:       pkce.Challenge,
+		CodeChallengeMethod: pkce.Method,
 	})
 	if err != nil {
 		return err
@@ -195,12 +246,18 @@ func runLogin(args []string) error {
 	}
 
 	exResp, err := client.ExchangeCLILogin(context.Background(), serverapi.CLILoginExchangeRequest{
-		Code:  code,
-		State: strings.TrimSpace(startResp.State),
+		Code:         code,
+		State:        strings.TrimSpace(startResp.State),
+		CodeVerifier: pkce.Verifier,
 	})
 	if err != nil {
 		return err
 	}
+	return saveLogin(state, statePath, baseURL, exResp)
+}
+
+// saveLogin records a successful login in auth.toml.
+func saveLogin(state authstate.State, statePath, baseURL string, exResp serverapi.CLILoginExchangeResponse) error {
 	if strings.TrimSpace(exResp.AccessToken) == "" {
 		return errors.New("server returned empty access token")
 	}
@@ -267,11 +324,20 @@ func runWhoami(args []string) error {
 	if strings.TrimSpace(state.AccessToken) == "" {
 		return errors.New("no access token stored; run `envlock login`")
 	}
-	client, err := serverapi.New(baseURL)
+	// Only the logged-in server can renew the stored tokens; an overridden
+	// server gets the access token as-is.
+	var client *serverapi.Client
+	token := ""
+	if strings.TrimRight(baseURL, "/") == state.ServerURL {
+		client, err = newSessionClient(state, statePath)
+	} else {
+		client, err = newServerClient(baseURL)
+		token = state.AccessToken
+	}
 	if err != nil {
 		return err
 	}
-	user, err := client.WhoAmI(context.Background(), state.AccessToken)
+	user, err := client.WhoAmI(context.Background(), token)
 	if err != nil {
 		return err
 	}
@@ -283,6 +349,57 @@ func runWhoami(args []string) error {
 	return nil
 }
 
+func runLogout(args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	allSessions := fs.Bool("all-sessions", false, "revoke every login of this account, not just this machine")
+	user := fs.String("user", "", "revoke every login of another user by email (server admins only; keeps this login)")
+	localOnly := fs.Bool("local-only", false, "only remove local credentials, without contacting the server")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("logout does not accept positional arguments")
+	}
+	state, statePath, err := authstate.LoadDefault()
+	if err != nil {
+		if errors.Is(err, authstate.ErrNotFound) {
+			return errors.New("not logged in")
+		}
+		return err
+	}
+	client, err := newSessionClient(state, statePath)
+	if err != nil {
+		return err
+	}
+
+	if email := strings.TrimSpace(*user); email != "" {
+		resp, err := client.AdminRevokeSessions(context.Background(), "", serverapi.AdminRevokeSessionsRequest{Email: email})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Revoked %d session(s) for %s on %s\n", resp.Revoked, email, state.ServerURL)
+		return nil
+	}
+
+	if !*localOnly {
+		resp, err := client.Logout(context.Background(), "", serverapi.LogoutRequest{AllSessions: *allSessions})
+		switch {
+		case err == nil:
+			fmt.Printf("Revoked %d session(s) on %s\n", resp.Revoked, state.ServerURL)
+		case errors.Is(err, serverapi.ErrSessionExpired), serverapi.StatusCode(err) == http.StatusUnauthorized:
+			fmt.Println("Server session already expired or revoked.")
+		default:
+			return fmt.Errorf("revoke session on %s: %w (use --local-only to only remove local credentials)", state.ServerURL, err)
+		}
+	}
+	if err := authstate.Delete(statePath); err != nil {
+		return err
+	}
+	fmt.Printf("Removed local credentials: %s\n", statePath)
+	return nil
+}
+
 func printCachedWhoami(state authstate.State) {
 	if state.User.ID != "" {
 		fmt.Printf("User ID (cached): %s\n", state.User.ID)
@@ -298,6 +415,45 @@ func printCachedWhoami(state authstate.State) {
 	}
 }
 
+// newSessionClient returns a client for the logged-in server that renews
+// expired access tokens automatically and rewrites auth.toml with each new
+// token pair.
+func newSessionClient(state authstate.State, statePath string) (*serverapi.Client, error) {
+	client, err := newServerClient(state.ServerURL)
+	if err != nil {
+		return nil, err
+	}
+	client.UseSession(serverapi.NewSession(state.AccessToken, state.RefreshToken, state.ExpiresAt, func(tok serverapi.CLILoginExchangeResponse) error {
+		state.AccessToken = tok.AccessToken
+		state.RefreshToken = tok.RefreshToken
+		state.ExpiresAt = tok.ExpiresAt
+		if tok.User.ID != "" {
+			state.User = authstate.User{
+				ID:          tok.User.ID,
+				Email:       tok.User.Email,
+				DisplayName: tok.User.DisplayName,
+			}
+		}
+		return authstate.Write(statePath, state)
+	}))
+	return client, nil
+}
+
+// newServerClient returns a client that identifies this machine's default
+// device key to the server for its audit log.
+func newServerClient(baseURL string) (*serverapi.Client, error) {
+	client, err := serverapi.New(baseURL)
+	if err != nil {
+		return nil, err
+	}
+	if idPath, err := keys.DefaultKeyPath("default"); err == nil {
+		if id, _, err := keys.LoadIdentity(idPath); err == nil {
+			client.SetDevice(keys.Fingerprint(id.Recipient().String()))
+		}
+	}
+	return client, nil
+}
+
 func loadAuthStateOptional() (authstate.State, string, error) {
 	s, path, err := authstate.LoadDefault()
 	if err == nil {
@@ -401,32 +557,6 @@ func openBrowser(target string) error {
 	}
 }
 
-func runSecrets(args []string) error {
-	if len(args) == 0 {
-		printSecretsUsage()
-		return nil
-	}
-	switch args[0] {
-	case "push", "pull", "ls", "status", "rekey":
-		return fmt.Errorf("secrets %s is not implemented yet (server-backed secrets workflow planned)", args[0])
-	case "help", "--help", "-h":
-		printSecretsUsage()
-		return nil
-	default:
-		return fmt.Errorf("unknown secrets command %q", args[0])
-	}
-}
-
-func printSecretsUsage() {
-	fmt.Println("Usage:")
-	fmt.Println("  envlock secrets push <path>")
-	fmt.Println("  envlock secrets pull <name> [--out <path>] [--force]")
-	fmt.Println("  envlock secrets ls")
-	fmt.Println("  envlock secrets status")
-	fmt.Println("  envlock secrets rekey <name>")
-	fmt.Println("  envlock secrets rekey --all")
-}
-
 func runInvite(args []string) error {
 	if len(args) == 0 {
 		printInviteUsage()
@@ -506,7 +636,7 @@ func runRequests(args []string) error {
 func printRequestsUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  envlock requests ls [--all]")
-	fmt.Println("  envlock requests approve <request-id> [--note <text>]")
+	fmt.Println("  envlock requests approve <request-id> [--sas <code>] [--token <invite-token>] [--note <text>] [--rekey <name,...> | --rekey-all]")
 	fmt.Println("  envlock requests reject <request-id> [--reason <text>]")
 }
 
@@ -587,13 +717,15 @@ func runStatus(args []string) error {
 	if err == nil {
 		fmt.Printf("Project config: %s\n", projPath)
 		fmt.Printf("App: %s\n", proj.AppName)
-		fmt.Printf("Bucket: %s\n", proj.Bucket)
+		printProjectLocation(proj)
 		fmt.Printf("Prefix: %s\n", proj.Prefix)
-		rs, err := remote.New(context.Background(), proj)
+		rs, err := openStore(context.Background(), proj, projPath)
 		if err != nil {
 			fmt.Printf("Recipients: unavailable (%v)\n", err)
 		} else if r, err := rs.LoadRecipients(context.Background()); err == nil {
-			fmt.Printf("Recipients (Tigris): %d active / %d total\n", r.ActiveCount(), len(r.Recipients))
+			fmt.Printf("Recipients (%s): %d active / %d total\n", backendLabel(proj), r.ActiveCount(), len(r.Recipients))
+		} else if _, ok := unverifiedOnFailure(rs, err); ok {
+			fmt.Printf("Recipients (%s): untrusted (%v)\n", backendLabel(proj), err)
 		} else {
 			return err
 		}
@@ -615,9 +747,11 @@ func runProject(args []string) error {
 	case "init":
 		return runProjectInit(args[1:])
 	case "create":
-		return runProjectInit(args[1:])
+		return runProjectCreate(args[1:])
 	case "use":
 		return runProjectUse(args[1:])
+	case "ls":
+		return runProjectList(args[1:])
 	case "show":
 		return runProjectShow(args[1:])
 	case "help", "--help", "-h":
@@ -631,28 +765,24 @@ func runProject(args []string) error {
 func printProjectUsage() {
 	fmt.Println("Usage:")
 	fmt.Println("  envlock project init --app <name> --bucket <bucket>")
-	fmt.Println("  envlock project create --app <name> --bucket <bucket>   # alias (current Tigris path)")
-	fmt.Println("  envlock project use <name>                               # planned server-backed flow")
+	fmt.Println("  envlock project init --app <name> --backend localfs --path <dir>")
+	fmt.Println("  envlock project init --app <name> --backend server --server <url> --project-id <id>")
+	fmt.Println("  envlock project create --app <name> --bucket <bucket>   # alias of project init")
+	fmt.Println("  envlock project create <name> [--app <name>]             # new project on the logged-in server")
+	fmt.Println("  envlock project use <name> [--app <name>]                # select an existing server project")
+	fmt.Println("  envlock project ls                                       # server projects you belong to")
 	fmt.Println("  envlock project show")
 }
 
-func runProjectUse(args []string) error {
-	fs := flag.NewFlagSet("project use", flag.ContinueOnError)
-	fs.SetOutput(os.Stdout)
-	if err := fs.Parse(args); err != nil {
-		return err
-	}
-	if fs.NArg() != 1 {
-		return errors.New("usage: envlock project use <name>")
-	}
-	return errors.New("project use is not implemented yet (planned: select server-backed project)")
-}
-
 func runProjectInit(args []string) error {
 	fs := flag.NewFlagSet("project init", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	appName := fs.String("app", "", "application name (defaults to current folder name)")
-	bucket := fs.String("bucket", "", "Tigris bucket name (required)")
+	backendName := fs.String("backend", config.BackendTigris, "metadata backend: tigris, localfs or server")
+	bucket := fs.String("bucket", "", "Tigris bucket name (required for tigris)")
+	storePath := fs.String("path", "", "localfs backend directory (required for localfs)")
+	serverURL := fs.String("server", "", "envlock server URL (required for server)")
+	projectID := fs.String("project-id", "", "envlock server project ID (required for server)")
 	prefix := fs.String("prefix", "", "object prefix (defaults to <app>)")
 	endpoint := fs.String("endpoint", "", "optional S3 endpoint override")
 	keyName := fs.String("key-name", "default", "local key profile used for auto-adding this device")
@@ -664,8 +794,21 @@ func runProjectInit(args []string) error {
 	if fs.NArg() != 0 {
 		return errors.New("project init does not accept positional arguments")
 	}
-	if strings.TrimSpace(*bucket) == "" {
-		return errors.New("--bucket is required")
+	switch strings.TrimSpace(*backendName) {
+	case config.BackendTigris:
+		if strings.TrimSpace(*bucket) == "" {
+			return errors.New("--bucket is required")
+		}
+	case config.BackendLocalFS:
+		if strings.TrimSpace(*storePath) == "" {
+			return errors.New("--path is required for the localfs backend")
+		}
+	case config.BackendServer:
+		if strings.TrimSpace(*serverURL) == "" || strings.TrimSpace(*projectID) == "" {
+			return errors.New("--server and --project-id are required for the server backend")
+		}
+	default:
+		return fmt.Errorf("unknown --backend %q (expected %s, %s or %s)", *backendName, config.BackendTigris, config.BackendLocalFS, config.BackendServer)
 	}
 	app := strings.TrimSpace(*appName)
 	if app == "" {
@@ -708,32 +851,24 @@ func runProjectInit(args []string) error {
 		Prefix:   pfx,
 		Endpoint: strings.TrimSpace(*endpoint),
 	}
-	rs, err := remote.New(context.Background(), proj)
-	if err != nil {
-		return fmt.Errorf("initialize remote metadata store: %w", err)
+	switch *backendName {
+	case config.BackendLocalFS:
+		proj.Backend = config.BackendLocalFS
+		proj.Path = strings.TrimSpace(*storePath)
+	case config.BackendServer:
+		proj.Backend = config.BackendServer
+		proj.Server = strings.TrimRight(strings.TrimSpace(*serverURL), "/")
+		proj.ProjectID = strings.TrimSpace(*projectID)
 	}
-	store, err := rs.LoadRecipients(context.Background())
+	rs, err := openStore(context.Background(), proj, projPath)
 	if err != nil {
-		return err
+		return fmt.Errorf("initialize remote metadata store: %w", err)
 	}
 	name := strings.TrimSpace(*deviceName)
 	if name == "" {
 		name = meta.DeviceName
 	}
-	if err := store.Add(recipients.Recipient{
-		Name:        name,
-		PublicKey:   id.Recipient().String(),
-		Fingerprint: keys.Fingerprint(id.Recipient().String()),
-		CreatedAt:   time.Now().UTC(),
-		Status:      recipients.StatusActive,
-		Source:      "local-init",
-		Note:        "Added during project init",
-	}); err != nil {
-		if !errors.Is(err, recipients.ErrDuplicateRecipient) {
-			return err
-		}
-	}
-	if err := rs.WriteRecipients(context.Background(), store); err != nil {
+	if err := addDeviceRecipient(context.Background(), rs, name, id.Recipient().String(), "Added during project init"); err != nil {
 		return err
 	}
 	if err := config.WriteProject(projPath, proj); err != nil {
@@ -741,11 +876,43 @@ func runProjectInit(args []string) error {
 	}
 
 	fmt.Printf("Project initialized: %s\n", projPath)
-	fmt.Printf("Remote recipients object initialized in bucket %q under prefix %q\n", proj.Bucket, proj.Prefix)
+	switch proj.BackendName() {
+	case config.BackendLocalFS:
+		fmt.Printf("Recipients initialized in %s under prefix %q\n", proj.Path, proj.Prefix)
+	case config.BackendServer:
+		fmt.Printf("Recipients initialized on %s for project %q\n", proj.Server, proj.ProjectID)
+	default:
+		fmt.Printf("Remote recipients object initialized in bucket %q under prefix %q\n", proj.Bucket, proj.Prefix)
+	}
 	fmt.Printf("Added local device recipient: %s (%s)\n", name, keys.Fingerprint(id.Recipient().String()))
 	return nil
 }
 
+// addDeviceRecipient adds this device as an active recipient of rs. A device
+// that is already listed is left as is.
+func addDeviceRecipient(ctx context.Context, rs backend.Store, name, publicKey, note string) error {
+	return retryOnConflict(func() error {
+		store, err := rs.LoadRecipients(ctx)
+		if err != nil {
+			return err
+		}
+		if err := store.Add(recipients.Recipient{
+			Name:        name,
+			PublicKey:   publicKey,
+			Fingerprint: keys.Fingerprint(publicKey),
+			CreatedAt:   time.Now().UTC(),
+			Status:      recipients.StatusActive,
+			Source:      "local-init",
+			Note:        note,
+		}); err != nil {
+			if !errors.Is(err, recipients.ErrDuplicateRecipient) {
+				return err
+			}
+		}
+		return rs.WriteRecipients(ctx, store)
+	})
+}
+
 func runProjectShow(args []string) error {
 	fs := flag.NewFlagSet("project show", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
@@ -762,11 +929,14 @@ func runProjectShow(args []string) error {
 	fmt.Printf("Project file: %s\n", projPath)
 	fmt.Printf("Version: %d\n", proj.Version)
 	fmt.Printf("App: %s\n", proj.AppName)
-	fmt.Printf("Bucket: %s\n", proj.Bucket)
+	printProjectLocation(proj)
 	fmt.Printf("Prefix: %s\n", proj.Prefix)
 	if proj.Endpoint != "" {
 		fmt.Printf("Endpoint: %s\n", proj.Endpoint)
 	}
+	if proj.TrustRoot != "" {
+		fmt.Printf("Trust root: %s\n", proj.TrustRoot)
+	}
 	return nil
 }
 
@@ -798,17 +968,103 @@ func printRecipientsUsage() {
 }
 
 func remoteStoreFromCWD(ctx context.Context) (backend.Store, config.Project, error) {
-	proj, _, err := config.LoadProjectFromCWD()
+	proj, projPath, err := config.LoadProjectFromCWD()
 	if err != nil {
 		return nil, config.Project{}, err
 	}
-	rs, err := remote.New(ctx, proj)
+	rs, err := openStore(ctx, proj, projPath)
 	if err != nil {
 		return nil, config.Project{}, err
 	}
 	return rs, proj, nil
 }
 
+// openStore returns the backend selected by the project's `backend` setting.
+// Projects that pin a trust root get a store that verifies recipients and
+// signs recipient writes.
+func openStore(ctx context.Context, proj config.Project, projPath string) (backend.Store, error) {
+	rs, err := openBackend(ctx, proj, projPath)
+	if err != nil {
+		return nil, err
+	}
+	if root := strings.TrimSpace(proj.TrustRoot); root != "" {
+		return signed.New(rs, root, defaultAdminKey, trustPin(proj, projPath)), nil
+	}
+	return rs, nil
+}
+
+// openBackend returns the unverified backend for proj.
+func openBackend(ctx context.Context, proj config.Project, projPath string) (backend.Store, error) {
+	switch proj.BackendName() {
+	case config.BackendTigris:
+		return remote.New(ctx, proj)
+	case config.BackendLocalFS:
+		return localfs.New(proj, config.ProjectRoot(projPath))
+	case config.BackendServer:
+		client, err := projectClient(proj)
+		if err != nil {
+			return nil, err
+		}
+		return server.New(proj, client)
+	default:
+		return nil, fmt.Errorf("unknown project backend %q", proj.Backend)
+	}
+}
+
+// unverifiedOnFailure returns the unverified backend behind rs when err is a
+// failed recipients signature check.
+func unverifiedOnFailure(rs backend.Store, err error) (backend.Store, bool) {
+	var verr *signed.VerificationError
+	ss, ok := rs.(*signed.Store)
+	if !ok || !errors.As(err, &verr) {
+		return nil, false
+	}
+	return ss.Unverified(), true
+}
+
+func backendLabel(proj config.Project) string {
+	switch proj.BackendName() {
+	case config.BackendLocalFS:
+		return "localfs"
+	case config.BackendServer:
+		return "envlock server"
+	default:
+		return "Tigris"
+	}
+}
+
+func printProjectLocation(proj config.Project) {
+	fmt.Printf("Backend: %s\n", proj.BackendName())
+	switch proj.BackendName() {
+	case config.BackendLocalFS:
+		fmt.Printf("Path: %s\n", proj.Path)
+	case config.BackendServer:
+		fmt.Printf("Server: %s\n", proj.Server)
+		fmt.Printf("Project ID: %s\n", proj.ProjectID)
+	default:
+		fmt.Printf("Bucket: %s\n", proj.Bucket)
+	}
+}
+
+// conflictRetries bounds how often a read-modify-write of remote metadata is
+// replayed after losing an optimistic concurrency race.
+const conflictRetries = 5
+
+// retryOnConflict runs fn, which must reload everything it modifies, until it
+// succeeds, fails with a non-conflict error, or runs out of attempts.
+func retryOnConflict(fn func() error) error {
+	var err error
+	for attempt := 1; attempt <= conflictRetries; attempt++ {
+		err = fn()
+		if !errors.Is(err, backend.ErrConflict) {
+			return err
+		}
+		fmt.Printf("Remote metadata changed concurrently (%v); retrying (%d/%d)\n", err, attempt, conflictRetries)
+		time.Sleep(time.Duration(attempt*attempt) * 100 * time.Millisecond)
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", conflictRetries, err)
+}
+
 func runRecipientsList(args []string) error {
 	fs := flag.NewFlagSet("recipients list", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
@@ -824,6 +1080,11 @@ func runRecipientsList(args []string) error {
 		return err
 	}
 	store, err := rs.LoadRecipients(context.Background())
+	if raw, ok := unverifiedOnFailure(rs, err); ok {
+		fmt.Printf("Warning: %v\n", err)
+		fmt.Println("Listing the unverified recipient set; review it with `envlock trust sign`.")
+		store, err = raw.LoadRecipients(context.Background())
+	}
 	if err != nil {
 		return err
 	}
@@ -873,24 +1134,27 @@ func runRecipientsAdd(args []string) error {
 	if err != nil {
 		return err
 	}
-	store, err := rs.LoadRecipients(context.Background())
+	err = retryOnConflict(func() error {
+		store, err := rs.LoadRecipients(context.Background())
+		if err != nil {
+			return err
+		}
+		if err := store.Add(recipients.Recipient{
+			Name:        name,
+			PublicKey:   pub,
+			Fingerprint: keys.Fingerprint(pub),
+			CreatedAt:   time.Now().UTC(),
+			Status:      recipients.StatusActive,
+			Source:      "manual",
+			Note:        strings.TrimSpace(*note),
+		}); err != nil {
+			return err
+		}
+		return rs.WriteRecipients(context.Background(), store)
+	})
 	if err != nil {
 		return err
 	}
-	if err := store.Add(recipients.Recipient{
-		Name:        name,
-		PublicKey:   pub,
-		Fingerprint: keys.Fingerprint(pub),
-		CreatedAt:   time.Now().UTC(),
-		Status:      recipients.StatusActive,
-		Source:      "manual",
-		Note:        strings.TrimSpace(*note),
-	}); err != nil {
-		return err
-	}
-	if err := rs.WriteRecipients(context.Background(), store); err != nil {
-		return err
-	}
 	fmt.Printf("Added recipient %q (%s)\n", name, keys.Fingerprint(pub))
 	return nil
 }
@@ -910,30 +1174,51 @@ func runRecipientsRemove(args []string) error {
 	if err != nil {
 		return err
 	}
-	store, err := rs.LoadRecipients(context.Background())
-	if err != nil {
+	// Removing from a set that fails verification cannot widen it, but the
+	// result is left unsigned for an admin to review rather than signed
+	// blindly.
+	verifying := rs
+	_, err = rs.LoadRecipients(context.Background())
+	raw, unsigned := unverifiedOnFailure(rs, err)
+	if unsigned {
+		fmt.Printf("Warning: %v\n", err)
+		rs = raw
+	} else if err != nil {
 		return err
 	}
-	if *hard {
-		removed, err := store.Delete(query)
+	var changed recipients.Recipient
+	err = retryOnConflict(func() error {
+		store, err := rs.LoadRecipients(context.Background())
 		if err != nil {
 			return err
 		}
-		if err := rs.WriteRecipients(context.Background(), store); err != nil {
+		if *hard {
+			changed, err = store.Delete(query)
+		} else {
+			changed, err = store.Revoke(query)
+		}
+		if err != nil {
 			return err
 		}
-		fmt.Printf("Deleted recipient %q (%s)\n", removed.Name, removed.Fingerprint)
-		return nil
-	}
-	revoked, err := store.Revoke(query)
+		return rs.WriteRecipients(context.Background(), store)
+	})
 	if err != nil {
 		return err
 	}
-	if err := rs.WriteRecipients(context.Background(), store); err != nil {
-		return err
+	if unsigned {
+		if _, err := verifying.LoadRecipients(context.Background()); err == nil {
+			fmt.Println("The recipient set matches its last signature again.")
+		} else {
+			fmt.Println("The recipient set is still unsigned; review and sign it with `envlock trust sign`.")
+		}
+	}
+	if *hard {
+		fmt.Printf("Deleted recipient %q (%s)\n", changed.Name, changed.Fingerprint)
+		return nil
 	}
+	revoked := changed
 	fmt.Printf("Revoked recipient %q (%s)\n", revoked.Name, revoked.Fingerprint)
-	fmt.Println("Note: existing encrypted blobs remain decryptable until rekeyed.")
+	fmt.Println("Note: existing encrypted blobs remain decryptable until rekeyed (run `envlock secrets rekey --all`).")
 	return nil
 }
 
@@ -967,7 +1252,7 @@ func printEnrollUsage() {
 	fmt.Println("  envlock enroll join <invite-token-or-url> [--name <device-name>]")
 	fmt.Println("  envlock enroll join --token <invite-token-or-url> [--name <device-name>]")
 	fmt.Println("  envlock enroll list [--all]")
-	fmt.Println("  envlock enroll approve <request-id>")
+	fmt.Println("  envlock enroll approve <request-id> [--sas <code>] [--token <invite-token>] [--rekey <name,...> | --rekey-all]")
 	fmt.Println("  envlock enroll reject <request-id> [--reason <text>]")
 }
 
@@ -986,17 +1271,22 @@ func runEnrollInvite(args []string) error {
 		return errors.New("--ttl must be > 0")
 	}
 
-	rs, _, err := remoteStoreFromCWD(context.Background())
-	if err != nil {
-		return err
-	}
-
 	var createdBy string
 	if keyPath, err := keys.DefaultKeyPath(*keyName); err == nil {
 		if _, meta, err := keys.LoadIdentity(keyPath); err == nil {
 			createdBy = meta.DeviceName
 		}
 	}
+	if proj, ok, err := serverProjectFromCWD(); err != nil {
+		return err
+	} else if ok {
+		return serverEnrollInvite(proj, *ttl, createdBy)
+	}
+
+	rs, proj, err := remoteStoreFromCWD(context.Background())
+	if err != nil {
+		return err
+	}
 
 	invite, token, err := enroll.NewInvite(*ttl, createdBy)
 	if err != nil {
@@ -1005,10 +1295,13 @@ func runEnrollInvite(args []string) error {
 	if err := rs.SaveInvite(context.Background(), invite); err != nil {
 		return err
 	}
+	if err := saveInviteToken(token); err != nil {
+		fmt.Printf("Warning: could not save the invite token for `enroll approve`: %v\n", err)
+	}
 
 	fmt.Printf("Created invite: %s\n", invite.ID)
 	fmt.Printf("Expires at: %s\n", invite.ExpiresAt.Format(time.RFC3339))
-	fmt.Println("Invite storage: Tigris (project metadata)")
+	fmt.Printf("Invite storage: %s (project metadata)\n", backendLabel(proj))
 	fmt.Printf("Invite token (share with new machine): %s\n", token)
 	return nil
 }
@@ -1034,23 +1327,7 @@ func runEnrollJoin(args []string) error {
 		return errors.New("invite token is required (pass <token-or-url> or --token)")
 	}
 
-	rs, _, err := remoteStoreFromCWD(context.Background())
-	if err != nil {
-		return err
-	}
-
-	inviteID, _, err := enroll.ParseToken(resolvedToken)
-	if err != nil {
-		return err
-	}
-	invite, err := rs.LoadInvite(context.Background(), inviteID)
-	if err != nil {
-		return err
-	}
-	if err := enroll.VerifyToken(invite, resolvedToken); err != nil {
-		return err
-	}
-	if err := enroll.ValidateInviteForJoin(invite, time.Now().UTC()); err != nil {
+	if _, _, err := enroll.ParseToken(resolvedToken); err != nil {
 		return err
 	}
 
@@ -1070,6 +1347,36 @@ func runEnrollJoin(args []string) error {
 		name = "device"
 	}
 
+	// A new machine has no project config yet; with a server login the
+	// token alone is enough to find the project.
+	proj, projPath, err := config.LoadProjectFromCWD()
+	switch {
+	case err == nil && proj.BackendName() == config.BackendServer:
+		return serverEnrollJoin(proj, true, resolvedToken, name, id.Recipient().String())
+	case errors.Is(err, config.ErrProjectNotFound):
+		if state, _, serr := loadAuthStateOptional(); serr == nil && strings.TrimSpace(state.AccessToken) != "" {
+			return serverEnrollJoin(config.Project{}, false, resolvedToken, name, id.Recipient().String())
+		}
+		return err
+	case err != nil:
+		return err
+	}
+	rs, err := openStore(context.Background(), proj, projPath)
+	if err != nil {
+		return err
+	}
+	inviteID, _, _ := enroll.ParseToken(resolvedToken)
+	invite, err := rs.LoadInvite(context.Background(), inviteID)
+	if err != nil {
+		return err
+	}
+	if err := enroll.VerifyToken(invite, resolvedToken); err != nil {
+		return err
+	}
+	if err := enroll.ValidateInviteForJoin(invite, time.Now().UTC()); err != nil {
+		return err
+	}
+
 	existing, err := rs.ListRequests(context.Background())
 	if err != nil {
 		return err
@@ -1083,8 +1390,19 @@ func runEnrollJoin(args []string) error {
 	}
 
 	fmt.Printf("Created enrollment request: %s\n", req.ID)
-	fmt.Println("Request storage: Tigris (project metadata)")
+	fmt.Printf("Request storage: %s (project metadata)\n", backendLabel(proj))
 	fmt.Printf("Device: %s (%s)\n", req.DeviceName, req.Fingerprint)
+	return printJoinCode(resolvedToken, req.PublicKey)
+}
+
+// printJoinCode shows the verification code the approver must enter.
+func printJoinCode(token, publicKey string) error {
+	code, err := enroll.SAS(token, publicKey)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Verification code: %s\n", code)
+	fmt.Println("Tell the approver this code over a channel you trust; `enroll approve` asks for it.")
 	return nil
 }
 
@@ -1158,76 +1476,135 @@ func runEnrollApprove(args []string) error {
 	fs := flag.NewFlagSet("enroll approve", flag.ContinueOnError)
 	fs.SetOutput(os.Stdout)
 	note := fs.String("note", "", "optional approval note")
+	sas := fs.String("sas", "", "verification code printed by `enroll join` on the new device (prompted for if omitted)")
+	token := fs.String("token", "", "invite token, if `invite create` ran on another machine")
+	rekey := fs.String("rekey", "", "comma-separated secrets to re-encrypt for the new device after approving")
+	rekeyAll := fs.Bool("rekey-all", false, "re-encrypt every secret for the new device after approving")
+	keyName := fs.String("key-name", "default", "local key profile used to decrypt secrets for --rekey")
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
 	if fs.NArg() != 1 {
-		return errors.New("usage: envlock enroll approve <request-id> [--note <text>]")
+		return errors.New("usage: envlock enroll approve <request-id> [--sas <code>] [--token <invite-token>] [--note <text>] [--rekey <name,...> | --rekey-all]")
 	}
 	reqID := strings.TrimSpace(fs.Arg(0))
 
-	rs, _, err := remoteStoreFromCWD(context.Background())
+	rs, proj, err := remoteStoreFromCWD(context.Background())
 	if err != nil {
 		return err
 	}
-
-	req, err := rs.LoadRequest(context.Background(), reqID)
+	verified, err := rs.LoadRequest(context.Background(), reqID)
 	if err != nil {
 		return err
 	}
-	if req.Status != enroll.RequestStatusPending {
-		return fmt.Errorf("request %s is %s (expected pending)", req.ID, req.Status)
+	if verified.Status != enroll.RequestStatusPending {
+		return fmt.Errorf("request %s is %s (expected pending)", verified.ID, verified.Status)
 	}
-
-	invite, err := rs.LoadInvite(context.Background(), req.InviteID)
-	if err != nil {
+	if err := confirmJoinCode(verified, *token, *sas); err != nil {
 		return err
 	}
-	if err := enroll.ValidateInviteForApproval(invite); err != nil {
+	plan, err := planApprovalRekey(context.Background(), rs, *rekey, *rekeyAll, *keyName)
+	if err != nil {
 		return err
 	}
+	if proj.BackendName() == config.BackendServer {
+		if err := serverEnrollApprove(proj, reqID, verified.Fingerprint, strings.TrimSpace(*note)); err != nil {
+			return err
+		}
+		removeInviteToken(verified.InviteID)
+		return plan.run(context.Background(), rs, verified.ID, verified.DeviceName)
+	}
 
-	store, err := rs.LoadRecipients(context.Background())
+	// Each object gets its own read-modify-write step, so a conflict on a
+	// later write retries only that write instead of re-running steps that
+	// already landed.
+	invite, err := rs.LoadInvite(context.Background(), verified.InviteID)
 	if err != nil {
 		return err
 	}
-	addErr := store.Add(recipients.Recipient{
-		Name:        req.DeviceName,
-		PublicKey:   req.PublicKey,
-		Fingerprint: req.Fingerprint,
-		CreatedAt:   time.Now().UTC(),
-		Status:      recipients.StatusActive,
-		Source:      "enroll-approve",
-		Note:        "Added via enrollment request " + req.ID,
-	})
-	if addErr != nil && !errors.Is(addErr, recipients.ErrDuplicateRecipient) {
-		return addErr
+	if err := enroll.ValidateInviteForApproval(invite); err != nil {
+		return err
 	}
-	if err := rs.WriteRecipients(context.Background(), store); err != nil {
+
+	var addErr error
+	err = retryOnConflict(func() error {
+		store, err := rs.LoadRecipients(context.Background())
+		if err != nil {
+			return err
+		}
+		addErr = store.Add(recipients.Recipient{
+			Name:        verified.DeviceName,
+			PublicKey:   verified.PublicKey,
+			Fingerprint: verified.Fingerprint,
+			CreatedAt:   time.Now().UTC(),
+			Status:      recipients.StatusActive,
+			Source:      "enroll-approve",
+			Note:        "Added via enrollment request " + verified.ID,
+		})
+		if errors.Is(addErr, recipients.ErrDuplicateRecipient) {
+			return nil
+		}
+		if addErr != nil {
+			return addErr
+		}
+		return rs.WriteRecipients(context.Background(), store)
+	})
+	if err != nil {
 		return err
 	}
 
-	now := time.Now().UTC()
-	req.Status = enroll.RequestStatusApproved
-	req.DecisionAt = now
-	req.DecisionNote = strings.TrimSpace(*note)
-	if err := rs.SaveRequest(context.Background(), req); err != nil {
+	var req enroll.Request
+	err = retryOnConflict(func() error {
+		req, err = rs.LoadRequest(context.Background(), reqID)
+		if err != nil {
+			return err
+		}
+		if req.PublicKey != verified.PublicKey {
+			return fmt.Errorf("request %s changed after it was verified; not approving", req.ID)
+		}
+		if req.Status == enroll.RequestStatusApproved {
+			// A write reported as a conflict may still have landed.
+			return nil
+		}
+		if req.Status != enroll.RequestStatusPending {
+			return fmt.Errorf("request %s is %s (expected pending)", req.ID, req.Status)
+		}
+		req.Status = enroll.RequestStatusApproved
+		req.DecisionAt = time.Now().UTC()
+		req.DecisionNote = strings.TrimSpace(*note)
+		return rs.SaveRequest(context.Background(), req)
+	})
+	if err != nil {
 		return err
 	}
 
-	invite.Status = enroll.InviteStatusUsed
-	invite.UsedByRequestID = req.ID
-	invite.UsedAt = now
-	if err := rs.SaveInvite(context.Background(), invite); err != nil {
+	err = retryOnConflict(func() error {
+		invite, err := rs.LoadInvite(context.Background(), req.InviteID)
+		if err != nil {
+			return err
+		}
+		if invite.Status == enroll.InviteStatusUsed && invite.UsedByRequestID == req.ID {
+			return nil
+		}
+		if err := enroll.ValidateInviteForApproval(invite); err != nil {
+			return fmt.Errorf("request %s is approved, but its invite could not be marked used: %w", req.ID, err)
+		}
+		invite.Status = enroll.InviteStatusUsed
+		invite.UsedByRequestID = req.ID
+		invite.UsedAt = req.DecisionAt
+		return rs.SaveInvite(context.Background(), invite)
+	})
+	if err != nil {
 		return err
 	}
 
+	removeInviteToken(req.InviteID)
 	if addErr != nil && errors.Is(addErr, recipients.ErrDuplicateRecipient) {
 		fmt.Printf("Approved request %s (recipient already existed): %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
 	} else {
 		fmt.Printf("Approved request %s and added recipient: %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
 	}
-	return nil
+	return plan.run(context.Background(), rs, req.ID, req.DeviceName)
 }
 
 func runEnrollReject(args []string) error {
@@ -1241,24 +1618,33 @@ func runEnrollReject(args []string) error {
 		return errors.New("usage: envlock enroll reject <request-id> [--reason <text>]")
 	}
 	reqID := strings.TrimSpace(fs.Arg(0))
+	if proj, ok, err := serverProjectFromCWD(); err != nil {
+		return err
+	} else if ok {
+		return serverEnrollReject(proj, reqID, strings.TrimSpace(*reason))
+	}
 
 	rs, _, err := remoteStoreFromCWD(context.Background())
 	if err != nil {
 		return err
 	}
-	req, err := rs.LoadRequest(context.Background(), reqID)
+	var req enroll.Request
+	err = retryOnConflict(func() error {
+		req, err = rs.LoadRequest(context.Background(), reqID)
+		if err != nil {
+			return err
+		}
+		if req.Status != enroll.RequestStatusPending {
+			return fmt.Errorf("request %s is %s (expected pending)", req.ID, req.Status)
+		}
+		req.Status = enroll.RequestStatusRejected
+		req.DecisionAt = time.Now().UTC()
+		req.DecisionNote = strings.TrimSpace(*reason)
+		return rs.SaveRequest(context.Background(), req)
+	})
 	if err != nil {
 		return err
 	}
-	if req.Status != enroll.RequestStatusPending {
-		return fmt.Errorf("request %s is %s (expected pending)", req.ID, req.Status)
-	}
-	req.Status = enroll.RequestStatusRejected
-	req.DecisionAt = time.Now().UTC()
-	req.DecisionNote = strings.TrimSpace(*reason)
-	if err := rs.SaveRequest(context.Background(), req); err != nil {
-		return err
-	}
 	fmt.Printf("Rejected request %s for %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
 	return nil
 }
@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/core/serverapi"
+)
+
+func runAudit(args []string) error {
+	if len(args) == 0 {
+		printAuditUsage()
+		return nil
+	}
+	switch args[0] {
+	case "ls":
+		return runAuditList(args[1:])
+	case "help", "--help", "-h":
+		printAuditUsage()
+		return nil
+	default:
+		return fmt.Errorf("unknown audit command %q", args[0])
+	}
+}
+
+func printAuditUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  envlock audit ls [--since <24h|2006-01-02|RFC3339>] [--until <...>] [--actor <email>] [--action <action>]")
+	fmt.Println("                   [--limit <n>] [--cursor <id>] [--all]")
+	fmt.Println()
+	fmt.Println("Lists the current server project's audit events, newest first (project admins).")
+	fmt.Println("--all lists events across every project, including logins (server admins).")
+}
+
+func runAuditList(args []string) error {
+	fs := flag.NewFlagSet("audit ls", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	since := fs.String("since", "", "only events at or after this time (a duration like 24h, a date, or RFC 3339)")
+	until := fs.String("until", "", "only events before this time (same formats as --since)")
+	actor := fs.String("actor", "", "only events by this user (email or user ID)")
+	action := fs.String("action", "", "only events with this action (e.g. secret.pull, login)")
+	limit := fs.Int("limit", 50, "maximum number of events")
+	cursor := fs.String("cursor", "", "continue from a previous page")
+	all := fs.Bool("all", false, "events across all projects, including logins (server admins only)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("audit ls does not accept positional arguments")
+	}
+	q := serverapi.AuditQuery{
+		Actor:  strings.TrimSpace(*actor),
+		Action: strings.TrimSpace(*action),
+		Before: strings.TrimSpace(*cursor),
+		Limit:  *limit,
+	}
+	var err error
+	if q.Since, err = parseAuditTime(*since); err != nil {
+		return fmt.Errorf("--since: %w", err)
+	}
+	if q.Until, err = parseAuditTime(*until); err != nil {
+		return fmt.Errorf("--until: %w", err)
+	}
+
+	client, state, err := loggedInClient()
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	var page serverapi.AuditPage
+	if *all {
+		page, err = client.ServerAudit(ctx, q)
+	} else {
+		proj, _, perr := config.LoadProjectFromCWD()
+		if perr != nil {
+			return perr
+		}
+		if proj.BackendName() != config.BackendServer {
+			return errors.New("the audit log is kept by the envlock server; this project uses the " + backendLabel(proj) + " backend")
+		}
+		if serverURL := strings.TrimRight(strings.TrimSpace(proj.Server), "/"); serverURL != state.ServerURL {
+			return fmt.Errorf("logged in to %s but project uses %s (run `envlock login --server %s`)", state.ServerURL, serverURL, serverURL)
+		}
+		page, err = client.ProjectAudit(ctx, proj.ProjectID, q)
+	}
+	if err != nil {
+		return err
+	}
+	if len(page.Events) == 0 {
+		fmt.Println("No audit events.")
+		return nil
+	}
+	for _, e := range page.Events {
+		fmt.Printf("%s  %-16s %-24s", e.Time.Local().Format("2006-01-02 15:04:05"), e.Action, e.ActorEmail)
+		if e.Target != "" {
+			fmt.Printf(" %s", e.Target)
+		}
+		if e.Detail != "" {
+			fmt.Printf(" (%s)", e.Detail)
+		}
+		if e.Device != "" {
+			fmt.Printf(" device=%s", e.Device)
+		}
+		if *all && e.ProjectID != "" {
+			fmt.Printf(" project=%s", e.ProjectID)
+		}
+		fmt.Println()
+	}
+	if page.NextCursor != "" {
+		fmt.Printf("More events: rerun with --cursor %s\n", page.NextCursor)
+	}
+	return nil
+}
+
+// parseAuditTime accepts a duration before now ("24h"), a date or an RFC 3339
+// timestamp. Empty means unbounded.
+func parseAuditTime(v string) (time.Time, error) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (use a duration like 24h, a date like 2006-01-02, or RFC 3339)", v)
+}
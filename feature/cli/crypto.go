@@ -0,0 +1,160 @@
+package cli
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/core/envcrypt"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/recipients"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+// stdioPath selects stdin/stdout instead of a file for encrypt/decrypt.
+const stdioPath = "-"
+
+func runEncrypt(args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	recipientsPath := fs.String("recipients", config.RecipientsFilePath("."), "local recipients file")
+	in := fs.String("in", ".env", "plaintext input path (- for stdin)")
+	out := fs.String("out", "", "ciphertext output path (- for stdout, defaults to <in>.envlock)")
+	force := fs.Bool("force", false, "overwrite existing output file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("encrypt does not accept positional arguments")
+	}
+	inPath := strings.TrimSpace(*in)
+	outPath := strings.TrimSpace(*out)
+	if outPath == "" {
+		if inPath == stdioPath {
+			outPath = stdioPath
+		} else {
+			outPath = inPath + secrets.BlobSuffix
+		}
+	}
+
+	store, err := recipients.Load(*recipientsPath)
+	if err != nil {
+		return fmt.Errorf("load recipients (%s): %w", *recipientsPath, err)
+	}
+	pubs := store.ActivePublicKeys()
+	if len(pubs) == 0 {
+		return fmt.Errorf("no active recipients in %s", *recipientsPath)
+	}
+	if err := checkOutput(outPath, *force); err != nil {
+		return err
+	}
+
+	plaintext, err := readInput(inPath)
+	if err != nil {
+		return err
+	}
+	ciphertext, err := envcrypt.Encrypt(plaintext, pubs)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(outPath, ciphertext, 0o644); err != nil {
+		return err
+	}
+	if outPath != stdioPath {
+		fmt.Printf("Encrypted %s -> %s (%d recipients)\n", displayPath(inPath), outPath, len(pubs))
+	}
+	return nil
+}
+
+func runDecrypt(args []string) error {
+	fs := flag.NewFlagSet("decrypt", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	in := fs.String("in", ".env"+secrets.BlobSuffix, "ciphertext input path (- for stdin)")
+	out := fs.String("out", "", "plaintext output path (- for stdout, defaults to <in> without .envlock)")
+	force := fs.Bool("force", false, "overwrite existing output file")
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("decrypt does not accept positional arguments")
+	}
+	inPath := strings.TrimSpace(*in)
+	outPath := strings.TrimSpace(*out)
+	if outPath == "" {
+		switch {
+		case inPath == stdioPath:
+			outPath = stdioPath
+		case strings.HasSuffix(inPath, secrets.BlobSuffix) && inPath != secrets.BlobSuffix:
+			outPath = strings.TrimSuffix(inPath, secrets.BlobSuffix)
+		default:
+			return errors.New("--out is required when --in does not end in .envlock")
+		}
+	}
+
+	keyPath, err := keys.DefaultKeyPath(*keyName)
+	if err != nil {
+		return err
+	}
+	id, _, err := keys.LoadIdentity(keyPath)
+	if err != nil {
+		return fmt.Errorf("load local key (%s): %w (run `envlock init` first)", keyPath, err)
+	}
+	if err := checkOutput(outPath, *force); err != nil {
+		return err
+	}
+
+	ciphertext, err := readInput(inPath)
+	if err != nil {
+		return err
+	}
+	plaintext, err := envcrypt.Decrypt(ciphertext, id)
+	if err != nil {
+		return err
+	}
+	if err := writeOutput(outPath, plaintext, 0o600); err != nil {
+		return err
+	}
+	if outPath != stdioPath {
+		fmt.Printf("Decrypted %s -> %s (%d bytes)\n", displayPath(inPath), outPath, len(plaintext))
+	}
+	return nil
+}
+
+func checkOutput(path string, force bool) error {
+	if path == stdioPath || force {
+		return nil
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("output file %s already exists (use --force to overwrite)", path)
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func readInput(path string) ([]byte, error) {
+	if path == stdioPath {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(path)
+}
+
+func writeOutput(path string, data []byte, perm os.FileMode) error {
+	if path == stdioPath {
+		_, err := os.Stdout.Write(data)
+		return err
+	}
+	return writeFileAtomic(path, data, perm)
+}
+
+func displayPath(path string) string {
+	if path == stdioPath {
+		return "stdin"
+	}
+	return path
+}
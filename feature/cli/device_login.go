@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/serverapi"
+)
+
+// slowDownStep is how much the poll interval grows on each slow_down reply.
+const slowDownStep = 5 * time.Second
+
+var errDeviceLoginExpired = errors.New("device code expired before the login was approved; run `envlock login --device` again")
+
+// deviceLogin runs the device authorization grant: it prints a user code and
+// verification URL, then polls until the login is approved, denied or
+// expires.
+func deviceLogin(ctx context.Context, client *serverapi.Client, baseURL string) (serverapi.CLILoginExchangeResponse, error) {
+	start, err := client.StartDeviceLogin(ctx)
+	if err != nil {
+		return serverapi.CLILoginExchangeResponse{}, err
+	}
+	if strings.TrimSpace(start.DeviceCode) == "" || strings.TrimSpace(start.UserCode) == "" {
+		return serverapi.CLILoginExchangeResponse{}, errors.New("server returned an incomplete device login")
+	}
+
+	fmt.Printf("Server: %s\n", strings.TrimRight(baseURL, "/"))
+	fmt.Printf("On any device, open: %s\n", start.VerificationURI)
+	fmt.Printf("and enter code: %s\n", start.UserCode)
+	if start.VerificationURIComplete != "" {
+		fmt.Printf("(or open %s)\n", start.VerificationURIComplete)
+	}
+
+	interval := start.PollInterval()
+	var deadline time.Time
+	if start.ExpiresIn > 0 {
+		deadline = time.Now().Add(time.Duration(start.ExpiresIn) * time.Second)
+		fmt.Printf("Waiting for approval (code expires at %s) ...\n", deadline.Format(time.Kitchen))
+	} else {
+		fmt.Println("Waiting for approval ...")
+	}
+
+	// Sleep, then poll, so an approval in the last interval is still seen;
+	// the final wait is cut short to poll once more at the deadline.
+	for {
+		wait := interval
+		if !deadline.IsZero() {
+			if left := time.Until(deadline); left < wait {
+				wait = max(left, 0)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return serverapi.CLILoginExchangeResponse{}, ctx.Err()
+		case <-time.After(wait):
+		}
+		resp, err := client.PollDeviceLogin(ctx, serverapi.DeviceTokenRequest{DeviceCode: start.DeviceCode})
+		switch {
+		case err == nil:
+			fmt.Println("Login approved.")
+			return resp, nil
+		case errors.Is(err, serverapi.ErrAuthorizationPending):
+		case errors.Is(err, serverapi.ErrSlowDown):
+			interval += slowDownStep
+		case errors.Is(err, serverapi.ErrDeviceCodeExpired):
+			return serverapi.CLILoginExchangeResponse{}, errDeviceLoginExpired
+		case errors.Is(err, serverapi.ErrAccessDenied):
+			return serverapi.CLILoginExchangeResponse{}, errors.New("device login was denied in the browser")
+		default:
+			return serverapi.CLILoginExchangeResponse{}, err
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return serverapi.CLILoginExchangeResponse{}, errDeviceLoginExpired
+		}
+	}
+}
@@ -0,0 +1,119 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+// approvalRekey re-encrypts secrets right after an approval so the new device
+// can decrypt them without waiting for the next push. Everything that can be
+// checked up front is checked before the approval is written; once it is,
+// the approval stands and a failed rekey only leaves the listed secrets for
+// `envlock secrets rekey`, which skips secrets that are already current.
+type approvalRekey struct {
+	names     []string
+	id        age.Identity
+	createdBy string
+}
+
+// planApprovalRekey resolves --rekey/--rekey-all. It returns nil when no
+// rekey was asked for.
+func planApprovalRekey(ctx context.Context, rs backend.Store, rekey string, all bool, keyName string) (*approvalRekey, error) {
+	rekey = strings.TrimSpace(rekey)
+	if rekey == "" && !all {
+		return nil, nil
+	}
+	if rekey != "" && all {
+		return nil, errors.New("pass either --rekey <name> or --rekey-all, not both")
+	}
+	keyPath, err := keys.DefaultKeyPath(keyName)
+	if err != nil {
+		return nil, err
+	}
+	id, meta, err := keys.LoadIdentity(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("load local key (%s): %w (rekey needs a device that can decrypt the secrets)", keyPath, err)
+	}
+	m, err := rs.LoadManifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	entries := make(map[string]secrets.ManifestEntry, len(m.Secrets))
+	for _, e := range m.Secrets {
+		entries[e.Name] = e
+	}
+
+	var names []string
+	if all {
+		for _, e := range m.Secrets {
+			names = append(names, e.Name)
+		}
+	} else {
+		for _, name := range strings.Split(rekey, ",") {
+			name = strings.TrimSpace(name)
+			if err := secrets.ValidateName(name); err != nil {
+				return nil, err
+			}
+			if _, ok := entries[name]; !ok {
+				return nil, fmt.Errorf("secret %q not found (see `envlock secrets ls`); nothing was approved", name)
+			}
+			if !containsString(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+
+	fp := keys.Fingerprint(id.Recipient().String())
+	var unreadable []string
+	for _, name := range names {
+		if fps := entries[name].RecipientFingerprints; len(fps) > 0 && !containsString(fps, fp) {
+			unreadable = append(unreadable, name)
+		}
+	}
+	if len(unreadable) > 0 {
+		return nil, fmt.Errorf("this device cannot decrypt %s, so it cannot rekey them; nothing was approved", strings.Join(unreadable, ", "))
+	}
+	return &approvalRekey{names: names, id: id, createdBy: meta.DeviceName}, nil
+}
+
+// run rekeys the planned secrets and prints a summary. deviceName is the
+// newly approved device.
+func (p *approvalRekey) run(ctx context.Context, rs backend.Store, requestID, deviceName string) error {
+	if p == nil {
+		return nil
+	}
+	if len(p.names) == 0 {
+		fmt.Println("No secrets to rekey")
+		return nil
+	}
+	fmt.Printf("Rekeying %d secrets so %s can decrypt them:\n", len(p.names), deviceName)
+	res, err := rekeySecrets(ctx, rs, p.id, p.createdBy, p.names, false)
+	if err != nil {
+		return fmt.Errorf("request %s is approved, but no secrets were rekeyed: %w (run `envlock secrets rekey --all` to finish)", requestID, err)
+	}
+	res.print()
+
+	var failed []string
+	for _, o := range res.Outcomes {
+		if o.Status == rekeyStatusFailed {
+			failed = append(failed, o.Name)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	retry := "--all"
+	if len(failed) == 1 {
+		retry = failed[0]
+	}
+	return fmt.Errorf("request %s is approved, but %d of %d secrets were not rekeyed (%s) and the new device cannot read them yet; run `envlock secrets rekey %s` to finish (secrets already rekeyed are skipped)",
+		requestID, len(failed), len(p.names), strings.Join(failed, ", "), retry)
+}
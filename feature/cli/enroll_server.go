@@ -0,0 +1,161 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/authstate"
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/core/serverapi"
+)
+
+// Server projects enroll devices through the server's enrollment API instead
+// of writing invite and request objects: the server keeps the invite secret
+// hashes, binds join requests to the logged-in user, and approves them in one
+// step.
+
+// projectClient returns a session client for a server project, requiring the
+// login to be for the project's server.
+func projectClient(proj config.Project) (*serverapi.Client, error) {
+	serverURL := strings.TrimRight(strings.TrimSpace(proj.Server), "/")
+	state, statePath, err := loadAuthStateOptional()
+	if err != nil {
+		return nil, err
+	}
+	if strings.TrimSpace(state.AccessToken) == "" {
+		return nil, fmt.Errorf("not logged in (run `envlock login --server %s`)", serverURL)
+	}
+	if state.ServerURL != serverURL {
+		return nil, fmt.Errorf("logged in to %s but project uses %s (run `envlock login --server %s`)", state.ServerURL, serverURL, serverURL)
+	}
+	return newSessionClient(state, statePath)
+}
+
+// serverProjectFromCWD returns the current project when it uses the server
+// backend; ok is false for other backends and missing project configs.
+func serverProjectFromCWD() (proj config.Project, ok bool, err error) {
+	proj, _, err = config.LoadProjectFromCWD()
+	if errors.Is(err, config.ErrProjectNotFound) {
+		return config.Project{}, false, nil
+	}
+	if err != nil {
+		return config.Project{}, false, err
+	}
+	return proj, proj.BackendName() == config.BackendServer, nil
+}
+
+func serverEnrollInvite(proj config.Project, ttl time.Duration, createdBy string) error {
+	client, err := projectClient(proj)
+	if err != nil {
+		return err
+	}
+	out, err := client.CreateInvite(context.Background(), proj.ProjectID, serverapi.CreateInviteRequest{
+		TTLSeconds: int(ttl.Round(time.Second) / time.Second),
+		CreatedBy:  createdBy,
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created invite: %s\n", out.Invite.ID)
+	fmt.Printf("Expires at: %s\n", out.Invite.ExpiresAt.Format(time.RFC3339))
+	fmt.Printf("Invite storage: %s (only the token hash is kept)\n", backendLabel(proj))
+	if err := saveInviteToken(out.Token); err != nil {
+		fmt.Printf("Warning: could not save the invite token for `enroll approve`: %v\n", err)
+	}
+	fmt.Printf("Invite token (share with new machine): %s\n", out.Token)
+	return nil
+}
+
+// serverEnrollJoin files a join request with the project's server, or with
+// the logged-in server when the directory has no project config yet. In the
+// latter case the invite's project is selected for the directory.
+func serverEnrollJoin(proj config.Project, haveProject bool, token, deviceName, publicKey string) error {
+	var (
+		client    *serverapi.Client
+		serverURL string
+		err       error
+	)
+	if haveProject {
+		client, err = projectClient(proj)
+		serverURL = strings.TrimRight(strings.TrimSpace(proj.Server), "/")
+	} else {
+		var state authstate.State
+		client, state, err = loggedInClient()
+		serverURL = state.ServerURL
+	}
+	if err != nil {
+		return err
+	}
+	req, err := client.JoinInvite(context.Background(), token, serverapi.JoinRequest{
+		DeviceName: deviceName,
+		PublicKey:  publicKey,
+	})
+	if err != nil {
+		return err
+	}
+	if haveProject && req.ProjectID != proj.ProjectID {
+		return fmt.Errorf("invite is for project %s but this directory uses %s; request %s was still filed", req.ProjectID, proj.ProjectID, req.ID)
+	}
+
+	fmt.Printf("Created enrollment request: %s\n", req.ID)
+	fmt.Printf("Request storage: server %s (project %s)\n", serverURL, req.ProjectID)
+	fmt.Printf("Device: %s (%s)\n", req.DeviceName, req.Fingerprint)
+	fmt.Printf("Requested as: %s\n", req.UserEmail)
+	if !haveProject {
+		p := serverapi.Project{ID: req.ProjectID, Name: req.ProjectName}
+		if p.Name == "" {
+			p.Name = req.ProjectID
+		}
+		if err := os.MkdirAll(config.ProjectDirPath("."), 0o755); err != nil {
+			return err
+		}
+		projPath := config.ProjectFilePath(".")
+		if err := config.WriteProject(projPath, serverProject(p, serverURL, "")); err != nil {
+			return err
+		}
+		fmt.Printf("Project selected: %s\n", projPath)
+	}
+	if err := printJoinCode(token, publicKey); err != nil {
+		return err
+	}
+	fmt.Println("Ask a project admin to approve the request, then run `envlock secrets pull`.")
+	return nil
+}
+
+// serverEnrollApprove approves requestID if its device still has
+// fingerprint, the one the approver verified.
+func serverEnrollApprove(proj config.Project, requestID, fingerprint, note string) error {
+	client, err := projectClient(proj)
+	if err != nil {
+		return err
+	}
+	out, err := client.ApproveRequest(context.Background(), proj.ProjectID, requestID, fingerprint, note)
+	if err != nil {
+		return err
+	}
+	req := out.Request
+	if out.RecipientAdded {
+		fmt.Printf("Approved request %s and added recipient: %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
+	} else {
+		fmt.Printf("Approved request %s (recipient already existed): %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
+	}
+	fmt.Printf("Project member: %s\n", req.UserEmail)
+	return nil
+}
+
+func serverEnrollReject(proj config.Project, requestID, reason string) error {
+	client, err := projectClient(proj)
+	if err != nil {
+		return err
+	}
+	req, err := client.RejectRequest(context.Background(), proj.ProjectID, requestID, reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Rejected request %s for %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
+	return nil
+}
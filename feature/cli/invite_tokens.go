@@ -0,0 +1,106 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jasonchiu/envlock/feature/enroll"
+)
+
+// Invite tokens are kept on the machine that created them so `enroll approve`
+// can recompute the joiner's verification code without the token being
+// pasted back. Backends only ever store the token hash.
+
+func inviteTokenPath(inviteID string) (string, error) {
+	if strings.ContainsAny(inviteID, `/\`) || strings.TrimSpace(inviteID) == "" {
+		return "", fmt.Errorf("invalid invite id %q", inviteID)
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, "envlock", "invites", inviteID+".token"), nil
+}
+
+func saveInviteToken(token string) error {
+	id, _, err := enroll.ParseToken(token)
+	if err != nil {
+		return err
+	}
+	path, err := inviteTokenPath(id)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(token+"\n"), 0o600)
+}
+
+// loadInviteToken returns the saved token for inviteID, or "" if this
+// machine did not create the invite.
+func loadInviteToken(inviteID string) (string, error) {
+	path, err := inviteTokenPath(inviteID)
+	if err != nil {
+		return "", err
+	}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func removeInviteToken(inviteID string) {
+	if path, err := inviteTokenPath(inviteID); err == nil {
+		_ = os.Remove(path)
+	}
+}
+
+// confirmJoinCode makes the approver prove the request's public key is the
+// one on the joining device: the code the joiner printed (code, or typed at
+// a prompt) must match the one recomputed from the invite token.
+func confirmJoinCode(req enroll.Request, token, code string) error {
+	token = extractInviteToken(token)
+	if token == "" {
+		saved, err := loadInviteToken(req.InviteID)
+		if err != nil {
+			return err
+		}
+		if saved == "" {
+			return fmt.Errorf("no saved token for invite %s on this machine; pass --token <invite-token> (printed by `invite create`) to verify the request", req.InviteID)
+		}
+		token = saved
+	}
+	inviteID, _, err := enroll.ParseToken(token)
+	if err != nil {
+		return err
+	}
+	if inviteID != req.InviteID {
+		return fmt.Errorf("token is for invite %s but request %s used invite %s", inviteID, req.ID, req.InviteID)
+	}
+	if strings.TrimSpace(code) == "" {
+		fmt.Printf("Request %s: %s (%s)\n", req.ID, req.DeviceName, req.Fingerprint)
+		code, err = promptForLine("Verification code shown on the joining device: ")
+		if err != nil {
+			return err
+		}
+		if code == "" {
+			return errors.New("verification code is required (pass --sas <code> or enter it when prompted)")
+		}
+	}
+	if err := enroll.VerifySAS(token, req.PublicKey, code); err != nil {
+		if errors.Is(err, enroll.ErrSASMismatch) {
+			return fmt.Errorf("%w: the public key in request %s is not the one on the joining device; do not approve it (reject with `envlock requests reject %s`)", err, req.ID, req.ID)
+		}
+		return err
+	}
+	fmt.Println("Verification code matches.")
+	return nil
+}
@@ -0,0 +1,216 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jasonchiu/envlock/core/authstate"
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/core/serverapi"
+)
+
+// runProjectCreate creates a project on the logged-in server and selects it
+// for the current directory. Without a project name it is the older alias of
+// `project init`.
+func runProjectCreate(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return runProjectInit(args)
+	}
+	name := strings.TrimSpace(args[0])
+	fs := flag.NewFlagSet("project create", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	appName := fs.String("app", "", "application name (defaults to the project name)")
+	keyName := fs.String("key-name", "default", "local key profile used for auto-adding this device")
+	deviceName := fs.String("name", "", "recipient device name override")
+	force := fs.Bool("force", false, "overwrite existing project config")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: envlock project create <name> [--app <name>]")
+	}
+
+	idPath, err := keys.DefaultKeyPath(*keyName)
+	if err != nil {
+		return err
+	}
+	id, meta, err := keys.LoadIdentity(idPath)
+	if err != nil {
+		return fmt.Errorf("load local key (%s): %w (run `envlock init` first)", idPath, err)
+	}
+	projPath := config.ProjectFilePath(".")
+	if _, err := os.Stat(projPath); err == nil && !*force {
+		return fmt.Errorf("project config already exists at %s (use --force to overwrite)", projPath)
+	}
+	client, state, err := loggedInClient()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	p, err := client.CreateProject(ctx, serverapi.CreateProjectRequest{Name: name})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created project %q (%s) on %s\n", p.Name, p.ID, state.ServerURL)
+
+	proj := serverProject(p, state.ServerURL, *appName)
+	if err := os.MkdirAll(config.ProjectDirPath("."), 0o755); err != nil {
+		return err
+	}
+	if err := config.WriteProject(projPath, proj); err != nil {
+		return err
+	}
+	fmt.Printf("Project selected: %s\n", projPath)
+
+	rs, err := openStore(ctx, proj, projPath)
+	if err == nil {
+		device := strings.TrimSpace(*deviceName)
+		if device == "" {
+			device = meta.DeviceName
+		}
+		err = addDeviceRecipient(ctx, rs, device, id.Recipient().String(), "Added during project create")
+		if err == nil {
+			fmt.Printf("Added local device recipient: %s (%s)\n", device, keys.Fingerprint(id.Recipient().String()))
+			return nil
+		}
+	}
+	fmt.Printf("Warning: could not add this device as a recipient: %v\n", err)
+	fmt.Println("Retry with `envlock recipients add` once the server accepts recipient updates.")
+	return nil
+}
+
+// runProjectUse points the current directory at an existing server project
+// the logged-in user belongs to. An existing project.toml keeps its app name
+// and prefix.
+func runProjectUse(args []string) error {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return errors.New("usage: envlock project use <name> [--app <name>]")
+	}
+	name := strings.TrimSpace(args[0])
+	fs := flag.NewFlagSet("project use", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	appName := fs.String("app", "", "application name for a new project config (defaults to the project name)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("usage: envlock project use <name> [--app <name>]")
+	}
+	client, state, err := loggedInClient()
+	if err != nil {
+		return err
+	}
+	list, err := client.ListProjects(context.Background())
+	if err != nil {
+		return err
+	}
+	var (
+		p     serverapi.Project
+		found bool
+	)
+	for _, candidate := range list {
+		if candidate.Name == name || candidate.ID == name {
+			p, found = candidate, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf("no project %q on %s that you are a member of (see `envlock project ls`)", name, state.ServerURL)
+	}
+
+	proj := serverProject(p, state.ServerURL, *appName)
+	existing, projPath, err := config.LoadProjectFromCWD()
+	switch {
+	case err == nil:
+		if strings.TrimSpace(*appName) == "" {
+			proj.AppName = existing.AppName
+		}
+		proj.Prefix = existing.Prefix
+	case errors.Is(err, config.ErrProjectNotFound):
+		if err := os.MkdirAll(config.ProjectDirPath("."), 0o755); err != nil {
+			return err
+		}
+		projPath = config.ProjectFilePath(".")
+	default:
+		return err
+	}
+	if err := config.WriteProject(projPath, proj); err != nil {
+		return err
+	}
+	fmt.Printf("Using project %q (%s) on %s as %s\n", p.Name, p.ID, state.ServerURL, p.Role)
+	fmt.Printf("Project config: %s\n", projPath)
+	return nil
+}
+
+func runProjectList(args []string) error {
+	fs := flag.NewFlagSet("project ls", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("project ls does not accept positional arguments")
+	}
+	client, state, err := loggedInClient()
+	if err != nil {
+		return err
+	}
+	list, err := client.ListProjects(context.Background())
+	if err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		fmt.Printf("No projects on %s (create one with `envlock project create <name>`)\n", state.ServerURL)
+		return nil
+	}
+	current := ""
+	if proj, _, err := config.LoadProjectFromCWD(); err == nil && proj.BackendName() == config.BackendServer {
+		current = proj.ProjectID
+	}
+	for _, p := range list {
+		marker := " "
+		if p.ID == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %-24s %-8s %s\n", marker, p.Name, p.Role, p.ID)
+	}
+	return nil
+}
+
+// serverProject returns the project.toml contents selecting p on serverURL.
+func serverProject(p serverapi.Project, serverURL, appName string) config.Project {
+	app := strings.TrimSpace(appName)
+	if app == "" {
+		app = p.Name
+	}
+	return config.Project{
+		Version:   1,
+		AppName:   app,
+		Backend:   config.BackendServer,
+		Prefix:    config.DefaultPrefix(app),
+		Server:    serverURL,
+		ProjectID: p.ID,
+	}
+}
+
+// loggedInClient returns a session client for the server in auth.toml.
+func loggedInClient() (*serverapi.Client, authstate.State, error) {
+	state, statePath, err := loadAuthStateOptional()
+	if err != nil {
+		return nil, authstate.State{}, err
+	}
+	if strings.TrimSpace(state.AccessToken) == "" || strings.TrimSpace(state.ServerURL) == "" {
+		return nil, authstate.State{}, errors.New("not logged in (run `envlock login --server <url>`)")
+	}
+	client, err := newSessionClient(state, statePath)
+	if err != nil {
+		return nil, authstate.State{}, err
+	}
+	return client, state, nil
+}
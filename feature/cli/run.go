@@ -0,0 +1,159 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/joho/godotenv"
+
+	"github.com/jasonchiu/envlock/core/envcrypt"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+// ExitError carries a child process exit code up to main without printing
+// an extra error line, so `envlock run` is transparent to supervisors.
+type ExitError struct {
+	Code int
+}
+
+func (e *ExitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.Code)
+}
+
+// forwardedSignals are relayed to the child; the set is limited to signals
+// that exist on every platform the CLI builds for.
+var forwardedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT}
+
+func runRun(args []string) error {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	name := fs.String("secret", ".env", "secret to decrypt into the child environment")
+	keyName := fs.String("key-name", "default", "local key profile name")
+	keepEnv := fs.Bool("keep-env", false, "let variables already set in the environment win over secret values")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("usage: envlock run [--secret <name>] -- <command> [args...]")
+	}
+	if err := secrets.ValidateName(*name); err != nil {
+		return err
+	}
+
+	keyPath, err := keys.DefaultKeyPath(*keyName)
+	if err != nil {
+		return err
+	}
+	id, _, err := keys.LoadIdentity(keyPath)
+	if err != nil {
+		return fmt.Errorf("load local key (%s): %w (run `envlock init` first)", keyPath, err)
+	}
+
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	blob, err := rs.LoadSecret(ctx, *name)
+	if err != nil {
+		if errors.Is(err, secrets.ErrSecretNotFound) {
+			return fmt.Errorf("remote secret %q not found", *name)
+		}
+		return err
+	}
+	plaintext, err := envcrypt.Decrypt(blob.Ciphertext, id)
+	if err != nil {
+		return err
+	}
+	vars, err := godotenv.UnmarshalBytes(plaintext)
+	if err != nil {
+		return fmt.Errorf("parse %s as dotenv: %w", *name, err)
+	}
+
+	cmd := exec.Command(fs.Arg(0), fs.Args()[1:]...)
+	cmd.Env = mergeEnv(os.Environ(), vars, *keepEnv)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return runChild(cmd)
+}
+
+// mergeEnv overlays vars on base. Secret values win unless keepExisting is
+// set, matching what a developer expects after `secrets pull` + dotenv load.
+func mergeEnv(base []string, vars map[string]string, keepExisting bool) []string {
+	env := make(map[string]string, len(base)+len(vars))
+	order := make([]string, 0, len(base)+len(vars))
+	for _, kv := range base {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		if _, seen := env[k]; !seen {
+			order = append(order, k)
+		}
+		env[k] = v
+	}
+	added := make([]string, 0, len(vars))
+	for k := range vars {
+		added = append(added, k)
+	}
+	sort.Strings(added)
+	for _, k := range added {
+		if _, exists := env[k]; exists {
+			if keepExisting {
+				continue
+			}
+		} else {
+			order = append(order, k)
+		}
+		env[k] = vars[k]
+	}
+	out := make([]string, 0, len(order))
+	for _, k := range order {
+		out = append(out, k+"="+env[k])
+	}
+	return out
+}
+
+// runChild starts cmd, relays signals to it until it exits and maps its exit
+// status to an *ExitError. A child killed by a signal exits 128+signal, as a
+// shell would report it.
+func runChild(cmd *exec.Cmd) error {
+	sigs := make(chan os.Signal, 4)
+	signal.Notify(sigs, forwardedSignals...)
+	defer signal.Stop(sigs)
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	for {
+		select {
+		case sig := <-sigs:
+			_ = cmd.Process.Signal(sig)
+		case err := <-done:
+			if err == nil {
+				return nil
+			}
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return err
+			}
+			if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+				return &ExitError{Code: 128 + int(ws.Signal())}
+			}
+			return &ExitError{Code: exitErr.ExitCode()}
+		}
+	}
+}
@@ -0,0 +1,671 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+
+	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/envcrypt"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+func runSecrets(args []string) error {
+	if len(args) == 0 {
+		printSecretsUsage()
+		return nil
+	}
+	switch args[0] {
+	case "push":
+		return runSecretsPush(args[1:])
+	case "pull":
+		return runSecretsPull(args[1:])
+	case "rekey":
+		return runSecretsRekey(args[1:])
+	case "history":
+		return runSecretsHistory(args[1:])
+	case "rollback":
+		return runSecretsRollback(args[1:])
+	case "ls", "list":
+		return runSecretsList(args[1:])
+	case "status":
+		return runSecretsStatus(args[1:])
+	case "help", "--help", "-h":
+		printSecretsUsage()
+		return nil
+	default:
+		return fmt.Errorf("unknown secrets command %q", args[0])
+	}
+}
+
+func printSecretsUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  envlock secrets push <path> [--name <name>] [--force]")
+	fmt.Println("  envlock secrets pull <name> [--out <path>] [--version <n>] [--force] [--backup]")
+	fmt.Println("  envlock secrets ls")
+	fmt.Println("  envlock secrets status")
+	fmt.Println("  envlock secrets rekey <name> [--force]")
+	fmt.Println("  envlock secrets rekey --all [--force]")
+	fmt.Println("  envlock secrets history <name>")
+	fmt.Println("  envlock secrets rollback <name> --to <version>")
+}
+
+func runSecretsPush(args []string) error {
+	fs := flag.NewFlagSet("secrets push", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	nameFlag := fs.String("name", "", "remote secret name (defaults to the file name)")
+	force := fs.Bool("force", false, "overwrite existing remote secret")
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: envlock secrets push <path> [--name <name>] [--force]")
+	}
+	inPath := fs.Arg(0)
+	name := strings.TrimSpace(*nameFlag)
+	if name == "" {
+		name = secrets.NameFromPath(inPath)
+	}
+	if err := secrets.ValidateName(name); err != nil {
+		return err
+	}
+
+	plaintext, err := os.ReadFile(inPath)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	pubs := store.ActivePublicKeys()
+	if len(pubs) == 0 {
+		return errors.New("no active recipients (run `envlock project init` or approve an enrollment request first)")
+	}
+
+	var baseVersion int
+	if existing, err := rs.LoadSecret(ctx, name); err == nil {
+		if !*force {
+			return fmt.Errorf("remote secret %q already exists (use --force to push a new version)", name)
+		}
+		baseVersion = existing.Version
+	} else if !errors.Is(err, secrets.ErrSecretNotFound) {
+		return err
+	}
+
+	ciphertext, err := envcrypt.Encrypt(plaintext, pubs)
+	if err != nil {
+		return err
+	}
+	localPub, deviceName, _ := localDevice(*keyName)
+	v, err := rs.SaveSecret(ctx, secrets.Blob{
+		Name:                  name,
+		Ciphertext:            ciphertext,
+		Version:               baseVersion,
+		RecipientSetHash:      store.ActiveSetHash(),
+		RecipientFingerprints: store.ActiveFingerprints(),
+		CreatedBy:             deviceName,
+		Source:                secrets.SourcePush,
+	})
+	if errors.Is(err, backend.ErrConflict) {
+		return fmt.Errorf("remote secret %q changed while pushing; pull and review it before pushing again: %w", name, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Pushed %s -> %s v%d (%d bytes, %d recipients)\n", inPath, name, v.Version, len(plaintext), len(pubs))
+	if localPub != "" && !containsString(pubs, localPub) {
+		fmt.Println("Warning: this device is not an active recipient and cannot decrypt the pushed secret.")
+	}
+	return nil
+}
+
+func runSecretsPull(args []string) error {
+	fs := flag.NewFlagSet("secrets pull", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	out := fs.String("out", "", "output path (defaults to the secret name in the current directory)")
+	force := fs.Bool("force", false, "overwrite existing output file")
+	backup := fs.Bool("backup", false, "keep a timestamped copy of the existing output file before overwriting")
+	version := fs.Int("version", 0, "pull a specific version instead of the latest")
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: envlock secrets pull <name> [--out <path>] [--version <n>] [--force] [--backup]")
+	}
+	name := strings.TrimSpace(fs.Arg(0))
+	if err := secrets.ValidateName(name); err != nil {
+		return err
+	}
+	outPath := strings.TrimSpace(*out)
+	if outPath == "" {
+		outPath = name
+	}
+
+	exists := false
+	if _, err := os.Stat(outPath); err == nil {
+		exists = true
+		if !*force {
+			return fmt.Errorf("output file %s already exists (use --force to overwrite, optionally with --backup)", outPath)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	keyPath, err := keys.DefaultKeyPath(*keyName)
+	if err != nil {
+		return err
+	}
+	id, _, err := keys.LoadIdentity(keyPath)
+	if err != nil {
+		return fmt.Errorf("load local key (%s): %w (run `envlock init` first)", keyPath, err)
+	}
+
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	var blob secrets.Blob
+	if *version > 0 {
+		blob, err = rs.LoadSecretVersion(ctx, name, *version)
+	} else {
+		blob, err = rs.LoadSecret(ctx, name)
+	}
+	if err != nil {
+		if errors.Is(err, secrets.ErrSecretNotFound) {
+			return fmt.Errorf("remote secret %q not found", name)
+		}
+		return err
+	}
+	plaintext, err := envcrypt.Decrypt(blob.Ciphertext, id)
+	if err != nil {
+		return err
+	}
+
+	if exists && *backup {
+		backupPath, err := backupFile(outPath)
+		if err != nil {
+			return fmt.Errorf("backup %s: %w", outPath, err)
+		}
+		fmt.Printf("Backup written: %s\n", backupPath)
+	}
+	if err := writeFileAtomic(outPath, plaintext, 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("Pulled %s v%d -> %s (%d bytes)\n", name, blob.Version, outPath, len(plaintext))
+	return nil
+}
+
+func runSecretsRekey(args []string) error {
+	fs := flag.NewFlagSet("secrets rekey", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	all := fs.Bool("all", false, "rekey every secret under the project prefix")
+	force := fs.Bool("force", false, "re-encrypt even when a blob already matches the active recipient set")
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if *all && fs.NArg() != 0 {
+		return errors.New("secrets rekey accepts either <name> or --all, not both")
+	}
+	if !*all && fs.NArg() != 1 {
+		return errors.New("usage: envlock secrets rekey <name> | --all")
+	}
+
+	keyPath, err := keys.DefaultKeyPath(*keyName)
+	if err != nil {
+		return err
+	}
+	id, meta, err := keys.LoadIdentity(keyPath)
+	if err != nil {
+		return fmt.Errorf("load local key (%s): %w (run `envlock init` first)", keyPath, err)
+	}
+
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	var names []string
+	if *all {
+		names, err = rs.ListSecrets(ctx)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			fmt.Println("No secrets to rekey")
+			return nil
+		}
+	} else {
+		name := strings.TrimSpace(fs.Arg(0))
+		if err := secrets.ValidateName(name); err != nil {
+			return err
+		}
+		names = []string{name}
+	}
+
+	res, err := rekeySecrets(ctx, rs, id, meta.DeviceName, names, *force)
+	if err != nil {
+		return err
+	}
+	res.print()
+	if failed := res.count(rekeyStatusFailed); failed > 0 {
+		return fmt.Errorf("rekey failed for %d of %d secrets", failed, len(names))
+	}
+	return nil
+}
+
+const (
+	rekeyStatusRekeyed = "rekeyed"
+	rekeyStatusCurrent = "already current"
+	rekeyStatusFailed  = "failed"
+)
+
+type rekeyOutcome struct {
+	Name   string
+	Status string
+	Err    error
+}
+
+type rekeyResult struct {
+	Outcomes []rekeyOutcome
+}
+
+func (r *rekeyResult) add(name, status string, err error) {
+	r.Outcomes = append(r.Outcomes, rekeyOutcome{Name: name, Status: status, Err: err})
+}
+
+func (r rekeyResult) count(status string) int {
+	n := 0
+	for _, o := range r.Outcomes {
+		if o.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+func (r rekeyResult) print() {
+	for _, o := range r.Outcomes {
+		if o.Err != nil {
+			fmt.Printf("- %s: %s (%v)\n", o.Name, o.Status, o.Err)
+			continue
+		}
+		fmt.Printf("- %s: %s\n", o.Name, o.Status)
+	}
+	fmt.Printf("Rekeyed %d, already current %d, failed %d\n",
+		r.count(rekeyStatusRekeyed), r.count(rekeyStatusCurrent), r.count(rekeyStatusFailed))
+}
+
+// rekeySecrets re-encrypts each named blob to the current active recipient
+// set. Per-secret failures are collected so one unreadable blob does not stop
+// the rest; the returned error is reserved for failures that affect every blob.
+func rekeySecrets(ctx context.Context, rs backend.Store, id age.Identity, createdBy string, names []string, force bool) (rekeyResult, error) {
+	store, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return rekeyResult{}, err
+	}
+	pubs := store.ActivePublicKeys()
+	if len(pubs) == 0 {
+		return rekeyResult{}, errors.New("no active recipients to rekey to")
+	}
+	setHash := store.ActiveSetHash()
+	fingerprints := store.ActiveFingerprints()
+
+	var res rekeyResult
+	for _, name := range names {
+		var status string
+		err := retryOnConflict(func() error {
+			blob, err := rs.LoadSecret(ctx, name)
+			if err != nil {
+				return err
+			}
+			if !force && blob.RecipientSetHash == setHash {
+				status = rekeyStatusCurrent
+				return nil
+			}
+			plaintext, err := envcrypt.Decrypt(blob.Ciphertext, id)
+			if err != nil {
+				return err
+			}
+			ciphertext, err := envcrypt.Encrypt(plaintext, pubs)
+			if err != nil {
+				return err
+			}
+			if _, err := rs.SaveSecret(ctx, secrets.Blob{
+				Name:                  name,
+				Ciphertext:            ciphertext,
+				Version:               blob.Version,
+				RecipientSetHash:      setHash,
+				RecipientFingerprints: fingerprints,
+				CreatedBy:             createdBy,
+				Source:                secrets.SourceRekey,
+			}); err != nil {
+				return err
+			}
+			status = rekeyStatusRekeyed
+			return nil
+		})
+		if err != nil {
+			res.add(name, rekeyStatusFailed, err)
+			continue
+		}
+		res.add(name, status, nil)
+	}
+	return res, nil
+}
+
+func runSecretsList(args []string) error {
+	fs := flag.NewFlagSet("secrets ls", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("secrets ls does not accept positional arguments")
+	}
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := rs.LoadManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if len(m.Secrets) == 0 {
+		fmt.Println("No secrets")
+		return nil
+	}
+	for _, e := range m.Secrets {
+		fmt.Printf("- %s\n", e.Name)
+		fmt.Printf("  version: %d\n", e.LatestVersion)
+		fmt.Printf("  size: %d\n", e.Size)
+		fmt.Printf("  sha256: %s\n", e.SHA256)
+		if e.PushedBy != "" {
+			fmt.Printf("  pushed_by: %s\n", e.PushedBy)
+		}
+		fmt.Printf("  updated_at: %s\n", e.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+func runSecretsStatus(args []string) error {
+	fs := flag.NewFlagSet("secrets status", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("secrets status does not accept positional arguments")
+	}
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	store, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := rs.LoadManifest(ctx)
+	if err != nil {
+		return err
+	}
+	if len(m.Secrets) == 0 {
+		fmt.Println("No secrets")
+		return nil
+	}
+
+	setHash := store.ActiveSetHash()
+	active := store.ActiveFingerprints()
+	stale := 0
+	for _, e := range m.Secrets {
+		st := secrets.CheckStaleness(e, setHash, active)
+		if !st.Current() {
+			stale++
+		}
+		fmt.Printf("- %s (v%d): %s\n", e.Name, e.LatestVersion, strings.Join(st.Statuses(), "; "))
+		for _, fp := range st.Extra {
+			fmt.Printf("  still readable by: %s (%s)\n", store.NameForFingerprint(fp), fp)
+		}
+		for _, fp := range st.Missing {
+			fmt.Printf("  not readable by: %s (%s)\n", store.NameForFingerprint(fp), fp)
+		}
+	}
+	if stale > 0 {
+		return fmt.Errorf("%d of %d secrets need rekeying (run `envlock secrets rekey --all`)", stale, len(m.Secrets))
+	}
+	fmt.Printf("All %d secrets are current\n", len(m.Secrets))
+	return nil
+}
+
+func runSecretsHistory(args []string) error {
+	fs := flag.NewFlagSet("secrets history", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	if err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: envlock secrets history <name>")
+	}
+	name := strings.TrimSpace(fs.Arg(0))
+	if err := secrets.ValidateName(name); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	h, err := rs.LoadSecretHistory(ctx, name)
+	if err != nil {
+		if errors.Is(err, secrets.ErrSecretNotFound) {
+			return fmt.Errorf("no version history for %q (push it first)", name)
+		}
+		return err
+	}
+	store, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	currentSet := store.ActiveSetHash()
+
+	latest := h.LatestVersion()
+	for i := len(h.Versions) - 1; i >= 0; i-- {
+		v := h.Versions[i]
+		marker := ""
+		if v.Version == latest {
+			marker = " (latest)"
+		}
+		fmt.Printf("- v%d%s\n", v.Version, marker)
+		fmt.Printf("  created_at: %s\n", v.CreatedAt.UTC().Format(time.RFC3339))
+		fmt.Printf("  source: %s\n", v.Source)
+		if v.RestoredFrom > 0 {
+			fmt.Printf("  restored_from: v%d\n", v.RestoredFrom)
+		}
+		if v.CreatedBy != "" {
+			fmt.Printf("  created_by: %s\n", v.CreatedBy)
+		}
+		fmt.Printf("  size: %d\n", v.Size)
+		fmt.Printf("  sha256: %s\n", v.SHA256)
+		recipientsNote := "previous recipient set"
+		if v.RecipientSetHash == currentSet {
+			recipientsNote = "current recipient set"
+		}
+		fmt.Printf("  recipients: %s\n", recipientsNote)
+	}
+	return nil
+}
+
+func runSecretsRollback(args []string) error {
+	fs := flag.NewFlagSet("secrets rollback", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	to := fs.Int("to", 0, "version to restore (required)")
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := parseInterspersed(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 || *to <= 0 {
+		return errors.New("usage: envlock secrets rollback <name> --to <version>")
+	}
+	name := strings.TrimSpace(fs.Arg(0))
+	if err := secrets.ValidateName(name); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	rs, _, err := remoteStoreFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	_, deviceName, _ := localDevice(*keyName)
+
+	// Rollback never rewrites history: the old ciphertext is promoted as a new
+	// version so the bad push stays visible and can itself be restored.
+	var restored secrets.Version
+	var target secrets.Blob
+	err = retryOnConflict(func() error {
+		h, err := rs.LoadSecretHistory(ctx, name)
+		if err != nil {
+			if errors.Is(err, secrets.ErrSecretNotFound) {
+				return fmt.Errorf("no version history for %q", name)
+			}
+			return err
+		}
+		if h.LatestVersion() == *to {
+			return fmt.Errorf("%s is already at v%d", name, *to)
+		}
+		target, err = rs.LoadSecretVersion(ctx, name, *to)
+		if err != nil {
+			return err
+		}
+		restored, err = rs.SaveSecret(ctx, secrets.Blob{
+			Name:                  name,
+			Ciphertext:            target.Ciphertext,
+			Version:               h.LatestVersion(),
+			RecipientSetHash:      target.RecipientSetHash,
+			RecipientFingerprints: target.RecipientFingerprints,
+			CreatedBy:             deviceName,
+			Source:                secrets.SourceRollback,
+			RestoredFrom:          *to,
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Rolled back %s: v%d now holds the contents of v%d\n", name, restored.Version, *to)
+	store, err := rs.LoadRecipients(ctx)
+	if err == nil && target.RecipientSetHash != store.ActiveSetHash() {
+		fmt.Printf("Warning: v%d was encrypted to a previous recipient set; run `envlock secrets rekey %s`.\n", *to, name)
+	}
+	return nil
+}
+
+// writeFileAtomic writes data to a temp file in the destination directory and
+// renames it into place, so readers never observe a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	if err := tmp.Chmod(perm); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+func backupFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	backupPath := path + ".backup-" + time.Now().UTC().Format("20060102T150405Z")
+	if err := writeFileAtomic(backupPath, data, 0o600); err != nil {
+		return "", err
+	}
+	return backupPath, nil
+}
+
+// parseInterspersed lets flags follow positional arguments
+// (`secrets push .env --force`), which the stdlib flag package stops at.
+// Everything after a literal "--" is kept positional.
+func parseInterspersed(fs *flag.FlagSet, args []string) error {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			positional = append(positional, rest...)
+			break
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+	return fs.Parse(append([]string{"--"}, positional...))
+}
+
+// localDevice returns this device's public key and name for attribution. It
+// is best-effort: commands that only need attribution work without a key.
+func localDevice(keyName string) (pub string, name string, err error) {
+	keyPath, err := keys.DefaultKeyPath(keyName)
+	if err != nil {
+		return "", "", err
+	}
+	id, meta, err := keys.LoadIdentity(keyPath)
+	if err != nil {
+		return "", "", err
+	}
+	return id.Recipient().String(), meta.DeviceName, nil
+}
+
+func containsString(items []string, v string) bool {
+	for _, item := range items {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
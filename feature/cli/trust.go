@@ -0,0 +1,495 @@
+package cli
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/backend"
+	"github.com/jasonchiu/envlock/core/backend/server"
+	"github.com/jasonchiu/envlock/core/backend/signed"
+	"github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/recipients"
+)
+
+// The trust commands manage the signature chain that projects with a
+// trust_root use to verify recipients.json (see core/backend/signed). They
+// work on the unverified backend so a broken or unsigned set can be
+// inspected and re-signed.
+
+func runTrust(args []string) error {
+	if len(args) == 0 {
+		printTrustUsage()
+		return nil
+	}
+	switch args[0] {
+	case "init":
+		return runTrustInit(args[1:])
+	case "status":
+		return runTrustStatus(args[1:])
+	case "sign":
+		return runTrustSign(args[1:])
+	case "key":
+		return runTrustKey(args[1:])
+	case "admin":
+		return runTrustAdmin(args[1:])
+	case "help", "--help", "-h":
+		printTrustUsage()
+		return nil
+	default:
+		return fmt.Errorf("unknown trust command %q", args[0])
+	}
+}
+
+func printTrustUsage() {
+	fmt.Println("Usage:")
+	fmt.Println("  envlock trust init [--yes] [--force]")
+	fmt.Println("  envlock trust status")
+	fmt.Println("  envlock trust sign [--yes] [--note <text>]")
+	fmt.Println("  envlock trust key")
+	fmt.Println("  envlock trust admin add <admin-public-key>")
+	fmt.Println("  envlock trust admin remove <admin-public-key>")
+}
+
+// defaultAdminKey signs recipient writes made by other commands.
+func defaultAdminKey() (ed25519.PrivateKey, error) {
+	return loadAdminKey("default")
+}
+
+func loadAdminKey(keyName string) (ed25519.PrivateKey, error) {
+	path, err := keys.AdminKeyPath(keyName)
+	if err != nil {
+		return nil, err
+	}
+	priv, err := keys.LoadAdminKey(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("no admin key at %s; recipient changes in this project must be signed by an admin (run `envlock trust key` and ask an admin to run `envlock trust admin add <key>`)", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("load admin key (%s): %w", path, err)
+	}
+	return priv, nil
+}
+
+func loadOrCreateAdminKey(keyName string) (ed25519.PrivateKey, string, bool, error) {
+	path, err := keys.AdminKeyPath(keyName)
+	if err != nil {
+		return nil, "", false, err
+	}
+	priv, err := keys.LoadAdminKey(path)
+	if err == nil {
+		return priv, path, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, "", false, fmt.Errorf("load admin key (%s): %w", path, err)
+	}
+	priv, err = keys.GenerateAdminKey()
+	if err != nil {
+		return nil, "", false, err
+	}
+	if err := keys.WriteAdminKey(path, priv, false); err != nil {
+		return nil, "", false, err
+	}
+	return priv, path, true, nil
+}
+
+// trustPin returns this device's rollback checkpoint for the project's
+// recipients chain. Pins live in the user config dir, keyed by the trust root
+// and backend location, so checkouts of the same project share one and a new
+// trust root starts fresh.
+func trustPin(proj config.Project, projPath string) *signed.Pin {
+	base, err := os.UserConfigDir()
+	if err != nil {
+		return signed.NewPin("")
+	}
+	location := proj.Path
+	if location != "" && !filepath.IsAbs(location) {
+		location = filepath.Join(config.ProjectRoot(projPath), location)
+	}
+	prefix := strings.Trim(strings.TrimSpace(proj.Prefix), "/")
+	if prefix == "" {
+		prefix = config.DefaultPrefix(proj.AppName)
+	}
+	sum := sha256.Sum256([]byte(strings.Join([]string{
+		strings.TrimSpace(proj.TrustRoot), proj.BackendName(), proj.Endpoint, proj.Bucket, prefix, location,
+	}, "\n")))
+	return signed.NewPin(filepath.Join(base, "envlock", "trust", hex.EncodeToString(sum[:16])+".json"))
+}
+
+// trustBackendFromCWD returns the project and its unverified backend.
+func trustBackendFromCWD(ctx context.Context) (backend.Store, config.Project, string, error) {
+	proj, projPath, err := config.LoadProjectFromCWD()
+	if err != nil {
+		return nil, config.Project{}, "", err
+	}
+	if proj.BackendName() == config.BackendServer {
+		return nil, config.Project{}, "", server.ErrRecipientChainUnsupported
+	}
+	rs, err := openBackend(ctx, proj, projPath)
+	if err != nil {
+		return nil, config.Project{}, "", err
+	}
+	return rs, proj, projPath, nil
+}
+
+// printRecipientSet lists what a signature would vouch for.
+func printRecipientSet(store recipients.Store) {
+	items := make([]recipients.Recipient, 0, len(store.Recipients))
+	for _, r := range store.Recipients {
+		if r.Status == recipients.StatusActive {
+			items = append(items, r)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	fmt.Printf("Active recipients (%d):\n", len(items))
+	for _, r := range items {
+		fmt.Printf("- %s (%s) source=%s\n", r.Name, r.Fingerprint, r.Source)
+	}
+}
+
+func confirmSignature(yes bool) error {
+	if yes {
+		return nil
+	}
+	answer, err := promptForLine("Sign this recipient set? Every listed device will be able to decrypt future pushes. [y/N]: ")
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(answer, "y") && !strings.EqualFold(answer, "yes") {
+		return errors.New("not signed")
+	}
+	return nil
+}
+
+func runTrustInit(args []string) error {
+	fs := flag.NewFlagSet("trust init", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	keyName := fs.String("key-name", "default", "local key profile name")
+	yes := fs.Bool("yes", false, "sign without asking for confirmation")
+	force := fs.Bool("force", false, "replace an existing trust root and signature chain")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("trust init does not accept positional arguments")
+	}
+	ctx := context.Background()
+	rs, proj, projPath, err := trustBackendFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	if proj.TrustRoot != "" && !*force {
+		return fmt.Errorf("project already pins trust root %s (use --force to replace it)", proj.TrustRoot)
+	}
+	priv, keyPath, created, err := loadOrCreateAdminKey(*keyName)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Printf("Created admin key: %s\n", keyPath)
+	}
+	root := keys.AdminPublicKey(priv)
+
+	store, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	printRecipientSet(store)
+	if err := confirmSignature(*yes); err != nil {
+		return err
+	}
+	existing, err := rs.LoadRecipientChain(ctx)
+	if err != nil {
+		return err
+	}
+	if len(existing.Links) > 0 && !*force {
+		return errors.New("a recipients signature chain already exists (use --force to replace it)")
+	}
+	chain := recipients.Chain{Version: 1, Revision: existing.Revision}
+	if _, err := chain.Append(root, priv, store.ActiveSetHash(), []string{root}, "trust init", time.Now().UTC()); err != nil {
+		return err
+	}
+	if err := rs.WriteRecipientChain(ctx, chain); err != nil {
+		return err
+	}
+	proj.TrustRoot = root
+	if err := config.WriteProject(projPath, proj); err != nil {
+		return err
+	}
+	if err := trustPin(proj, projPath).Reset(chain); err != nil {
+		return err
+	}
+
+	fmt.Printf("Signed recipient set (%d active)\n", store.ActiveCount())
+	fmt.Printf("Trust root pinned in %s: %s\n", projPath, root)
+	fmt.Println("Commit .envlock/project.toml so every checkout verifies recipients against this key.")
+	return nil
+}
+
+func runTrustStatus(args []string) error {
+	fs := flag.NewFlagSet("trust status", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("trust status does not accept positional arguments")
+	}
+	ctx := context.Background()
+	rs, proj, projPath, err := trustBackendFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	if proj.TrustRoot == "" {
+		fmt.Println("Trust root: not pinned (recipients are not verified; run `envlock trust init`)")
+		return nil
+	}
+	fmt.Printf("Trust root: %s\n", proj.TrustRoot)
+	store, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	chain, err := rs.LoadRecipientChain(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signature links: %d\n", len(chain.Links))
+	if head, ok := chain.Head(); ok {
+		fmt.Printf("Last signed by: %s at %s\n", head.SignedBy, head.SignedAt.UTC().Format(time.RFC3339))
+		fmt.Println("Admins:")
+		for _, a := range head.Admins {
+			fmt.Printf("- %s\n", a)
+		}
+	}
+	if priv, err := loadAdminKey(*keyName); err == nil {
+		pub := keys.AdminPublicKey(priv)
+		fmt.Printf("This device's admin key: %s (can sign: %t)\n", pub, chain.CanSign(proj.TrustRoot, pub))
+	}
+	if _, err := chain.Verify(proj.TrustRoot, store); err != nil {
+		return fmt.Errorf("recipients are not trusted: %w", err)
+	}
+	if err := trustPin(proj, projPath).Advance(chain); err != nil {
+		return fmt.Errorf("recipients are not trusted: %w", err)
+	}
+	fmt.Printf("Recipients verified: %d active\n", store.ActiveCount())
+	return nil
+}
+
+func runTrustSign(args []string) error {
+	fs := flag.NewFlagSet("trust sign", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	keyName := fs.String("key-name", "default", "local key profile name")
+	yes := fs.Bool("yes", false, "sign without asking for confirmation")
+	note := fs.String("note", "", "optional note recorded in the signature")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("trust sign does not accept positional arguments")
+	}
+	ctx := context.Background()
+	rs, proj, projPath, err := trustBackendFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	if proj.TrustRoot == "" {
+		return errors.New("project has no trust root (run `envlock trust init`)")
+	}
+	priv, err := loadAdminKey(*keyName)
+	if err != nil {
+		return err
+	}
+	reviewed, err := rs.LoadRecipients(ctx)
+	if err != nil {
+		return err
+	}
+	setHash := reviewed.ActiveSetHash()
+	chain, err := rs.LoadRecipientChain(ctx)
+	if err != nil {
+		return err
+	}
+	pin := trustPin(proj, projPath)
+	if err := pin.Check(chain); err != nil {
+		return err
+	}
+	if _, err := chain.Verify(proj.TrustRoot, reviewed); err == nil {
+		fmt.Println("Recipient set is already signed")
+		return pin.Advance(chain)
+	}
+	printRecipientSet(reviewed)
+	if err := confirmSignature(*yes); err != nil {
+		return err
+	}
+
+	err = retryOnConflict(func() error {
+		store, err := rs.LoadRecipients(ctx)
+		if err != nil {
+			return err
+		}
+		if store.ActiveSetHash() != setHash {
+			return errors.New("recipients changed while you were reviewing them; run `envlock trust sign` again")
+		}
+		chain, err := rs.LoadRecipientChain(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := chain.VerifyLinks(proj.TrustRoot); err != nil && !errors.Is(err, recipients.ErrUnsigned) {
+			return err
+		}
+		if err := pin.Check(chain); err != nil {
+			return err
+		}
+		if _, err := chain.Append(proj.TrustRoot, priv, setHash, nil, *note, time.Now().UTC()); err != nil {
+			return err
+		}
+		if err := rs.WriteRecipientChain(ctx, chain); err != nil {
+			return err
+		}
+		return pin.Advance(chain)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Signed recipient set (%d active) as %s\n", reviewed.ActiveCount(), keys.AdminPublicKey(priv))
+	return nil
+}
+
+func runTrustKey(args []string) error {
+	fs := flag.NewFlagSet("trust key", flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New("trust key does not accept positional arguments")
+	}
+	priv, path, created, err := loadOrCreateAdminKey(*keyName)
+	if err != nil {
+		return err
+	}
+	if created {
+		fmt.Printf("Created admin key: %s\n", path)
+	} else {
+		fmt.Printf("Admin key: %s\n", path)
+	}
+	fmt.Printf("Admin public key: %s\n", keys.AdminPublicKey(priv))
+	return nil
+}
+
+func runTrustAdmin(args []string) error {
+	if len(args) == 0 {
+		printTrustUsage()
+		return nil
+	}
+	switch args[0] {
+	case "add":
+		return runTrustAdminChange(args[1:], true)
+	case "remove":
+		return runTrustAdminChange(args[1:], false)
+	default:
+		return fmt.Errorf("unknown trust admin command %q", args[0])
+	}
+}
+
+// runTrustAdminChange appends a link that re-signs the current, verified
+// recipient set with an updated admin list.
+func runTrustAdminChange(args []string, add bool) error {
+	verb := "remove"
+	if add {
+		verb = "add"
+	}
+	fs := flag.NewFlagSet("trust admin "+verb, flag.ContinueOnError)
+	fs.SetOutput(os.Stdout)
+	keyName := fs.String("key-name", "default", "local key profile name")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("usage: envlock trust admin %s <admin-public-key>", verb)
+	}
+	target := strings.TrimSpace(fs.Arg(0))
+	if _, err := keys.ParseAdminPublicKey(target); err != nil {
+		return fmt.Errorf("%w: %v", recipients.ErrInvalidAdminKey, err)
+	}
+	ctx := context.Background()
+	rs, proj, projPath, err := trustBackendFromCWD(ctx)
+	if err != nil {
+		return err
+	}
+	if proj.TrustRoot == "" {
+		return errors.New("project has no trust root (run `envlock trust init`)")
+	}
+	if !add && target == proj.TrustRoot {
+		return errors.New("the trust root can always sign; pin a different trust_root in project.toml to retire it")
+	}
+	priv, err := loadAdminKey(*keyName)
+	if err != nil {
+		return err
+	}
+	pin := trustPin(proj, projPath)
+
+	err = retryOnConflict(func() error {
+		store, err := rs.LoadRecipients(ctx)
+		if err != nil {
+			return err
+		}
+		chain, err := rs.LoadRecipientChain(ctx)
+		if err != nil {
+			return err
+		}
+		head, err := chain.Verify(proj.TrustRoot, store)
+		if err != nil {
+			return fmt.Errorf("recipients are not trusted (%w); review them with `envlock trust sign` first", err)
+		}
+		if err := pin.Check(chain); err != nil {
+			return err
+		}
+		admins := make([]string, 0, len(head.Admins)+1)
+		found := false
+		for _, a := range head.Admins {
+			if a == target {
+				found = true
+				if !add {
+					continue
+				}
+			}
+			admins = append(admins, a)
+		}
+		switch {
+		case add && found:
+			return fmt.Errorf("%s is already an admin", target)
+		case !add && !found:
+			return fmt.Errorf("%s is not an admin", target)
+		case add:
+			admins = append(admins, target)
+		}
+		if _, err := chain.Append(proj.TrustRoot, priv, head.SetHash, admins, verb+" admin "+target, time.Now().UTC()); err != nil {
+			return err
+		}
+		if err := rs.WriteRecipientChain(ctx, chain); err != nil {
+			return err
+		}
+		return pin.Advance(chain)
+	})
+	if err != nil {
+		return err
+	}
+	if add {
+		fmt.Printf("Added admin: %s\n", target)
+	} else {
+		fmt.Printf("Removed admin: %s\n", target)
+	}
+	return nil
+}
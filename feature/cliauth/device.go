@@ -0,0 +1,173 @@
+package cliauth
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+)
+
+// deviceFormCookie holds the token the device confirmation form echoes back,
+// so another site cannot post a deny (or approve) for someone's code.
+const deviceFormCookie = "envlock_device_form"
+
+type deviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+func (h *Handler) deviceCode(w http.ResponseWriter, r *http.Request) {
+	d, err := h.Store.StartDeviceLogin(h.Config.DeviceCodeTTL, h.Config.DevicePollInterval, time.Now().UTC())
+	if err != nil {
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	verify := h.Config.BaseURL + "/login/device"
+	writeJSON(w, http.StatusOK, deviceCodeResponse{
+		DeviceCode:              d.DeviceCode,
+		UserCode:                d.UserCode,
+		VerificationURI:         verify,
+		VerificationURIComplete: verify + "?user_code=" + url.QueryEscape(d.UserCode),
+		ExpiresIn:               int(time.Until(d.ExpiresAt).Seconds()),
+		Interval:                int(d.Interval.Seconds()),
+	})
+}
+
+type deviceTokenRequest struct {
+	DeviceCode string `json:"device_code"`
+}
+
+// deviceToken is polled by the CLI. Pending, slow-down, denied and expired
+// states are reported as 400 responses whose "error" is the RFC 8628 code.
+func (h *Handler) deviceToken(w http.ResponseWriter, r *http.Request) {
+	var req deviceTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	token, err := h.Store.PollDeviceLogin(
+		strings.TrimSpace(req.DeviceCode),
+		h.Config.AccessTokenTTL,
+		h.Config.RefreshTokenTTL,
+		time.Now().UTC(),
+	)
+	switch {
+	case err == nil:
+	case errors.Is(err, coreauth.ErrAuthorizationPending),
+		errors.Is(err, coreauth.ErrSlowDown),
+		errors.Is(err, coreauth.ErrDeviceCodeExpired),
+		errors.Is(err, coreauth.ErrDeviceAccessDenied):
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, coreauth.ErrDeviceCodeNotFound):
+		httpErrorJSON(w, http.StatusBadRequest, "invalid_grant")
+		return
+	default:
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	e := coreaudit.FromRequest(r, token.User, coreaudit.ActionLogin)
+	e.Detail = "device"
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusOK, exchangeResponse{
+		AccessToken:  token.Token,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.ExpiresAt,
+		User:         token.User,
+	})
+}
+
+// devicePage is where the user enters (or confirms) the code shown by the
+// CLI and approves or denies the login.
+func (h *Handler) devicePage(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	userCode := strings.TrimSpace(r.Form.Get("user_code"))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if userCode == "" {
+		_, _ = fmt.Fprint(w, `<html><body><h1>envlock device login</h1><form method="get"><p>Enter the code shown in your terminal:</p><input name="user_code" autofocus autocomplete="off"><button type="submit">Continue</button></form></body></html>`)
+		return
+	}
+	now := time.Now().UTC()
+	d, err := h.Store.GetDeviceLogin(userCode, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.Method == http.MethodPost {
+		// Both decisions need the form rendered below; a GET never decides,
+		// except with the dev provider's auto-approval.
+		if !checkDeviceForm(r) {
+			http.Error(w, "invalid or missing form token; reload the page and try again", http.StatusForbidden)
+			return
+		}
+		switch r.PostForm.Get("action") {
+		case "deny":
+			if err := h.Store.DecideDeviceLogin(d.UserCode, coreauth.User{}, false, now); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			_, _ = fmt.Fprint(w, `<html><body><h1>envlock device login denied</h1><p>You can close this window.</p></body></html>`)
+		case "approve":
+			h.beginBrowserLogin(w, r, browserLogin{UserCode: d.UserCode})
+		default:
+			http.Error(w, "unknown action", http.StatusBadRequest)
+		}
+		return
+	}
+	if h.autoApprove() {
+		h.beginBrowserLogin(w, r, browserLogin{UserCode: d.UserCode})
+		return
+	}
+
+	formToken, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     deviceFormCookie,
+		Value:    formToken,
+		Path:     "/login/device",
+		Expires:  d.ExpiresAt,
+		HttpOnly: true,
+		Secure:   h.Config.SecureCookies(),
+		SameSite: http.SameSiteStrictMode,
+	})
+	h.loginPage(w, "Authorize envlock device login", fmt.Sprintf(`<p>Only continue if your terminal shows this code:</p><pre>%s</pre><form method="post"><input type="hidden" name="user_code" value="%s"><input type="hidden" name="form_token" value="%s"><button type="submit" name="action" value="approve">Sign in and approve</button> <button type="submit" name="action" value="deny">Deny</button></form>`,
+		html.EscapeString(d.UserCode), html.EscapeString(d.UserCode), formToken))
+}
+
+// checkDeviceForm reports whether a device page post echoes the token set in
+// the browser's cookie when the page was rendered.
+func checkDeviceForm(r *http.Request) bool {
+	c, err := r.Cookie(deviceFormCookie)
+	if err != nil || c.Value == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.Value), []byte(r.PostForm.Get("form_token"))) == 1
+}
+
+// completeDeviceLogin approves a device login on behalf of user.
+func (h *Handler) completeDeviceLogin(w http.ResponseWriter, userCode string, user coreauth.User) {
+	if err := h.Store.DecideDeviceLogin(userCode, user, true, time.Now().UTC()); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprint(w, `<html><body><h1>envlock CLI signed in</h1><p>Return to your terminal; you can close this window.</p></body></html>`)
+}
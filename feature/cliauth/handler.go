@@ -6,29 +6,52 @@ import (
 	"net/http"
 	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
 	coreauth "github.com/jasonchiu/envlock/core/auth"
 	coreconfig "github.com/jasonchiu/envlock/core/config"
 )
 
 type Handler struct {
-	Config coreconfig.Runtime
-	Store  *coreauth.MemoryStore
+	Config   coreconfig.Runtime
+	Store    coreauth.Store
+	Identity coreauth.IdentityProvider
+	Audit    *coreaudit.Log
+	// WebSessions, when set, lets browsers sign in to the admin web UI
+	// through the same identity provider (see webLogin).
+	WebSessions *coreauth.WebSessions
+
+	mu            sync.Mutex
+	browserLogins map[string]browserLogin
 }
 
 func (h *Handler) RegisterRoutes(r chi.Router) {
 	r.Post("/api/cli/login/start", h.start)
 	r.Get("/login/cli/authorize", h.authorizePage)
 	r.Post("/login/cli/authorize", h.authorizePage)
+	r.Get(CallbackPath, h.callback)
 	r.Post("/api/cli/login/exchange", h.exchange)
+	r.Post("/api/cli/token/refresh", h.refresh)
 	r.Get("/api/cli/whoami", h.whoami)
+	r.Post("/api/cli/logout", h.logout)
+	r.Post("/api/admin/sessions/revoke", h.adminRevokeSessions)
+	r.Post("/api/cli/device/code", h.deviceCode)
+	r.Post("/api/cli/device/token", h.deviceToken)
+	r.Get("/login/device", h.devicePage)
+	r.Post("/login/device", h.devicePage)
+	if h.WebSessions != nil {
+		r.Get(WebLoginPath, h.webLogin)
+	}
 }
 
 type startRequest struct {
-	CallbackURL string `json:"callback_url,omitempty"`
+	CallbackURL         string `json:"callback_url,omitempty"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
 }
 
 type startResponse struct {
@@ -42,7 +65,17 @@ func (h *Handler) start(w http.ResponseWriter, r *http.Request) {
 		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
-	p, err := h.Store.StartCLILogin(strings.TrimSpace(req.CallbackURL), h.Config.CLILoginCodeTTL)
+	challenge := strings.TrimSpace(req.CodeChallenge)
+	if err := coreauth.ValidatePKCEChallenge(challenge, strings.TrimSpace(req.CodeChallengeMethod)); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	callbackURL := strings.TrimSpace(req.CallbackURL)
+	if err := coreauth.ValidateLoopbackCallback(callbackURL); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	p, err := h.Store.StartCLILogin(callbackURL, challenge, h.Config.CLILoginCodeTTL)
 	if err != nil {
 		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
 		return
@@ -57,56 +90,52 @@ func (h *Handler) authorizePage(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing state", http.StatusBadRequest)
 		return
 	}
-	p, err := h.Store.GetPendingCLILogin(state, time.Now().UTC())
-	if err != nil {
+	if _, err := h.Store.GetPendingCLILogin(state, time.Now().UTC()); err != nil {
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	user := coreauth.User{
-		ID:          "dev:" + strings.ToLower(strings.TrimSpace(h.Config.DevUserEmail)),
-		Email:       strings.TrimSpace(h.Config.DevUserEmail),
-		DisplayName: strings.TrimSpace(h.Config.DevUserDisplay),
-	}
-	if user.Email == "" {
-		user.Email = "dev@example.com"
+	if r.Method == http.MethodPost || h.autoApprove() {
+		h.beginBrowserLogin(w, r, browserLogin{CLIState: state})
+		return
 	}
-	if user.ID == "dev:" {
-		user.ID = "dev:user"
+
+	h.loginPage(w, "Authorize envlock CLI login", `<p>Only continue if you just ran <code>envlock login</code>.</p><form method="post"><button type="submit">Sign in and approve</button></form>`)
+}
+
+// completeCLILogin issues the one-time code for a loopback login approved by
+// user and hands it to the CLI's callback, or shows it for copy/paste.
+func (h *Handler) completeCLILogin(w http.ResponseWriter, r *http.Request, state string, user coreauth.User) {
+	now := time.Now().UTC()
+	p, err := h.Store.GetPendingCLILogin(state, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-	if user.DisplayName == "" {
-		user.DisplayName = user.Email
+	code, err := h.Store.IssueCodeForState(state, user, h.Config.CLILoginCodeTTL, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
-
-	if r.Method == http.MethodPost || h.Config.DevAutoApproveCLI {
-		code, err := h.Store.IssueCodeForState(state, user, h.Config.CLILoginCodeTTL, time.Now().UTC())
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+	if p.CallbackURL != "" {
+		cb, err := url.Parse(p.CallbackURL)
+		if err == nil {
+			q := cb.Query()
+			q.Set("code", code.Code)
+			q.Set("state", state)
+			cb.RawQuery = q.Encode()
+			http.Redirect(w, r, cb.String(), http.StatusSeeOther)
 			return
 		}
-		if p.CallbackURL != "" {
-			cb, err := url.Parse(p.CallbackURL)
-			if err == nil {
-				q := cb.Query()
-				q.Set("code", code.Code)
-				q.Set("state", state)
-				cb.RawQuery = q.Encode()
-				http.Redirect(w, r, cb.String(), http.StatusSeeOther)
-				return
-			}
-		}
-		w.Header().Set("Content-Type", "text/html; charset=utf-8")
-		_, _ = fmt.Fprintf(w, `<html><body><h1>envlock dev login</h1><p>Copy this code into the CLI:</p><pre>%s</pre></body></html>`, code.Code)
-		return
 	}
-
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	_, _ = fmt.Fprintf(w, `<html><body><h1>Authorize envlock CLI login</h1><p>User: %s</p><form method="post"><button type="submit">Approve</button></form></body></html>`, user.Email)
+	_, _ = fmt.Fprintf(w, `<html><body><h1>envlock login</h1><p>Copy this code into the CLI:</p><pre>%s</pre></body></html>`, code.Code)
 }
 
 type exchangeRequest struct {
-	Code  string `json:"code"`
-	State string `json:"state,omitempty"`
+	Code         string `json:"code"`
+	State        string `json:"state,omitempty"`
+	CodeVerifier string `json:"code_verifier"`
 }
 
 type exchangeResponse struct {
@@ -125,6 +154,7 @@ func (h *Handler) exchange(w http.ResponseWriter, r *http.Request) {
 	token, err := h.Store.ExchangeCode(
 		strings.TrimSpace(req.Code),
 		strings.TrimSpace(req.State),
+		strings.TrimSpace(req.CodeVerifier),
 		h.Config.AccessTokenTTL,
 		h.Config.RefreshTokenTTL,
 		time.Now().UTC(),
@@ -133,6 +163,9 @@ func (h *Handler) exchange(w http.ResponseWriter, r *http.Request) {
 		httpErrorJSON(w, http.StatusUnauthorized, err.Error())
 		return
 	}
+	e := coreaudit.FromRequest(r, token.User, coreaudit.ActionLogin)
+	e.Detail = "browser"
+	h.Audit.TryRecord(r.Context(), e)
 	writeJSON(w, http.StatusOK, exchangeResponse{
 		AccessToken:  token.Token,
 		RefreshToken: token.RefreshToken,
@@ -141,22 +174,141 @@ func (h *Handler) exchange(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func (h *Handler) whoami(w http.ResponseWriter, r *http.Request) {
-	authz := strings.TrimSpace(r.Header.Get("Authorization"))
-	const prefix = "Bearer "
-	if !strings.HasPrefix(authz, prefix) {
-		httpErrorJSON(w, http.StatusUnauthorized, "missing bearer token")
+type refreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// refresh rotates a refresh token. The response has the same shape as the
+// login exchange; the presented refresh token is no longer valid afterwards.
+func (h *Handler) refresh(w http.ResponseWriter, r *http.Request) {
+	var req refreshRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
 		return
 	}
-	token := strings.TrimSpace(strings.TrimPrefix(authz, prefix))
-	user, err := h.Store.ValidateAccessToken(token, time.Now().UTC())
+	if strings.TrimSpace(req.RefreshToken) == "" {
+		httpErrorJSON(w, http.StatusBadRequest, "refresh_token is required")
+		return
+	}
+	token, err := h.Store.RefreshAccessToken(
+		strings.TrimSpace(req.RefreshToken),
+		h.Config.AccessTokenTTL,
+		h.Config.RefreshTokenTTL,
+		time.Now().UTC(),
+	)
 	if err != nil {
 		httpErrorJSON(w, http.StatusUnauthorized, err.Error())
 		return
 	}
+	writeJSON(w, http.StatusOK, exchangeResponse{
+		AccessToken:  token.Token,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    token.ExpiresAt,
+		User:         token.User,
+	})
+}
+
+func (h *Handler) whoami(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
 	writeJSON(w, http.StatusOK, user)
 }
 
+type logoutRequest struct {
+	AllSessions bool `json:"all_sessions,omitempty"`
+}
+
+type revokeResponse struct {
+	Revoked int `json:"revoked"`
+}
+
+// logout revokes the caller's login, or with all_sessions every login of the
+// caller's account.
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	user, token, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	var req logoutRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err.Error() != "EOF" {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionLogout)
+	if req.AllSessions {
+		n, err := h.Store.RevokeUserSessions(user.Email)
+		if err != nil {
+			httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		e.Detail = "all sessions"
+		h.Audit.TryRecord(r.Context(), e)
+		writeJSON(w, http.StatusOK, revokeResponse{Revoked: n})
+		return
+	}
+	if err := h.Store.RevokeSession(token); err != nil {
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusOK, revokeResponse{Revoked: 1})
+}
+
+type adminRevokeRequest struct {
+	Email string `json:"email"`
+}
+
+// adminRevokeSessions lets a server admin (ENVLOCK_SERVER_ADMIN_EMAILS) kill
+// every login of another user, e.g. a departing teammate.
+func (h *Handler) adminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user, _, ok := h.authenticate(w, r)
+	if !ok {
+		return
+	}
+	if !h.Config.IsAdmin(user.Email) {
+		httpErrorJSON(w, http.StatusForbidden, "admin access required")
+		return
+	}
+	var req adminRevokeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	email := strings.TrimSpace(req.Email)
+	if email == "" {
+		httpErrorJSON(w, http.StatusBadRequest, "email is required")
+		return
+	}
+	n, err := h.Store.RevokeUserSessions(email)
+	if err != nil {
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionSessionsRevoke)
+	e.Target = email
+	e.Detail = fmt.Sprintf("%d session(s)", n)
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusOK, revokeResponse{Revoked: n})
+}
+
+// authenticate validates the bearer token, writing a 401 and returning false
+// if it is missing or invalid.
+func (h *Handler) authenticate(w http.ResponseWriter, r *http.Request) (coreauth.User, string, bool) {
+	token, ok := coreauth.BearerToken(r)
+	if !ok {
+		httpErrorJSON(w, http.StatusUnauthorized, "missing bearer token")
+		return coreauth.User{}, "", false
+	}
+	user, err := h.Store.ValidateAccessToken(token, time.Now().UTC())
+	if err != nil {
+		httpErrorJSON(w, http.StatusUnauthorized, err.Error())
+		return coreauth.User{}, "", false
+	}
+	return user, token, true
+}
+
 func writeJSON(w http.ResponseWriter, status int, v any) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
@@ -0,0 +1,208 @@
+package cliauth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	coreconfig "github.com/jasonchiu/envlock/core/config"
+)
+
+// browserLoginTTL bounds how long a user may spend at the identity provider.
+const browserLoginTTL = 10 * time.Minute
+
+// CallbackPath is where the identity provider returns the browser.
+const CallbackPath = "/login/callback"
+
+// WebLoginPath signs a browser in to the web UI; ?next= is the page to
+// return to.
+const WebLoginPath = "/login/web"
+
+// NewIdentityProvider builds the provider selected by cfg.IdentityProvider.
+func NewIdentityProvider(ctx context.Context, cfg coreconfig.Runtime) (coreauth.IdentityProvider, error) {
+	switch cfg.IdentityProvider {
+	case coreconfig.IdentityProviderDev:
+		return &coreauth.DevProvider{User: devUser(cfg), CallbackURL: cfg.BaseURL + CallbackPath}, nil
+	case coreconfig.IdentityProviderOIDC:
+		return coreauth.NewOIDCProvider(ctx, coreauth.OIDCConfig{
+			Issuer:         cfg.OIDCIssuer,
+			ClientID:       cfg.OIDCClientID,
+			ClientSecret:   cfg.OIDCClientSecret,
+			RedirectURL:    cfg.BaseURL + CallbackPath,
+			AllowedDomains: cfg.OIDCAllowedDomains,
+		})
+	default:
+		return nil, fmt.Errorf("unknown identity provider %q (want %q or %q)", cfg.IdentityProvider, coreconfig.IdentityProviderDev, coreconfig.IdentityProviderOIDC)
+	}
+}
+
+// autoApprove reports whether login pages may skip the confirmation form.
+// Only the dev provider allows it: with a real identity provider a signed-in
+// user opening a link someone else started would approve that login unseen.
+func (h *Handler) autoApprove() bool {
+	return h.Config.DevAutoApproveCLI && h.Identity.Name() == coreconfig.IdentityProviderDev
+}
+
+// devUser is the identity the dev provider signs every browser in as.
+func devUser(cfg coreconfig.Runtime) coreauth.User {
+	user := coreauth.User{
+		ID:          "dev:" + strings.ToLower(strings.TrimSpace(cfg.DevUserEmail)),
+		Email:       strings.TrimSpace(cfg.DevUserEmail),
+		DisplayName: strings.TrimSpace(cfg.DevUserDisplay),
+	}
+	if user.Email == "" {
+		user.Email = "dev@example.com"
+	}
+	if user.ID == "dev:" {
+		user.ID = "dev:user"
+	}
+	if user.DisplayName == "" {
+		user.DisplayName = user.Email
+	}
+	return user
+}
+
+// browserLogin is a round trip to the identity provider in progress. It
+// approves either a loopback CLI login (CLIState) or a device login
+// (UserCode), or signs the browser in to the web UI (WebNext), once the
+// provider vouches for the user.
+type browserLogin struct {
+	Nonce     string
+	Verifier  string
+	CLIState  string
+	UserCode  string
+	WebNext   string
+	ExpiresAt time.Time
+}
+
+// beginBrowserLogin remembers what to approve and sends the browser to the
+// identity provider.
+func (h *Handler) beginBrowserLogin(w http.ResponseWriter, r *http.Request, bl browserLogin) {
+	state, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomToken()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	verifier, challenge, err := coreauth.NewPKCEVerifier()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	bl.Nonce = nonce
+	bl.Verifier = verifier
+	bl.ExpiresAt = now.Add(browserLoginTTL)
+
+	h.mu.Lock()
+	if h.browserLogins == nil {
+		h.browserLogins = map[string]browserLogin{}
+	}
+	for k, v := range h.browserLogins {
+		if now.After(v.ExpiresAt) {
+			delete(h.browserLogins, k)
+		}
+	}
+	h.browserLogins[state] = bl
+	h.mu.Unlock()
+
+	http.Redirect(w, r, h.Identity.AuthURL(state, nonce, challenge), http.StatusSeeOther)
+}
+
+func (h *Handler) takeBrowserLogin(state string, now time.Time) (browserLogin, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bl, ok := h.browserLogins[state]
+	if !ok {
+		return browserLogin{}, false
+	}
+	delete(h.browserLogins, state)
+	return bl, !now.After(bl.ExpiresAt)
+}
+
+// callback completes a browser login: the provider's code is exchanged for a
+// verified user, who then approves the CLI or device login that started it.
+func (h *Handler) callback(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		msg := e
+		if d := q.Get("error_description"); d != "" {
+			msg += ": " + d
+		}
+		http.Error(w, "identity provider: "+msg, http.StatusForbidden)
+		return
+	}
+	bl, ok := h.takeBrowserLogin(q.Get("state"), time.Now().UTC())
+	if !ok {
+		http.Error(w, "login expired or unknown; start again from the CLI", http.StatusBadRequest)
+		return
+	}
+	user, err := h.Identity.Exchange(r.Context(), q.Get("code"), bl.Nonce, bl.Verifier)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, coreauth.ErrEmailDomainDenied) || errors.Is(err, coreauth.ErrEmailNotVerified) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	switch {
+	case bl.CLIState != "":
+		h.completeCLILogin(w, r, bl.CLIState, user)
+	case bl.UserCode != "":
+		h.completeDeviceLogin(w, bl.UserCode, user)
+	case bl.WebNext != "" && h.WebSessions != nil:
+		h.completeWebLogin(w, r, bl.WebNext, user)
+	default:
+		http.Error(w, "nothing to approve", http.StatusBadRequest)
+	}
+}
+
+func (h *Handler) webLogin(w http.ResponseWriter, r *http.Request) {
+	next := coreauth.SafeRedirectPath(r.URL.Query().Get("next"), "/ui")
+	h.beginBrowserLogin(w, r, browserLogin{WebNext: next})
+}
+
+// completeWebLogin starts a web UI session for user and returns the browser
+// to the page that asked for it.
+func (h *Handler) completeWebLogin(w http.ResponseWriter, r *http.Request, next string, user coreauth.User) {
+	now := time.Now().UTC()
+	token, sess, err := h.WebSessions.Create(r.Context(), user, h.Config.WebSessionTTL, now)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionLogin)
+	e.Detail = "web"
+	h.Audit.TryRecord(r.Context(), e)
+	coreauth.SetWebSessionCookie(w, token, sess.ExpiresAt, h.Config.SecureCookies())
+	http.Redirect(w, r, next, http.StatusSeeOther)
+}
+
+// loginPage renders the confirmation shown before handing off to the
+// identity provider.
+func (h *Handler) loginPage(w http.ResponseWriter, title, body string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = fmt.Fprintf(w, `<html><body><h1>%s</h1>%s<p><small>Sign-in: %s</small></p></body></html>`,
+		html.EscapeString(title), body, html.EscapeString(h.Identity.Name()))
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,68 @@
+package enroll
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"strings"
+)
+
+var ErrSASMismatch = errors.New("verification code does not match")
+
+// sasEncoding is Crockford's base32 alphabet: no I, L, O or U, so codes read
+// aloud or retyped survive common confusions (see NormalizeSAS).
+var sasEncoding = base32.NewEncoding("0123456789ABCDEFGHJKMNPQRSTVWXYZ").WithPadding(base32.NoPadding)
+
+// sasLength is the number of code characters: 50 bits, far more than can be
+// ground out by generating keys during an invite's lifetime.
+const sasLength = 10
+
+// SAS returns the short authentication string for a join request with
+// publicKey against the invite named by token, e.g. "7K2QD-M9XHA".
+//
+// Only the joiner and the inviter know the token secret. The joiner prints
+// the code for its own key; the approver recomputes it for the key stored in
+// the request. If someone with write access to the request replaced the key,
+// the two codes differ.
+func SAS(token, publicKey string) (string, error) {
+	_, secret, err := ParseToken(token)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, []byte("envlock-sas-v1\x00"+strings.TrimSpace(secret)))
+	mac.Write([]byte(strings.TrimSpace(publicKey)))
+	code := sasEncoding.EncodeToString(mac.Sum(nil))[:sasLength]
+	return code[:sasLength/2] + "-" + code[sasLength/2:], nil
+}
+
+// NormalizeSAS uppercases code, drops separators and maps look-alike letters
+// to the digits they stand for.
+func NormalizeSAS(code string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(code) {
+		switch r {
+		case '-', ' ', '\t':
+			continue
+		case 'O':
+			r = '0'
+		case 'I', 'L':
+			r = '1'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// VerifySAS checks a code typed by the approver against the one computed for
+// publicKey.
+func VerifySAS(token, publicKey, code string) error {
+	want, err := SAS(token, publicKey)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(NormalizeSAS(want)), []byte(NormalizeSAS(code))) {
+		return ErrSASMismatch
+	}
+	return nil
+}
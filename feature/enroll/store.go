@@ -42,6 +42,10 @@ type Invite struct {
 	CreatedBy       string    `json:"created_by,omitempty"`
 	UsedByRequestID string    `json:"used_by_request_id,omitempty"`
 	UsedAt          time.Time `json:"used_at,omitempty"`
+
+	// Revision is the backend version token from the last load (see
+	// backend.Store); empty for invites that have not been saved yet.
+	Revision string `json:"-"`
 }
 
 type Request struct {
@@ -56,6 +60,10 @@ type Request struct {
 	DeviceName  string `json:"device_name"`
 	PublicKey   string `json:"public_key"`
 	Fingerprint string `json:"fingerprint"`
+
+	// Revision is the backend version token from the last load (see
+	// backend.Store); empty for requests that have not been saved yet.
+	Revision string `json:"-"`
 }
 
 func InvitesDir(projectEnvlockDir string) string {
@@ -0,0 +1,320 @@
+package enrollment
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	"github.com/jasonchiu/envlock/feature/enroll"
+	"github.com/jasonchiu/envlock/feature/projects"
+	"github.com/jasonchiu/envlock/feature/recipients"
+)
+
+// MaxInviteTTL bounds how long an invite token stays usable.
+const MaxInviteTTL = 7 * 24 * time.Hour
+
+// Handler serves enrollment for server projects. All routes require a CLI
+// access token.
+//
+//	GET  /api/projects/{projectID}/recipients                       member; recipients.Store with ETag
+//	PUT  /api/projects/{projectID}/recipients                       admin; If-Match / If-None-Match: *
+//	POST /api/projects/{projectID}/invites                          admin; {"ttl_seconds","created_by"} -> {"invite","token"}
+//	GET  /api/projects/{projectID}/invites                          admin
+//	GET  /api/projects/{projectID}/invites/{inviteID}               admin
+//	POST /api/invites/{token}/join                                  any user; {"device_name","public_key"} -> Request
+//	GET  /api/projects/{projectID}/requests                         admin
+//	GET  /api/projects/{projectID}/requests/{requestID}             admin
+//	POST /api/projects/{projectID}/requests/{requestID}/approve     admin; {"note","fingerprint"}
+//	POST /api/projects/{projectID}/requests/{requestID}/reject      admin; {"reason"}
+type Handler struct {
+	Auth     coreauth.Store
+	Projects *projects.Store
+	Store    *Store
+	Audit    *coreaudit.Log
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(coreauth.RequireBearer(h.Auth))
+		r.Post("/api/invites/{token}/join", h.join)
+
+		member := r.With(projects.RequireRole(h.Projects, projects.RoleMember))
+		admin := r.With(projects.RequireRole(h.Projects, projects.RoleAdmin))
+		member.Get("/api/projects/{projectID}/recipients", h.getRecipients)
+		admin.Put("/api/projects/{projectID}/recipients", h.putRecipients)
+		admin.Post("/api/projects/{projectID}/invites", h.createInvite)
+		admin.Get("/api/projects/{projectID}/invites", h.listInvites)
+		admin.Get("/api/projects/{projectID}/invites/{inviteID}", h.getInvite)
+		admin.Get("/api/projects/{projectID}/requests", h.listRequests)
+		admin.Get("/api/projects/{projectID}/requests/{requestID}", h.getRequest)
+		admin.Post("/api/projects/{projectID}/requests/{requestID}/approve", h.approve)
+		admin.Post("/api/projects/{projectID}/requests/{requestID}/reject", h.reject)
+	})
+}
+
+type createInviteRequest struct {
+	TTLSeconds int    `json:"ttl_seconds"`
+	CreatedBy  string `json:"created_by"`
+}
+
+type createInviteResponse struct {
+	Invite enroll.Invite `json:"invite"`
+	Token  string        `json:"token"`
+}
+
+type joinRequest struct {
+	DeviceName string `json:"device_name"`
+	PublicKey  string `json:"public_key"`
+}
+
+type decisionRequest struct {
+	Note   string `json:"note,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	// Fingerprint is the device the approver verified; approve requires it
+	// and it must match the request's device.
+	Fingerprint string `json:"fingerprint,omitempty"`
+}
+
+type approveResponse struct {
+	Request        Request `json:"request"`
+	RecipientAdded bool    `json:"recipient_added"`
+}
+
+func (h *Handler) getRecipients(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	rs, err := h.Store.Recipients(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if rs.Revision == "" {
+		httpErrorJSON(w, http.StatusNotFound, "recipients not initialized")
+		return
+	}
+	w.Header().Set("ETag", rs.Revision)
+	writeJSON(w, http.StatusOK, rs)
+}
+
+// putRecipients replaces the document. Devices that were active before and
+// are not afterwards are recorded as revoked in the audit log.
+func (h *Handler) putRecipients(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	revision := strings.TrimSpace(r.Header.Get("If-Match"))
+	if revision == "" && strings.TrimSpace(r.Header.Get("If-None-Match")) != "*" {
+		httpErrorJSON(w, http.StatusPreconditionRequired, "If-Match or If-None-Match: * is required")
+		return
+	}
+	var rs recipients.Store
+	if err := json.NewDecoder(r.Body).Decode(&rs); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	before, err := h.Store.Recipients(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	etag, err := h.Store.PutRecipients(r.Context(), id, rs, revision, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	user, _ := coreauth.UserFromContext(r.Context())
+	still := map[string]bool{}
+	for _, fp := range rs.ActiveFingerprints() {
+		still[fp] = true
+	}
+	for _, rec := range before.Recipients {
+		if rec.Status == recipients.StatusActive && !still[rec.Fingerprint] {
+			e := coreaudit.FromRequest(r, user, coreaudit.ActionDeviceRevoke)
+			e.ProjectID = id
+			e.Target = rec.Fingerprint
+			e.Detail = rec.Name
+			h.Audit.TryRecord(r.Context(), e)
+		}
+	}
+	w.Header().Set("ETag", etag)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) createInvite(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	user, _ := coreauth.UserFromContext(r.Context())
+	var req createInviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	ttl := time.Duration(req.TTLSeconds) * time.Second
+	if ttl <= 0 || ttl > MaxInviteTTL {
+		httpErrorJSON(w, http.StatusBadRequest, "ttl_seconds must be between 1 and "+MaxInviteTTL.String())
+		return
+	}
+	inv, token, err := h.Store.CreateInvite(r.Context(), id, user, req.CreatedBy, ttl)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionInviteCreate)
+	e.ProjectID = id
+	e.Target = inv.ID
+	e.Detail = "expires " + inv.ExpiresAt.Format(time.RFC3339)
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusCreated, createInviteResponse{Invite: inv, Token: token})
+}
+
+func (h *Handler) listInvites(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	out, err := h.Store.ListInvites(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) getInvite(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	inv, err := h.Store.Invite(r.Context(), id, chi.URLParam(r, "inviteID"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, inv)
+}
+
+func (h *Handler) join(w http.ResponseWriter, r *http.Request) {
+	user, _ := coreauth.UserFromContext(r.Context())
+	var req joinRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	out, err := h.Store.Join(r.Context(), chi.URLParam(r, "token"), user, req.DeviceName, req.PublicKey)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionEnrollJoin)
+	e.ProjectID = out.ProjectID
+	e.Target = out.ID
+	e.Detail = out.DeviceName + " " + out.Fingerprint
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusCreated, out)
+}
+
+func (h *Handler) listRequests(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	out, err := h.Store.ListRequests(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) getRequest(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	out, err := h.Store.Request(r.Context(), id, chi.URLParam(r, "requestID"))
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) approve(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	user, _ := coreauth.UserFromContext(r.Context())
+	var req decisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	out, added, err := h.Store.Approve(r.Context(), id, chi.URLParam(r, "requestID"), req.Fingerprint, user, req.Note, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionEnrollApprove)
+	e.ProjectID = id
+	e.Target = out.ID
+	e.Detail = out.UserEmail + " " + out.DeviceName + " " + out.Fingerprint
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusOK, approveResponse{Request: out, RecipientAdded: added})
+}
+
+func (h *Handler) reject(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	user, _ := coreauth.UserFromContext(r.Context())
+	var req decisionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	out, err := h.Store.Reject(r.Context(), id, chi.URLParam(r, "requestID"), user, req.Reason, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionEnrollReject)
+	e.ProjectID = id
+	e.Target = out.ID
+	e.Detail = out.UserEmail + " " + out.DeviceName + " " + out.Fingerprint
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusOK, out)
+}
+
+// RedactTokens hides invite token secrets in the request URI that request
+// loggers print, so access logs never hold a usable token. Install it before
+// the logger.
+func RedactTokens(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "/api/invites/"
+		if rest, ok := strings.CutPrefix(r.RequestURI, prefix); ok {
+			if id, tail, ok := strings.Cut(rest, "."); ok {
+				r2 := *r
+				r2.RequestURI = prefix + id + ".REDACTED"
+				if _, after, ok := strings.Cut(tail, "/"); ok {
+					r2.RequestURI += "/" + after
+				}
+				r = &r2
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrPreconditionFailed):
+		httpErrorJSON(w, http.StatusPreconditionFailed, err.Error())
+	case errors.Is(err, enroll.ErrInvalidToken), errors.Is(err, ErrInvalidPublicKey), errors.Is(err, ErrFingerprintRequired):
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, enroll.ErrInviteNotFound), errors.Is(err, enroll.ErrRequestNotFound):
+		httpErrorJSON(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, enroll.ErrInviteExpired), errors.Is(err, enroll.ErrInviteUsed):
+		httpErrorJSON(w, http.StatusGone, err.Error())
+	case errors.Is(err, ErrNotPending), errors.Is(err, ErrDuplicateRequest), errors.Is(err, ErrFingerprintMismatch), errors.Is(err, recipients.ErrDuplicateRecipient):
+		httpErrorJSON(w, http.StatusConflict, err.Error())
+	default:
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func httpErrorJSON(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"error": strings.TrimSpace(msg)})
+}
@@ -0,0 +1,504 @@
+// Package enrollment is the server side of device enrollment for server
+// projects: the project recipients document, invites and join requests. It
+// reuses the pure helpers of feature/enroll and feature/recipients so the
+// rules match the Tigris and localfs flows, but the server keeps only the
+// hash of each invite secret and binds every join request to the logged-in
+// user who submitted it. Approving a request adds its device to the
+// recipients and its user to the project, in one transaction.
+package enrollment
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	"github.com/jasonchiu/envlock/core/keys"
+	"github.com/jasonchiu/envlock/feature/enroll"
+	"github.com/jasonchiu/envlock/feature/projects"
+	"github.com/jasonchiu/envlock/feature/recipients"
+)
+
+var (
+	ErrPreconditionFailed  = errors.New("recipients were modified by another writer")
+	ErrNotPending          = errors.New("request is not pending")
+	ErrInvalidPublicKey    = errors.New("invalid age public key")
+	ErrDuplicateRequest    = errors.New("duplicate enrollment request")
+	ErrFingerprintMismatch = errors.New("request device does not match the verified fingerprint")
+	ErrFingerprintRequired = errors.New("fingerprint of the verified device is required to approve")
+)
+
+// Request is an enrollment request with the server user who submitted it.
+type Request struct {
+	enroll.Request
+	ProjectID string `json:"project_id"`
+	// ProjectName is only set on the request returned by Join, for joiners
+	// who are not project members yet.
+	ProjectName string `json:"project_name,omitempty"`
+	UserID      string `json:"user_id"`
+	UserEmail   string `json:"user_email"`
+	DecidedBy   string `json:"decided_by,omitempty"`
+}
+
+// Store keeps enrollment state in the server database (see core/db).
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Recipients returns the project's recipients document. A project without
+// one yet has an empty store with no Revision.
+func (s *Store) Recipients(ctx context.Context, projectID string) (recipients.Store, error) {
+	return loadRecipients(ctx, s.db, projectID)
+}
+
+// PutRecipients replaces the recipients document if it is still at revision
+// (empty: only if none exists) and returns the new revision.
+func (s *Store) PutRecipients(ctx context.Context, projectID string, rs recipients.Store, revision string, now time.Time) (string, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	current, err := loadRecipients(ctx, tx, projectID)
+	if err != nil {
+		return "", err
+	}
+	if current.Revision != revision {
+		return "", ErrPreconditionFailed
+	}
+	rev, err := writeRecipients(ctx, tx, projectID, rs, now)
+	if err != nil {
+		return "", err
+	}
+	return rev, tx.Commit()
+}
+
+// RevokeRecipient marks the recipient matching query (name or fingerprint)
+// revoked. changed is false when it already was.
+func (s *Store) RevokeRecipient(ctx context.Context, projectID, query string, now time.Time) (rec recipients.Recipient, changed bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return recipients.Recipient{}, false, err
+	}
+	defer tx.Rollback()
+	rs, err := loadRecipients(ctx, tx, projectID)
+	if err != nil {
+		return recipients.Recipient{}, false, err
+	}
+	before := rs.ActiveSetHash()
+	rec, err = rs.Revoke(query)
+	if err != nil {
+		return recipients.Recipient{}, false, err
+	}
+	if rs.ActiveSetHash() == before {
+		return rec, false, nil
+	}
+	if _, err := writeRecipients(ctx, tx, projectID, rs, now); err != nil {
+		return recipients.Recipient{}, false, err
+	}
+	return rec, true, tx.Commit()
+}
+
+// CreateInvite issues an invite for the project and returns it with the
+// one-time token. Only the hash of the token secret is stored, and invites
+// returned by the Store never carry it.
+func (s *Store) CreateInvite(ctx context.Context, projectID string, creator coreauth.User, createdBy string, ttl time.Duration) (enroll.Invite, string, error) {
+	inv, token, err := enroll.NewInvite(ttl, createdBy)
+	if err != nil {
+		return enroll.Invite{}, "", err
+	}
+	if _, err := s.db.ExecContext(ctx,
+		`INSERT INTO enroll_invites (id, project_id, secret_hash, status, created_by, created_by_user, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		inv.ID, projectID, inv.SecretHash, inv.Status, inv.CreatedBy, creator.ID,
+		inv.CreatedAt.UnixMilli(), inv.ExpiresAt.UnixMilli(),
+	); err != nil {
+		return enroll.Invite{}, "", err
+	}
+	inv.SecretHash = ""
+	return inv, token, nil
+}
+
+func (s *Store) ListInvites(ctx context.Context, projectID string) ([]enroll.Invite, error) {
+	rows, err := s.db.QueryContext(ctx, selectInvites+` WHERE project_id = ? ORDER BY created_at DESC`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []enroll.Invite{}
+	for rows.Next() {
+		inv, _, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		inv.SecretHash = ""
+		out = append(out, inv)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) Invite(ctx context.Context, projectID, id string) (enroll.Invite, error) {
+	inv, pid, err := scanInvite(s.db.QueryRowContext(ctx, selectInvites+` WHERE id = ?`, id))
+	if err != nil {
+		return enroll.Invite{}, err
+	}
+	if pid != projectID {
+		return enroll.Invite{}, enroll.ErrInviteNotFound
+	}
+	inv.SecretHash = ""
+	return inv, nil
+}
+
+// Join files a pending request for user's device against the invite named by
+// token. The invite's project is returned with the request.
+func (s *Store) Join(ctx context.Context, token string, user coreauth.User, deviceName, publicKey string) (Request, error) {
+	inviteID, _, err := enroll.ParseToken(token)
+	if err != nil {
+		return Request{}, err
+	}
+	publicKey = strings.TrimSpace(publicKey)
+	if err := keys.ValidateRecipientString(publicKey); err != nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrInvalidPublicKey, err)
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Request{}, err
+	}
+	defer tx.Rollback()
+
+	inv, projectID, err := scanInvite(tx.QueryRowContext(ctx, selectInvites+` WHERE id = ?`, inviteID))
+	if errors.Is(err, enroll.ErrInviteNotFound) {
+		// Indistinguishable from a wrong secret for the caller.
+		return Request{}, enroll.ErrInvalidToken
+	}
+	if err != nil {
+		return Request{}, err
+	}
+	if err := enroll.VerifyToken(inv, token); err != nil {
+		return Request{}, err
+	}
+	if err := enroll.ValidateInviteForJoin(inv, time.Now().UTC()); err != nil {
+		return Request{}, err
+	}
+	var projectName string
+	if err := tx.QueryRowContext(ctx, `SELECT name FROM projects WHERE id = ?`, projectID).Scan(&projectName); err != nil {
+		return Request{}, err
+	}
+	existing, err := listRequests(ctx, tx, projectID)
+	if err != nil {
+		return Request{}, err
+	}
+	plain := make([]enroll.Request, len(existing))
+	for i, r := range existing {
+		plain[i] = r.Request
+	}
+	name := strings.TrimSpace(deviceName)
+	if name == "" {
+		name = "device"
+	}
+	er, err := enroll.NewJoinRequest(plain, inv, name, publicKey, keys.Fingerprint(publicKey))
+	if err != nil {
+		return Request{}, fmt.Errorf("%w: %v", ErrDuplicateRequest, err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO enroll_requests (id, project_id, invite_id, user_id, status, device_name, public_key, fingerprint, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		er.ID, projectID, er.InviteID, user.ID, er.Status, er.DeviceName, er.PublicKey, er.Fingerprint, er.CreatedAt.UnixMilli(),
+	); err != nil {
+		return Request{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Request{}, err
+	}
+	return Request{Request: er, ProjectID: projectID, ProjectName: projectName, UserID: user.ID, UserEmail: user.Email}, nil
+}
+
+// ListRequests returns the project's requests, newest first.
+func (s *Store) ListRequests(ctx context.Context, projectID string) ([]Request, error) {
+	return listRequests(ctx, s.db, projectID)
+}
+
+func (s *Store) Request(ctx context.Context, projectID, id string) (Request, error) {
+	return loadRequest(ctx, s.db, projectID, id)
+}
+
+// VerifyJoinCode checks the verification code the joiner printed against the
+// request's key, using the invite token the approver holds. The token is only
+// used for the check and never stored.
+func (s *Store) VerifyJoinCode(ctx context.Context, projectID, id, token, code string) (Request, error) {
+	req, err := loadRequest(ctx, s.db, projectID, id)
+	if err != nil {
+		return Request{}, err
+	}
+	inv, _, err := scanInvite(s.db.QueryRowContext(ctx, selectInvites+` WHERE id = ?`, req.InviteID))
+	if err != nil {
+		return Request{}, err
+	}
+	if err := enroll.VerifyToken(inv, token); err != nil {
+		return Request{}, err
+	}
+	if err := enroll.VerifySAS(token, req.PublicKey, code); err != nil {
+		return Request{}, err
+	}
+	return req, nil
+}
+
+// Approve accepts a pending request: its device becomes an active recipient,
+// its user a project member, and its invite is used up. added is false when
+// the device was already a recipient. fingerprint is the device the approver
+// verified (see VerifyJoinCode) and must match the request's device.
+func (s *Store) Approve(ctx context.Context, projectID, id, fingerprint string, approver coreauth.User, note string, now time.Time) (req Request, added bool, err error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Request{}, false, err
+	}
+	defer tx.Rollback()
+	req, err = loadRequest(ctx, tx, projectID, id)
+	if err != nil {
+		return Request{}, false, err
+	}
+	if req.Status != enroll.RequestStatusPending {
+		return Request{}, false, fmt.Errorf("%w: request %s is %s", ErrNotPending, req.ID, req.Status)
+	}
+	if strings.TrimSpace(fingerprint) == "" {
+		return Request{}, false, ErrFingerprintRequired
+	}
+	if fingerprint != req.Fingerprint {
+		return Request{}, false, ErrFingerprintMismatch
+	}
+	inv, _, err := scanInvite(tx.QueryRowContext(ctx, selectInvites+` WHERE id = ?`, req.InviteID))
+	if err != nil {
+		return Request{}, false, err
+	}
+	if err := enroll.ValidateInviteForApproval(inv); err != nil {
+		return Request{}, false, err
+	}
+
+	rs, err := loadRecipients(ctx, tx, projectID)
+	if err != nil {
+		return Request{}, false, err
+	}
+	err = rs.Add(recipients.Recipient{
+		Name:        req.DeviceName,
+		PublicKey:   req.PublicKey,
+		Fingerprint: req.Fingerprint,
+		CreatedAt:   now,
+		Status:      recipients.StatusActive,
+		Source:      "enroll-approve",
+		Note:        "Added via enrollment request " + req.ID,
+	})
+	switch {
+	case err == nil:
+		added = true
+		if _, err := writeRecipients(ctx, tx, projectID, rs, now); err != nil {
+			return Request{}, false, err
+		}
+	case errors.Is(err, recipients.ErrDuplicateRecipient):
+	default:
+		return Request{}, false, err
+	}
+	if err := projects.EnsureMember(ctx, tx, projectID, req.UserID, projects.RoleMember, now); err != nil {
+		return Request{}, false, err
+	}
+
+	req.Status = enroll.RequestStatusApproved
+	req.DecisionAt = now
+	req.DecisionNote = strings.TrimSpace(note)
+	req.DecidedBy = approver.Email
+	if err := decide(ctx, tx, req); err != nil {
+		return Request{}, false, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE enroll_invites SET status = ?, used_by_request_id = ?, used_at = ? WHERE id = ?`,
+		enroll.InviteStatusUsed, req.ID, now.UnixMilli(), inv.ID,
+	); err != nil {
+		return Request{}, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Request{}, false, err
+	}
+	return req, added, nil
+}
+
+// Reject declines a pending request. Its invite stays usable until it
+// expires.
+func (s *Store) Reject(ctx context.Context, projectID, id string, approver coreauth.User, reason string, now time.Time) (Request, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Request{}, err
+	}
+	defer tx.Rollback()
+	req, err := loadRequest(ctx, tx, projectID, id)
+	if err != nil {
+		return Request{}, err
+	}
+	if req.Status != enroll.RequestStatusPending {
+		return Request{}, fmt.Errorf("%w: request %s is %s", ErrNotPending, req.ID, req.Status)
+	}
+	req.Status = enroll.RequestStatusRejected
+	req.DecisionAt = now
+	req.DecisionNote = strings.TrimSpace(reason)
+	req.DecidedBy = approver.Email
+	if err := decide(ctx, tx, req); err != nil {
+		return Request{}, err
+	}
+	return req, tx.Commit()
+}
+
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func loadRecipients(ctx context.Context, q queryer, projectID string) (recipients.Store, error) {
+	var (
+		doc string
+		rev int64
+	)
+	err := q.QueryRowContext(ctx,
+		`SELECT doc, revision FROM project_recipients WHERE project_id = ?`, projectID,
+	).Scan(&doc, &rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return recipients.Store{Version: 1, Recipients: []recipients.Recipient{}}, nil
+	}
+	if err != nil {
+		return recipients.Store{}, err
+	}
+	var rs recipients.Store
+	if err := json.Unmarshal([]byte(doc), &rs); err != nil {
+		return recipients.Store{}, fmt.Errorf("decode recipients: %w", err)
+	}
+	if rs.Version == 0 {
+		rs.Version = 1
+	}
+	if rs.Recipients == nil {
+		rs.Recipients = []recipients.Recipient{}
+	}
+	rs.Revision = revisionTag(rev)
+	return rs, nil
+}
+
+func writeRecipients(ctx context.Context, tx *sql.Tx, projectID string, rs recipients.Store, now time.Time) (string, error) {
+	if rs.Version == 0 {
+		rs.Version = 1
+	}
+	doc, err := json.Marshal(rs)
+	if err != nil {
+		return "", err
+	}
+	var rev int64
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO project_recipients (project_id, doc, revision, updated_at) VALUES (?, ?, 1, ?)
+		ON CONFLICT (project_id) DO UPDATE SET doc = excluded.doc, revision = revision + 1, updated_at = excluded.updated_at
+		RETURNING revision`,
+		projectID, string(doc), now.UnixMilli(),
+	).Scan(&rev)
+	if err != nil {
+		return "", err
+	}
+	return revisionTag(rev), nil
+}
+
+// revisionTag is the ETag of a recipients document revision.
+func revisionTag(rev int64) string {
+	return `"` + strconv.FormatInt(rev, 10) + `"`
+}
+
+const selectInvites = `SELECT id, project_id, secret_hash, status, created_by, created_at, expires_at,
+	used_by_request_id, used_at FROM enroll_invites`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanInvite(row scanner) (enroll.Invite, string, error) {
+	var (
+		inv                  enroll.Invite
+		projectID            string
+		createdAt, expiresAt int64
+		usedAt               sql.NullInt64
+	)
+	err := row.Scan(&inv.ID, &projectID, &inv.SecretHash, &inv.Status, &inv.CreatedBy,
+		&createdAt, &expiresAt, &inv.UsedByRequestID, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return enroll.Invite{}, "", enroll.ErrInviteNotFound
+	}
+	if err != nil {
+		return enroll.Invite{}, "", err
+	}
+	inv.Version = 1
+	inv.CreatedAt = fromMillis(createdAt)
+	inv.ExpiresAt = fromMillis(expiresAt)
+	if usedAt.Valid {
+		inv.UsedAt = fromMillis(usedAt.Int64)
+	}
+	return inv, projectID, nil
+}
+
+const selectRequests = `SELECT r.id, r.project_id, r.invite_id, r.user_id, u.email, r.status, r.device_name,
+	r.public_key, r.fingerprint, r.created_at, r.decision_at, r.decision_note, r.decided_by
+	FROM enroll_requests r JOIN users u ON u.id = r.user_id`
+
+func listRequests(ctx context.Context, q queryer, projectID string) ([]Request, error) {
+	rows, err := q.QueryContext(ctx, selectRequests+` WHERE r.project_id = ? ORDER BY r.created_at DESC`, projectID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Request{}
+	for rows.Next() {
+		r, err := scanRequest(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func loadRequest(ctx context.Context, q queryer, projectID, id string) (Request, error) {
+	r, err := scanRequest(q.QueryRowContext(ctx, selectRequests+` WHERE r.id = ? AND r.project_id = ?`, id, projectID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Request{}, enroll.ErrRequestNotFound
+	}
+	return r, err
+}
+
+func scanRequest(row scanner) (Request, error) {
+	var (
+		r          Request
+		createdAt  int64
+		decisionAt sql.NullInt64
+	)
+	if err := row.Scan(&r.ID, &r.ProjectID, &r.InviteID, &r.UserID, &r.UserEmail, &r.Status, &r.DeviceName,
+		&r.PublicKey, &r.Fingerprint, &createdAt, &decisionAt, &r.DecisionNote, &r.DecidedBy); err != nil {
+		return Request{}, err
+	}
+	r.Version = 1
+	r.CreatedAt = fromMillis(createdAt)
+	if decisionAt.Valid {
+		r.DecisionAt = fromMillis(decisionAt.Int64)
+	}
+	return r, nil
+}
+
+func decide(ctx context.Context, tx *sql.Tx, req Request) error {
+	_, err := tx.ExecContext(ctx,
+		`UPDATE enroll_requests SET status = ?, decision_at = ?, decision_note = ?, decided_by = ? WHERE id = ?`,
+		req.Status, req.DecisionAt.UnixMilli(), req.DecisionNote, req.DecidedBy, req.ID,
+	)
+	return err
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
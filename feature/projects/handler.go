@@ -0,0 +1,260 @@
+package projects
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+)
+
+// Handler serves the project and membership API. All routes require a CLI
+// access token.
+//
+//	GET    /api/projects                          projects of the caller (with role)
+//	POST   /api/projects                          {"name"} -> project, caller is admin
+//	GET    /api/projects/{projectID}              member
+//	PATCH  /api/projects/{projectID}              {"name"}, admin
+//	DELETE /api/projects/{projectID}              admin
+//	GET    /api/projects/{projectID}/members      member
+//	POST   /api/projects/{projectID}/members      {"email","role"} add or change role, admin
+//	DELETE /api/projects/{projectID}/members/{userID}  admin
+type Handler struct {
+	Auth  coreauth.Store
+	Store *Store
+	Audit *coreaudit.Log
+	// Deleted, if set, runs after a project's rows are deleted to remove
+	// state kept outside the database (secret ciphertext files).
+	Deleted func(ctx context.Context, projectID string) error
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(coreauth.RequireBearer(h.Auth))
+		r.Get("/api/projects", h.list)
+		r.Post("/api/projects", h.create)
+
+		member := r.With(RequireRole(h.Store, RoleMember))
+		admin := r.With(RequireRole(h.Store, RoleAdmin))
+		member.Get("/api/projects/{projectID}", h.get)
+		admin.Patch("/api/projects/{projectID}", h.rename)
+		admin.Delete("/api/projects/{projectID}", h.delete)
+		member.Get("/api/projects/{projectID}/members", h.members)
+		admin.Post("/api/projects/{projectID}/members", h.setMember)
+		admin.Delete("/api/projects/{projectID}/members/{userID}", h.removeMember)
+	})
+}
+
+type projectContextKey struct{}
+
+type projectAccess struct {
+	ID   string
+	Role Role
+}
+
+// RequireRole loads the caller's role in the {projectID} route parameter and
+// rejects non-members with 404 and members below min with 403. It must run
+// after coreauth.RequireBearer.
+func RequireRole(store *Store, min Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := coreauth.UserFromContext(r.Context())
+			if !ok {
+				httpErrorJSON(w, http.StatusUnauthorized, "not authenticated")
+				return
+			}
+			id := chi.URLParam(r, "projectID")
+			role, err := store.Role(r.Context(), id, user.ID)
+			switch {
+			case err == nil:
+			case errors.Is(err, ErrProjectNotFound), errors.Is(err, ErrNotMember):
+				// Do not reveal which project IDs exist to non-members.
+				httpErrorJSON(w, http.StatusNotFound, ErrProjectNotFound.Error())
+				return
+			default:
+				httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			if !role.Allows(min) {
+				httpErrorJSON(w, http.StatusForbidden, "project "+string(min)+" role required")
+				return
+			}
+			ctx := context.WithValue(r.Context(), projectContextKey{}, projectAccess{ID: id, Role: role})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// FromContext returns the project ID and caller role checked by RequireRole.
+func FromContext(ctx context.Context) (id string, role Role, ok bool) {
+	a, ok := ctx.Value(projectContextKey{}).(projectAccess)
+	return a.ID, a.Role, ok
+}
+
+type nameRequest struct {
+	Name string `json:"name"`
+}
+
+type memberRequest struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+}
+
+func (h *Handler) list(w http.ResponseWriter, r *http.Request) {
+	user, _ := coreauth.UserFromContext(r.Context())
+	out, err := h.Store.ListForUser(r.Context(), user.ID)
+	if err != nil {
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) create(w http.ResponseWriter, r *http.Request) {
+	user, _ := coreauth.UserFromContext(r.Context())
+	var req nameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	p, err := h.Store.Create(r.Context(), req.Name, user, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.record(r, p.ID, coreaudit.ActionProjectCreate, p.Name, "")
+	writeJSON(w, http.StatusCreated, p)
+}
+
+func (h *Handler) get(w http.ResponseWriter, r *http.Request) {
+	id, role, _ := FromContext(r.Context())
+	p, err := h.Store.Get(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	p.Role = role
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) rename(w http.ResponseWriter, r *http.Request) {
+	id, role, _ := FromContext(r.Context())
+	var req nameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	p, err := h.Store.Rename(r.Context(), id, req.Name, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.record(r, id, coreaudit.ActionProjectRename, p.Name, "")
+	p.Role = role
+	writeJSON(w, http.StatusOK, p)
+}
+
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := FromContext(r.Context())
+	if err := h.Store.Delete(r.Context(), id); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.record(r, id, coreaudit.ActionProjectDelete, "", "")
+	if h.Deleted != nil {
+		// The project is already gone; leftover files are only logged.
+		if err := h.Deleted(r.Context(), id); err != nil {
+			log.Printf("projects: clean up deleted project %s: %v", id, err)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *Handler) members(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := FromContext(r.Context())
+	out, err := h.Store.Members(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) setMember(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := FromContext(r.Context())
+	var req memberRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, "invalid json body")
+		return
+	}
+	if strings.TrimSpace(req.Email) == "" {
+		httpErrorJSON(w, http.StatusBadRequest, "email is required")
+		return
+	}
+	if strings.TrimSpace(req.Role) == "" {
+		req.Role = string(RoleMember)
+	}
+	role, err := ParseRole(req.Role)
+	if err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	m, err := h.Store.SetMember(r.Context(), id, req.Email, role, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.record(r, id, coreaudit.ActionMemberSet, m.Email, string(m.Role))
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (h *Handler) removeMember(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := FromContext(r.Context())
+	userID := chi.URLParam(r, "userID")
+	if err := h.Store.RemoveMember(r.Context(), id, userID); err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	h.record(r, id, coreaudit.ActionMemberRemove, userID, "")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// record adds a project event by the authenticated caller to the audit log.
+func (h *Handler) record(r *http.Request, projectID, action, target, detail string) {
+	user, _ := coreauth.UserFromContext(r.Context())
+	e := coreaudit.FromRequest(r, user, action)
+	e.ProjectID = projectID
+	e.Target = target
+	e.Detail = detail
+	h.Audit.TryRecord(r.Context(), e)
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrInvalidName), errors.Is(err, ErrInvalidRole):
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, ErrProjectNotFound), errors.Is(err, ErrNotMember), errors.Is(err, ErrUserNotFound):
+		httpErrorJSON(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrNameTaken), errors.Is(err, ErrLastAdmin):
+		httpErrorJSON(w, http.StatusConflict, err.Error())
+	default:
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func httpErrorJSON(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"error": strings.TrimSpace(msg)})
+}
@@ -0,0 +1,407 @@
+// Package projects manages server projects and their members. Members are
+// either admins, who manage the project and its membership, or members, who
+// use it.
+package projects
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+)
+
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleMember Role = "member"
+)
+
+var (
+	ErrProjectNotFound = errors.New("project not found")
+	ErrNameTaken       = errors.New("project name is already taken")
+	ErrInvalidName     = errors.New("project name must be 1-64 characters: lowercase letters, digits, '.', '_' or '-'")
+	ErrInvalidRole     = errors.New("role must be admin or member")
+	ErrNotMember       = errors.New("not a member of this project")
+	ErrUserNotFound    = errors.New("no user with that email has logged in to this server")
+	ErrLastAdmin       = errors.New("a project must keep at least one admin")
+)
+
+var namePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,63}$`)
+
+func ValidateName(name string) error {
+	if !namePattern.MatchString(name) {
+		return ErrInvalidName
+	}
+	return nil
+}
+
+func ParseRole(s string) (Role, error) {
+	switch Role(strings.ToLower(strings.TrimSpace(s))) {
+	case RoleAdmin:
+		return RoleAdmin, nil
+	case RoleMember:
+		return RoleMember, nil
+	}
+	return "", ErrInvalidRole
+}
+
+// Allows reports whether r grants at least the rights of min.
+func (r Role) Allows(min Role) bool {
+	return r == RoleAdmin || r == min
+}
+
+type Project struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	CreatedBy string    `json:"created_by"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Role is the caller's role when listed for a user.
+	Role Role `json:"role,omitempty"`
+}
+
+type Member struct {
+	UserID      string    `json:"user_id"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	Role        Role      `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Store keeps projects in the server database (see core/db).
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// Create adds a project with creator as its only admin.
+func (s *Store) Create(ctx context.Context, name string, creator coreauth.User, now time.Time) (Project, error) {
+	name = strings.TrimSpace(name)
+	if err := ValidateName(name); err != nil {
+		return Project{}, err
+	}
+	raw := make([]byte, 8)
+	if _, err := rand.Read(raw); err != nil {
+		return Project{}, err
+	}
+	p := Project{
+		ID:        "prj_" + hex.EncodeToString(raw),
+		Name:      name,
+		CreatedBy: creator.ID,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Role:      RoleAdmin,
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Project{}, err
+	}
+	defer tx.Rollback()
+	if err := nameFree(ctx, tx, name, ""); err != nil {
+		return Project{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO projects (id, name, created_by, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		p.ID, p.Name, p.CreatedBy, millis(now), millis(now),
+	); err != nil {
+		return Project{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO project_members (project_id, user_id, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?)`,
+		p.ID, creator.ID, RoleAdmin, millis(now), millis(now),
+	); err != nil {
+		return Project{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Project{}, err
+	}
+	return p, nil
+}
+
+// ListForUser returns the projects userID belongs to, with their role.
+func (s *Store) ListForUser(ctx context.Context, userID string) ([]Project, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT p.id, p.name, p.created_by, p.created_at, p.updated_at, m.role
+		FROM projects p JOIN project_members m ON m.project_id = p.id
+		WHERE m.user_id = ? ORDER BY p.name`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Project{}
+	for rows.Next() {
+		var (
+			p                    Project
+			createdAt, updatedAt int64
+		)
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedBy, &createdAt, &updatedAt, &p.Role); err != nil {
+			return nil, err
+		}
+		p.CreatedAt = fromMillis(createdAt)
+		p.UpdatedAt = fromMillis(updatedAt)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func (s *Store) Get(ctx context.Context, id string) (Project, error) {
+	var (
+		p                    Project
+		createdAt, updatedAt int64
+	)
+	err := s.db.QueryRowContext(ctx,
+		`SELECT id, name, created_by, created_at, updated_at FROM projects WHERE id = ?`, id,
+	).Scan(&p.ID, &p.Name, &p.CreatedBy, &createdAt, &updatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Project{}, ErrProjectNotFound
+	}
+	if err != nil {
+		return Project{}, err
+	}
+	p.CreatedAt = fromMillis(createdAt)
+	p.UpdatedAt = fromMillis(updatedAt)
+	return p, nil
+}
+
+// Role returns userID's role in project id, ErrProjectNotFound if the
+// project does not exist, or ErrNotMember.
+func (s *Store) Role(ctx context.Context, id, userID string) (Role, error) {
+	var role Role
+	err := s.db.QueryRowContext(ctx,
+		`SELECT role FROM project_members WHERE project_id = ? AND user_id = ?`, id, userID,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		if _, err := s.Get(ctx, id); err != nil {
+			return "", err
+		}
+		return "", ErrNotMember
+	}
+	if err != nil {
+		return "", err
+	}
+	return role, nil
+}
+
+func (s *Store) Rename(ctx context.Context, id, name string, now time.Time) (Project, error) {
+	name = strings.TrimSpace(name)
+	if err := ValidateName(name); err != nil {
+		return Project{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Project{}, err
+	}
+	defer tx.Rollback()
+	if err := nameFree(ctx, tx, name, id); err != nil {
+		return Project{}, err
+	}
+	res, err := tx.ExecContext(ctx, `UPDATE projects SET name = ?, updated_at = ? WHERE id = ?`, name, millis(now), id)
+	if err != nil {
+		return Project{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Project{}, err
+	} else if n == 0 {
+		return Project{}, ErrProjectNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return Project{}, err
+	}
+	return s.Get(ctx, id)
+}
+
+func (s *Store) Delete(ctx context.Context, id string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM projects WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrProjectNotFound
+	}
+	return nil
+}
+
+func (s *Store) Members(ctx context.Context, id string) ([]Member, error) {
+	rows, err := s.db.QueryContext(ctx,
+		`SELECT u.id, u.email, u.display_name, m.role, m.created_at
+		FROM project_members m JOIN users u ON u.id = m.user_id
+		WHERE m.project_id = ? ORDER BY u.email`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Member{}
+	for rows.Next() {
+		var (
+			m         Member
+			createdAt int64
+		)
+		if err := rows.Scan(&m.UserID, &m.Email, &m.DisplayName, &m.Role, &createdAt); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = fromMillis(createdAt)
+		out = append(out, m)
+	}
+	return out, rows.Err()
+}
+
+// SetMember adds the user with email to the project or changes their role.
+// The user must have logged in to the server at least once.
+func (s *Store) SetMember(ctx context.Context, id, email string, role Role, now time.Time) (Member, error) {
+	if _, err := ParseRole(string(role)); err != nil {
+		return Member{}, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Member{}, err
+	}
+	defer tx.Rollback()
+
+	var m Member
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, email, display_name FROM users WHERE lower(email) = lower(?) ORDER BY updated_at DESC LIMIT 1`,
+		strings.TrimSpace(email),
+	).Scan(&m.UserID, &m.Email, &m.DisplayName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Member{}, ErrUserNotFound
+	}
+	if err != nil {
+		return Member{}, err
+	}
+	if err := projectExists(ctx, tx, id); err != nil {
+		return Member{}, err
+	}
+	if role != RoleAdmin {
+		if err := keepsAdmin(ctx, tx, id, m.UserID); err != nil {
+			return Member{}, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO project_members (project_id, user_id, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (project_id, user_id) DO UPDATE SET role = excluded.role, updated_at = excluded.updated_at`,
+		id, m.UserID, role, millis(now), millis(now),
+	); err != nil {
+		return Member{}, err
+	}
+	var createdAt int64
+	if err := tx.QueryRowContext(ctx,
+		`SELECT created_at FROM project_members WHERE project_id = ? AND user_id = ?`, id, m.UserID,
+	).Scan(&createdAt); err != nil {
+		return Member{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return Member{}, err
+	}
+	m.Role = role
+	m.CreatedAt = fromMillis(createdAt)
+	return m, nil
+}
+
+// EnsureMember adds userID to the project with role inside tx, leaving an
+// existing member's role unchanged. It lets other features grant access as
+// part of their own transaction (e.g. approving an enrollment request).
+func EnsureMember(ctx context.Context, tx *sql.Tx, id, userID string, role Role, now time.Time) error {
+	if _, err := ParseRole(string(role)); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx,
+		`INSERT INTO project_members (project_id, user_id, role, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (project_id, user_id) DO NOTHING`,
+		id, userID, role, millis(now), millis(now),
+	)
+	return err
+}
+
+func (s *Store) RemoveMember(ctx context.Context, id, userID string) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := keepsAdmin(ctx, tx, id, userID); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM project_members WHERE project_id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrNotMember
+	}
+	return tx.Commit()
+}
+
+// keepsAdmin fails with ErrLastAdmin if userID is the project's only admin,
+// i.e. if demoting or removing them would leave it without one.
+func keepsAdmin(ctx context.Context, tx *sql.Tx, id, userID string) error {
+	var others int
+	err := tx.QueryRowContext(ctx,
+		`SELECT count(*) FROM project_members WHERE project_id = ? AND role = ? AND user_id != ?`,
+		id, RoleAdmin, userID,
+	).Scan(&others)
+	if err != nil {
+		return err
+	}
+	if others > 0 {
+		return nil
+	}
+	var role Role
+	err = tx.QueryRowContext(ctx,
+		`SELECT role FROM project_members WHERE project_id = ? AND user_id = ?`, id, userID,
+	).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if role == RoleAdmin {
+		return ErrLastAdmin
+	}
+	return nil
+}
+
+func projectExists(ctx context.Context, tx *sql.Tx, id string) error {
+	var one int
+	err := tx.QueryRowContext(ctx, `SELECT 1 FROM projects WHERE id = ?`, id).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrProjectNotFound
+	}
+	return err
+}
+
+// nameFree checks name is unused by any project other than exceptID.
+func nameFree(ctx context.Context, tx *sql.Tx, name, exceptID string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM projects WHERE name = ?`, name).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && id == exceptID) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("check project name: %w", err)
+	}
+	return ErrNameTaken
+}
+
+func millis(t time.Time) int64 {
+	return t.UTC().UnixMilli()
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.UnixMilli(ms).UTC()
+}
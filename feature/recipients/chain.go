@@ -0,0 +1,221 @@
+package recipients
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jasonchiu/envlock/core/keys"
+)
+
+// A Chain is the append-only signature log stored next to recipients.json.
+// Each link signs the active recipient set (ActiveSetHash) and names the
+// admin keys allowed to sign the next link. The first link must be signed by
+// the trust root pinned in project.toml; the trust root may always sign.
+type Chain struct {
+	Version int    `json:"version"`
+	Links   []Link `json:"links"`
+
+	// Revision is the backend's opaque version token, as for Store.
+	Revision string `json:"-"`
+}
+
+type Link struct {
+	Seq       int       `json:"seq"`
+	Prev      string    `json:"prev,omitempty"`
+	SetHash   string    `json:"set_hash"`
+	Admins    []string  `json:"admins"`
+	SignedBy  string    `json:"signed_by"`
+	SignedAt  time.Time `json:"signed_at"`
+	Note      string    `json:"note,omitempty"`
+	Signature string    `json:"signature"`
+}
+
+var (
+	ErrUnsigned        = errors.New("recipient set is not signed")
+	ErrBadChain        = errors.New("recipient signature chain is invalid")
+	ErrSetMismatch     = errors.New("recipient set does not match its latest signature")
+	ErrSignerNotAdmin  = errors.New("signing key is not a recipients admin")
+	ErrInvalidAdminKey = errors.New("invalid admin public key")
+	ErrRolledBack      = errors.New("recipient signature chain is older than the one last verified on this device")
+)
+
+// A Checkpoint names the newest link a device has verified. A chain that
+// does not extend it was rolled back, even if every signature in it checks
+// out.
+type Checkpoint struct {
+	Seq  int    `json:"seq"`
+	Hash string `json:"hash"`
+}
+
+// payload is the exact byte string a link's signature covers.
+func (l Link) payload() []byte {
+	return []byte(strings.Join([]string{
+		"envlock-recipients-v1",
+		strconv.Itoa(l.Seq),
+		l.Prev,
+		l.SetHash,
+		strings.Join(l.Admins, ","),
+		l.SignedBy,
+		l.SignedAt.UTC().Format(time.RFC3339Nano),
+		l.Note,
+	}, "\n"))
+}
+
+// Hash identifies a signed link; the next link's Prev refers to it.
+func (l Link) Hash() string {
+	sum := sha256.Sum256(append(l.payload(), l.Signature...))
+	return hex.EncodeToString(sum[:])
+}
+
+// Checkpoint records l as verified.
+func (l Link) Checkpoint() Checkpoint {
+	return Checkpoint{Seq: l.Seq, Hash: l.Hash()}
+}
+
+// Head returns the latest link, or false for an unsigned chain.
+func (c Chain) Head() (Link, bool) {
+	if len(c.Links) == 0 {
+		return Link{}, false
+	}
+	return c.Links[len(c.Links)-1], true
+}
+
+// VerifyLinks checks every signature and back-reference against root and
+// returns the head link. It does not look at the recipient set.
+func (c Chain) VerifyLinks(root string) (Link, error) {
+	if _, err := keys.ParseAdminPublicKey(root); err != nil {
+		return Link{}, fmt.Errorf("trust root: %w", err)
+	}
+	if len(c.Links) == 0 {
+		return Link{}, ErrUnsigned
+	}
+	allowed := []string{root}
+	prev := ""
+	for i, l := range c.Links {
+		if l.Seq != i || l.Prev != prev {
+			return Link{}, fmt.Errorf("%w: link %d is out of sequence", ErrBadChain, i)
+		}
+		if !containsKey(allowed, l.SignedBy) {
+			return Link{}, fmt.Errorf("%w: link %d is signed by %s, which is not an admin", ErrBadChain, i, l.SignedBy)
+		}
+		pub, err := keys.ParseAdminPublicKey(l.SignedBy)
+		if err != nil {
+			return Link{}, fmt.Errorf("%w: link %d: %v", ErrBadChain, i, err)
+		}
+		sig, err := base64.RawURLEncoding.DecodeString(l.Signature)
+		if err != nil || !ed25519.Verify(pub, l.payload(), sig) {
+			return Link{}, fmt.Errorf("%w: link %d has a bad signature", ErrBadChain, i)
+		}
+		allowed = append([]string{root}, l.Admins...)
+		prev = l.Hash()
+	}
+	head, _ := c.Head()
+	return head, nil
+}
+
+// Verify checks the chain against root and that its head signs s's active
+// recipient set.
+func (c Chain) Verify(root string, s Store) (Link, error) {
+	head, err := c.VerifyLinks(root)
+	if err != nil {
+		return Link{}, err
+	}
+	if head.SetHash != s.ActiveSetHash() {
+		return Link{}, fmt.Errorf("%w (last signed by %s at %s)", ErrSetMismatch, head.SignedBy, head.SignedAt.UTC().Format(time.RFC3339))
+	}
+	return head, nil
+}
+
+// Extends returns ErrRolledBack unless c still contains the link recorded in
+// cp. The zero Checkpoint is extended by every chain.
+func (c Chain) Extends(cp Checkpoint) error {
+	if cp.Hash == "" {
+		return nil
+	}
+	if cp.Seq >= len(c.Links) {
+		return fmt.Errorf("%w: chain has %d links, link %d was verified before", ErrRolledBack, len(c.Links), cp.Seq)
+	}
+	if c.Links[cp.Seq].Hash() != cp.Hash {
+		return fmt.Errorf("%w: link %d differs from the one verified before", ErrRolledBack, cp.Seq)
+	}
+	return nil
+}
+
+// CanSign reports whether the admin key pub may append the next link.
+func (c Chain) CanSign(root, pub string) bool {
+	if pub == root {
+		return true
+	}
+	head, ok := c.Head()
+	return ok && containsKey(head.Admins, pub)
+}
+
+// Append signs setHash with priv as the next link. admins replaces the admin
+// list; nil keeps the current one. Callers verify the chain first.
+func (c *Chain) Append(root string, priv ed25519.PrivateKey, setHash string, admins []string, note string, now time.Time) (Link, error) {
+	signer := keys.AdminPublicKey(priv)
+	if !c.CanSign(root, signer) {
+		return Link{}, fmt.Errorf("%w: %s", ErrSignerNotAdmin, signer)
+	}
+	head, ok := c.Head()
+	if admins == nil {
+		if ok {
+			admins = head.Admins
+		} else {
+			admins = []string{root}
+		}
+	}
+	normalized, err := normalizeAdmins(admins)
+	if err != nil {
+		return Link{}, err
+	}
+	l := Link{
+		Seq:      len(c.Links),
+		SetHash:  setHash,
+		Admins:   normalized,
+		SignedBy: signer,
+		SignedAt: now.UTC(),
+		Note:     strings.TrimSpace(note),
+	}
+	if ok {
+		l.Prev = head.Hash()
+	}
+	l.Signature = base64.RawURLEncoding.EncodeToString(ed25519.Sign(priv, l.payload()))
+	if c.Version == 0 {
+		c.Version = 1
+	}
+	c.Links = append(c.Links, l)
+	return l, nil
+}
+
+func normalizeAdmins(admins []string) ([]string, error) {
+	out := make([]string, 0, len(admins))
+	for _, a := range admins {
+		a = strings.TrimSpace(a)
+		if _, err := keys.ParseAdminPublicKey(a); err != nil {
+			return nil, fmt.Errorf("%w %q: %v", ErrInvalidAdminKey, a, err)
+		}
+		if !containsKey(out, a) {
+			out = append(out, a)
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func containsKey(list []string, key string) bool {
+	for _, k := range list {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
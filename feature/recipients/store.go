@@ -1,11 +1,14 @@
 package recipients
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 )
@@ -33,6 +36,11 @@ type Recipient struct {
 type Store struct {
 	Version    int         `json:"version"`
 	Recipients []Recipient `json:"recipients"`
+
+	// Revision is the backend's opaque version token from the last load and is
+	// used for conditional writes. It is empty for stores that were not loaded
+	// from a backend.
+	Revision string `json:"-"`
 }
 
 func Load(path string) (Store, error) {
@@ -86,6 +94,52 @@ func (s *Store) ActiveCount() int {
 	return count
 }
 
+// ActivePublicKeys returns the public keys new ciphertext should be encrypted to.
+func (s *Store) ActivePublicKeys() []string {
+	out := make([]string, 0, len(s.Recipients))
+	for _, r := range s.Recipients {
+		if r.Status == StatusActive {
+			out = append(out, r.PublicKey)
+		}
+	}
+	return out
+}
+
+// ActiveFingerprints returns the sorted fingerprints of active recipients.
+func (s *Store) ActiveFingerprints() []string {
+	out := make([]string, 0, len(s.Recipients))
+	for _, r := range s.Recipients {
+		if r.Status == StatusActive {
+			out = append(out, r.Fingerprint)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// NameForFingerprint returns the recipient name for fp, including revoked
+// recipients, or fp itself when it is unknown.
+func (s *Store) NameForFingerprint(fp string) string {
+	for _, r := range s.Recipients {
+		if r.Fingerprint == fp {
+			return r.Name
+		}
+	}
+	return fp
+}
+
+// ActiveSetHash identifies the current active recipient set independent of
+// ordering, so callers can tell whether a blob needs rekeying.
+func (s *Store) ActiveSetHash() string {
+	pubs := s.ActivePublicKeys()
+	for i := range pubs {
+		pubs[i] = strings.TrimSpace(pubs[i])
+	}
+	sort.Strings(pubs)
+	sum := sha256.Sum256([]byte(strings.Join(pubs, "\n")))
+	return hex.EncodeToString(sum[:])
+}
+
 func (s *Store) Add(r Recipient) error {
 	r.Name = strings.TrimSpace(r.Name)
 	r.PublicKey = strings.TrimSpace(r.PublicKey)
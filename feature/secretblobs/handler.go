@@ -0,0 +1,238 @@
+package secretblobs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	"github.com/jasonchiu/envlock/core/serverapi"
+	"github.com/jasonchiu/envlock/feature/projects"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+// Handler serves the secrets blob API to project members (see
+// core/backend/server for the client side).
+//
+//	GET /api/projects/{projectID}/secrets                   secrets.Manifest
+//	GET /api/projects/{projectID}/secrets/{name}?version=N  ciphertext (latest without ?version)
+//	PUT /api/projects/{projectID}/secrets/{name}            ciphertext + Envlock-* headers -> secrets.Version
+//	GET /api/projects/{projectID}/secrets/{name}/history    secrets.History
+//
+// Uploads must carry Envlock-Base-Version (the version they replace, 0 for a
+// new secret) and Envlock-Sha256, and may carry Envlock-Size; a stale base
+// version is rejected with 409.
+//
+// Every upload and every ciphertext download is written to the audit log; a
+// download is refused if it cannot be recorded.
+type Handler struct {
+	Auth     coreauth.Store
+	Projects *projects.Store
+	Store    *Store
+	Audit    *coreaudit.Log
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Group(func(r chi.Router) {
+		r.Use(coreauth.RequireBearer(h.Auth))
+		r.Use(projects.RequireRole(h.Projects, projects.RoleMember))
+		r.Get("/api/projects/{projectID}/secrets", h.manifest)
+		r.Get("/api/projects/{projectID}/secrets/{name}", h.download)
+		r.Put("/api/projects/{projectID}/secrets/{name}", h.upload)
+		r.Get("/api/projects/{projectID}/secrets/{name}/history", h.history)
+	})
+}
+
+func (h *Handler) manifest(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	m, err := h.Store.Manifest(r.Context(), id)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", m.Revision)
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (h *Handler) history(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	name, ok := secretName(w, r)
+	if !ok {
+		return
+	}
+	hist, err := h.Store.History(r.Context(), id, name)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	w.Header().Set("ETag", hist.Revision)
+	writeJSON(w, http.StatusOK, hist)
+}
+
+func (h *Handler) download(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	name, ok := secretName(w, r)
+	if !ok {
+		return
+	}
+	version := 0
+	if q := r.URL.Query().Get("version"); q != "" {
+		n, err := strconv.Atoi(q)
+		if err != nil || n <= 0 {
+			httpErrorJSON(w, http.StatusBadRequest, "version must be a positive integer")
+			return
+		}
+		version = n
+	}
+	v, err := h.Store.Version(r.Context(), id, name, version)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	data, err := h.Store.Open(id, v)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	user, _ := coreauth.UserFromContext(r.Context())
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionSecretPull)
+	e.ProjectID = id
+	e.Target = name
+	e.Detail = "v" + strconv.Itoa(v.Version)
+	if err := h.Audit.Record(r.Context(), e); err != nil {
+		httpErrorJSON(w, http.StatusInternalServerError, "record audit event: "+err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.Header().Set("ETag", `"`+v.SHA256+`"`)
+	w.Header().Set(serverapi.HeaderVersion, strconv.Itoa(v.Version))
+	w.Header().Set(serverapi.HeaderSHA256, v.SHA256)
+	if v.RecipientSetHash != "" {
+		w.Header().Set(serverapi.HeaderRecipientSet, v.RecipientSetHash)
+	}
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+func (h *Handler) upload(w http.ResponseWriter, r *http.Request) {
+	id, _, _ := projects.FromContext(r.Context())
+	user, _ := coreauth.UserFromContext(r.Context())
+	name, ok := secretName(w, r)
+	if !ok {
+		return
+	}
+	base, err := strconv.Atoi(strings.TrimSpace(r.Header.Get(serverapi.HeaderBaseVersion)))
+	if err != nil || base < 0 {
+		httpErrorJSON(w, http.StatusBadRequest, serverapi.HeaderBaseVersion+" header is required (0 for a new secret)")
+		return
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBlobSize))
+	if err != nil {
+		httpErrorJSON(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("ciphertext exceeds %d bytes", MaxBlobSize))
+		return
+	}
+	if len(data) == 0 {
+		httpErrorJSON(w, http.StatusBadRequest, "ciphertext body is required")
+		return
+	}
+	if want := strings.ToLower(strings.TrimSpace(r.Header.Get(serverapi.HeaderSHA256))); want != secrets.Digest(data) {
+		httpErrorJSON(w, http.StatusBadRequest, serverapi.HeaderSHA256+" does not match the uploaded ciphertext")
+		return
+	}
+	if size := strings.TrimSpace(r.Header.Get(serverapi.HeaderSize)); size != "" && size != strconv.Itoa(len(data)) {
+		httpErrorJSON(w, http.StatusBadRequest, serverapi.HeaderSize+" does not match the uploaded ciphertext")
+		return
+	}
+	blob := secrets.Blob{
+		Name:             name,
+		Ciphertext:       data,
+		Version:          base,
+		RecipientSetHash: strings.TrimSpace(r.Header.Get(serverapi.HeaderRecipientSet)),
+		CreatedBy:        strings.TrimSpace(r.Header.Get(serverapi.HeaderCreatedBy)),
+		Source:           strings.TrimSpace(r.Header.Get(serverapi.HeaderSource)),
+	}
+	for _, fp := range strings.Split(r.Header.Get(serverapi.HeaderRecipients), ",") {
+		if fp = strings.TrimSpace(fp); fp != "" {
+			blob.RecipientFingerprints = append(blob.RecipientFingerprints, fp)
+		}
+	}
+	if s := strings.TrimSpace(r.Header.Get(serverapi.HeaderRestoredFrom)); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			httpErrorJSON(w, http.StatusBadRequest, serverapi.HeaderRestoredFrom+" must be a positive integer")
+			return
+		}
+		blob.RestoredFrom = n
+	}
+	v, err := h.Store.Put(r.Context(), id, user.ID, blob, time.Now().UTC())
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	e := coreaudit.FromRequest(r, user, pushAction(v.Source))
+	e.ProjectID = id
+	e.Target = name
+	e.Detail = "v" + strconv.Itoa(v.Version)
+	if v.RestoredFrom != 0 {
+		e.Detail += " from v" + strconv.Itoa(v.RestoredFrom)
+	}
+	h.Audit.TryRecord(r.Context(), e)
+	writeJSON(w, http.StatusCreated, v)
+}
+
+func pushAction(source string) string {
+	switch source {
+	case secrets.SourceRekey:
+		return coreaudit.ActionSecretRekey
+	case secrets.SourceRollback:
+		return coreaudit.ActionSecretRollback
+	}
+	return coreaudit.ActionSecretPush
+}
+
+// secretName returns the unescaped {name} route parameter, writing a 400 if
+// it is not a valid secret name.
+func secretName(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err == nil {
+		err = secrets.ValidateName(name)
+	}
+	if err != nil {
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	return name, true
+}
+
+func writeStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, secrets.ErrInvalidName):
+		httpErrorJSON(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, secrets.ErrSecretNotFound), errors.Is(err, secrets.ErrVersionNotFound):
+		httpErrorJSON(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, secrets.ErrStaleVersion):
+		httpErrorJSON(w, http.StatusConflict, err.Error())
+	default:
+		httpErrorJSON(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func httpErrorJSON(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"error": strings.TrimSpace(msg)})
+}
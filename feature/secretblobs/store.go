@@ -0,0 +1,235 @@
+// Package secretblobs stores pushed secret ciphertext for server projects.
+// The server never decrypts: it keeps the age ciphertext as opaque,
+// content-addressed files on local disk and the version metadata (digest,
+// size, recipient set) in the server database.
+package secretblobs
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	coredb "github.com/jasonchiu/envlock/core/db"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+// MaxBlobSize bounds a single uploaded ciphertext.
+const MaxBlobSize = 4 << 20
+
+// Store keeps version rows in the server database (see core/db) and
+// ciphertext under dir/<project id>/<sha256>.
+type Store struct {
+	db  *sql.DB
+	dir string
+}
+
+func NewStore(db *sql.DB, dir string) *Store {
+	return &Store{db: db, dir: dir}
+}
+
+// History returns every version of name, oldest first, or
+// secrets.ErrSecretNotFound if nothing was pushed yet.
+func (s *Store) History(ctx context.Context, projectID, name string) (secrets.History, error) {
+	rows, err := s.db.QueryContext(ctx, selectVersions+`
+		WHERE project_id = ? AND name = ? ORDER BY version`, projectID, name)
+	if err != nil {
+		return secrets.History{}, err
+	}
+	defer rows.Close()
+	h := secrets.NewHistory(name)
+	for rows.Next() {
+		_, v, err := scanVersion(rows)
+		if err != nil {
+			return secrets.History{}, err
+		}
+		h.Versions = append(h.Versions, v)
+	}
+	if err := rows.Err(); err != nil {
+		return secrets.History{}, err
+	}
+	if len(h.Versions) == 0 {
+		return secrets.History{}, secrets.ErrSecretNotFound
+	}
+	h.Revision = fmt.Sprintf(`"v%d"`, h.LatestVersion())
+	return h, nil
+}
+
+// Manifest summarizes the latest version of every secret in the project.
+func (s *Store) Manifest(ctx context.Context, projectID string) (secrets.Manifest, error) {
+	rows, err := s.db.QueryContext(ctx, selectVersions+` v
+		WHERE project_id = ? AND version = (
+			SELECT max(version) FROM secret_versions
+			WHERE project_id = v.project_id AND name = v.name
+		) ORDER BY name`, projectID)
+	if err != nil {
+		return secrets.Manifest{}, err
+	}
+	defer rows.Close()
+	m := secrets.NewManifest()
+	for rows.Next() {
+		name, v, err := scanVersion(rows)
+		if err != nil {
+			return secrets.Manifest{}, err
+		}
+		m.Record(name, v)
+	}
+	if err := rows.Err(); err != nil {
+		return secrets.Manifest{}, err
+	}
+	var b strings.Builder
+	for _, e := range m.Secrets {
+		fmt.Fprintf(&b, "%s\x00%d\x00", e.Name, e.LatestVersion)
+	}
+	m.Revision = `"` + secrets.Digest([]byte(b.String()))[:16] + `"`
+	return m, nil
+}
+
+// Version returns the metadata of one version (the latest when version is 0).
+func (s *Store) Version(ctx context.Context, projectID, name string, version int) (secrets.Version, error) {
+	h, err := s.History(ctx, projectID, name)
+	if err != nil {
+		return secrets.Version{}, err
+	}
+	if version == 0 {
+		v, _ := h.Latest()
+		return v, nil
+	}
+	return h.Get(version)
+}
+
+// Open returns the ciphertext of v.
+func (s *Store) Open(projectID string, v secrets.Version) ([]byte, error) {
+	data, err := os.ReadFile(s.blobPath(projectID, v.SHA256))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: v%d ciphertext missing", secrets.ErrVersionNotFound, v.Version)
+	}
+	return data, err
+}
+
+// Put stores blob as the version after blob.Version, uploaded by userID. It
+// returns secrets.ErrStaleVersion if blob.Version is not the latest version.
+func (s *Store) Put(ctx context.Context, projectID, userID string, blob secrets.Blob, now time.Time) (secrets.Version, error) {
+	if err := secrets.ValidateName(blob.Name); err != nil {
+		return secrets.Version{}, err
+	}
+	// The ciphertext is written first and addressed by its digest, so a
+	// version row never points at a missing or partial file. A lost race
+	// leaves at most an unreferenced file behind.
+	digest := secrets.Digest(blob.Ciphertext)
+	if err := writeFileAtomic(s.blobPath(projectID, digest), blob.Ciphertext); err != nil {
+		return secrets.Version{}, err
+	}
+
+	v, err := s.insertVersion(ctx, projectID, userID, blob, now)
+	if coredb.IsWriteConflict(err) {
+		// Another push from the same base version committed first.
+		return secrets.Version{}, fmt.Errorf("%w: %s changed concurrently, write was based on v%d", secrets.ErrStaleVersion, blob.Name, blob.Version)
+	}
+	return v, err
+}
+
+// insertVersion records the version row for blob. Transactions begin
+// IMMEDIATE (see core/db.Open), so concurrent pushes read the latest version
+// one at a time; the primary key on (project_id, name, version) backs that up.
+func (s *Store) insertVersion(ctx context.Context, projectID, userID string, blob secrets.Blob, now time.Time) (secrets.Version, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return secrets.Version{}, err
+	}
+	defer tx.Rollback()
+	var latest int
+	if err := tx.QueryRowContext(ctx,
+		`SELECT COALESCE(max(version), 0) FROM secret_versions WHERE project_id = ? AND name = ?`,
+		projectID, blob.Name,
+	).Scan(&latest); err != nil {
+		return secrets.Version{}, err
+	}
+	h := secrets.History{Name: blob.Name}
+	if latest > 0 {
+		h.Versions = []secrets.Version{{Version: latest}}
+	}
+	v, err := h.Append(blob, now)
+	if err != nil {
+		return secrets.Version{}, err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO secret_versions (project_id, name, version, sha256, size_bytes, recipient_set_hash,
+			recipient_fingerprints, created_by, source, restored_from, uploaded_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		projectID, blob.Name, v.Version, v.SHA256, v.Size, v.RecipientSetHash,
+		strings.Join(v.RecipientFingerprints, ","), v.CreatedBy, v.Source, v.RestoredFrom,
+		userID, v.CreatedAt.UnixMilli(),
+	); err != nil {
+		return secrets.Version{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return secrets.Version{}, err
+	}
+	return v, nil
+}
+
+// DeleteProject removes the project's ciphertext files. Call it after the
+// project is deleted; its version rows go with the project.
+func (s *Store) DeleteProject(ctx context.Context, projectID string) error {
+	if strings.TrimSpace(projectID) == "" || strings.ContainsAny(projectID, `/\`) || projectID == "." || projectID == ".." {
+		return fmt.Errorf("invalid project id %q", projectID)
+	}
+	return os.RemoveAll(filepath.Join(s.dir, projectID))
+}
+
+const selectVersions = `SELECT name, version, sha256, size_bytes, recipient_set_hash, recipient_fingerprints,
+	created_by, source, restored_from, created_at FROM secret_versions`
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scanVersion(row scanner) (string, secrets.Version, error) {
+	var (
+		name         string
+		v            secrets.Version
+		fingerprints string
+		createdAt    int64
+	)
+	if err := row.Scan(&name, &v.Version, &v.SHA256, &v.Size, &v.RecipientSetHash, &fingerprints,
+		&v.CreatedBy, &v.Source, &v.RestoredFrom, &createdAt); err != nil {
+		return "", secrets.Version{}, err
+	}
+	if fingerprints != "" {
+		v.RecipientFingerprints = strings.Split(fingerprints, ",")
+	}
+	v.CreatedAt = time.UnixMilli(createdAt).UTC()
+	return name, v, nil
+}
+
+func (s *Store) blobPath(projectID, digest string) string {
+	return filepath.Join(s.dir, projectID, digest)
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,299 @@
+package secrets
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	BlobSuffix = ".envlock"
+
+	SourcePush     = "push"
+	SourceRekey    = "rekey"
+	SourceRollback = "rollback"
+)
+
+var (
+	ErrSecretNotFound  = errors.New("secret not found")
+	ErrVersionNotFound = errors.New("secret version not found")
+	ErrInvalidName     = errors.New("invalid secret name")
+	ErrDigestMismatch  = errors.New("secret ciphertext does not match recorded sha256")
+	ErrStaleVersion    = errors.New("stale secret version")
+)
+
+// Blob is an encrypted secret as stored remotely. Ciphertext is an age file
+// and is never decrypted by storage backends.
+//
+// When loaded, Version is the version of Ciphertext. When saved, Version is
+// the version being replaced (0 for a new secret); backends reject the write
+// with a conflict if a newer version exists. RecipientSetHash identifies the
+// recipient set the blob was encrypted to (see recipients.Store.ActiveSetHash)
+// and RecipientFingerprints lists its members.
+type Blob struct {
+	Name                  string
+	Ciphertext            []byte
+	Version               int
+	RecipientSetHash      string
+	RecipientFingerprints []string
+	CreatedBy             string
+	Source                string
+	RestoredFrom          int
+}
+
+// Version describes one immutable pushed ciphertext. SHA256 and Size cover
+// the ciphertext only, so recording them never reveals anything about the
+// plaintext.
+type Version struct {
+	Version               int       `json:"version"`
+	SHA256                string    `json:"sha256"`
+	Size                  int64     `json:"size"`
+	RecipientSetHash      string    `json:"recipient_set_hash,omitempty"`
+	RecipientFingerprints []string  `json:"recipient_fingerprints,omitempty"`
+	CreatedAt             time.Time `json:"created_at"`
+	CreatedBy             string    `json:"created_by,omitempty"`
+	Source                string    `json:"source,omitempty"`
+	RestoredFrom          int       `json:"restored_from,omitempty"`
+}
+
+// History is the per-secret version log. It is the authoritative record of
+// which ciphertext is current; versions are monotonic and never rewritten.
+type History struct {
+	Version  int       `json:"version"`
+	Name     string    `json:"name"`
+	Versions []Version `json:"versions"`
+
+	// Revision is the backend version token from the last load; empty for a
+	// history that does not exist yet.
+	Revision string `json:"-"`
+}
+
+func NewHistory(name string) History {
+	return History{Version: 1, Name: name, Versions: []Version{}}
+}
+
+// Latest returns the newest version, or false if nothing was pushed yet.
+func (h History) Latest() (Version, bool) {
+	if len(h.Versions) == 0 {
+		return Version{}, false
+	}
+	return h.Versions[len(h.Versions)-1], true
+}
+
+func (h History) LatestVersion() int {
+	v, _ := h.Latest()
+	return v.Version
+}
+
+func (h History) Get(version int) (Version, error) {
+	for _, v := range h.Versions {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("%w: %s v%d", ErrVersionNotFound, h.Name, version)
+}
+
+// Append records blob as the next version. It returns ErrStaleVersion when
+// blob.Version is not the current latest version, i.e. the caller is about to
+// overwrite a push it has not seen.
+func (h *History) Append(blob Blob, now time.Time) (Version, error) {
+	latest := h.LatestVersion()
+	if blob.Version != latest {
+		return Version{}, fmt.Errorf("%w: %s is at v%d, write was based on v%d", ErrStaleVersion, h.Name, latest, blob.Version)
+	}
+	v := Version{
+		Version:               latest + 1,
+		SHA256:                Digest(blob.Ciphertext),
+		Size:                  int64(len(blob.Ciphertext)),
+		RecipientSetHash:      blob.RecipientSetHash,
+		RecipientFingerprints: blob.RecipientFingerprints,
+		CreatedAt:             now.UTC(),
+		CreatedBy:             strings.TrimSpace(blob.CreatedBy),
+		Source:                blob.Source,
+		RestoredFrom:          blob.RestoredFrom,
+	}
+	if v.Source == "" {
+		v.Source = SourcePush
+	}
+	h.Versions = append(h.Versions, v)
+	return v, nil
+}
+
+// Digest is the hex sha256 used to address and verify ciphertext.
+func Digest(ciphertext []byte) string {
+	sum := sha256.Sum256(ciphertext)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyDigest checks ciphertext against a recorded version before it is
+// handed to the decrypter.
+func VerifyDigest(v Version, ciphertext []byte) error {
+	if v.SHA256 != "" && Digest(ciphertext) != v.SHA256 {
+		return fmt.Errorf("%w (v%d)", ErrDigestMismatch, v.Version)
+	}
+	return nil
+}
+
+// NameFromPath derives the default secret name from a local file path, so
+// `secrets push .env.production` is stored as `.env.production`.
+func NameFromPath(path string) string {
+	return filepath.Base(strings.TrimSpace(path))
+}
+
+func ValidateName(name string) error {
+	n := strings.TrimSpace(name)
+	if n == "" || n == "." || n == ".." {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	if n != name {
+		return fmt.Errorf("%w: %q has leading or trailing whitespace", ErrInvalidName, name)
+	}
+	if strings.ContainsAny(n, `/\`) {
+		return fmt.Errorf("%w: %q must not contain path separators", ErrInvalidName, name)
+	}
+	if strings.HasPrefix(n, "_envlock") {
+		return fmt.Errorf("%w: %q uses the reserved _envlock prefix", ErrInvalidName, name)
+	}
+	return nil
+}
+
+// ManifestEntry summarizes the latest version of one secret.
+type ManifestEntry struct {
+	Name                  string    `json:"name"`
+	LatestVersion         int       `json:"latest_version"`
+	SHA256                string    `json:"sha256"`
+	Size                  int64     `json:"size"`
+	PushedBy              string    `json:"pushed_by,omitempty"`
+	RecipientSetHash      string    `json:"recipient_set_hash,omitempty"`
+	RecipientFingerprints []string  `json:"recipient_fingerprints,omitempty"`
+	UpdatedAt             time.Time `json:"updated_at"`
+}
+
+// Manifest indexes every secret under a project prefix so listing and
+// staleness checks need a single read instead of a bucket scan. It is derived
+// from the per-secret histories, which remain authoritative.
+type Manifest struct {
+	Version int             `json:"version"`
+	Secrets []ManifestEntry `json:"secrets"`
+
+	// Revision is the backend version token from the last load; empty for a
+	// manifest that does not exist yet.
+	Revision string `json:"-"`
+}
+
+func NewManifest() Manifest {
+	return Manifest{Version: 1, Secrets: []ManifestEntry{}}
+}
+
+func (m Manifest) Get(name string) (ManifestEntry, bool) {
+	for _, e := range m.Secrets {
+		if e.Name == name {
+			return e, true
+		}
+	}
+	return ManifestEntry{}, false
+}
+
+// Record updates the entry for name to v. Entries never move backwards, so
+// manifest writers racing with out-of-order versions cannot regress it.
+// It reports whether the manifest changed.
+func (m *Manifest) Record(name string, v Version) bool {
+	entry := ManifestEntry{
+		Name:                  name,
+		LatestVersion:         v.Version,
+		SHA256:                v.SHA256,
+		Size:                  v.Size,
+		PushedBy:              v.CreatedBy,
+		RecipientSetHash:      v.RecipientSetHash,
+		RecipientFingerprints: v.RecipientFingerprints,
+		UpdatedAt:             v.CreatedAt,
+	}
+	for i, e := range m.Secrets {
+		if e.Name != name {
+			continue
+		}
+		if e.LatestVersion >= v.Version {
+			return false
+		}
+		m.Secrets[i] = entry
+		return true
+	}
+	m.Secrets = append(m.Secrets, entry)
+	sort.Slice(m.Secrets, func(i, j int) bool { return m.Secrets[i].Name < m.Secrets[j].Name })
+	return true
+}
+
+const (
+	StatusCurrent        = "current"
+	StatusRevokedCanRead = "needs rekey (revoked device still has access)"
+	StatusNewCannotRead  = "needs rekey (new device cannot decrypt)"
+	StatusSetChanged     = "needs rekey (recipient set changed)"
+)
+
+// Staleness compares what a secret was encrypted to against the active
+// recipient set. Extra lists fingerprints that can still decrypt but are no
+// longer active; Missing lists active fingerprints that cannot decrypt yet.
+type Staleness struct {
+	Extra   []string
+	Missing []string
+	// Unknown is set when the entry predates recorded fingerprints, so only
+	// the set hash can be compared.
+	Unknown bool
+}
+
+func (s Staleness) Current() bool {
+	return !s.Unknown && len(s.Extra) == 0 && len(s.Missing) == 0
+}
+
+// Statuses returns human-readable status lines, most severe first.
+func (s Staleness) Statuses() []string {
+	if s.Current() {
+		return []string{StatusCurrent}
+	}
+	if s.Unknown {
+		return []string{StatusSetChanged}
+	}
+	var out []string
+	if len(s.Extra) > 0 {
+		out = append(out, StatusRevokedCanRead)
+	}
+	if len(s.Missing) > 0 {
+		out = append(out, StatusNewCannotRead)
+	}
+	return out
+}
+
+// CheckStaleness compares e with the active recipient set, identified by its
+// set hash and member fingerprints.
+func CheckStaleness(e ManifestEntry, activeSetHash string, activeFingerprints []string) Staleness {
+	if e.RecipientSetHash != "" && e.RecipientSetHash == activeSetHash {
+		return Staleness{}
+	}
+	if len(e.RecipientFingerprints) == 0 {
+		return Staleness{Unknown: true}
+	}
+	active := map[string]bool{}
+	for _, fp := range activeFingerprints {
+		active[fp] = true
+	}
+	encrypted := map[string]bool{}
+	var st Staleness
+	for _, fp := range e.RecipientFingerprints {
+		encrypted[fp] = true
+		if !active[fp] {
+			st.Extra = append(st.Extra, fp)
+		}
+	}
+	for _, fp := range activeFingerprints {
+		if !encrypted[fp] {
+			st.Missing = append(st.Missing, fp)
+		}
+	}
+	return st
+}
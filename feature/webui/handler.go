@@ -0,0 +1,372 @@
+// Package webui is the server-rendered admin web UI: the user's projects,
+// pending enrollment requests with approve/reject, devices with revoke, and
+// secrets metadata. It reads and changes the same server state as the CLI
+// APIs and never handles plaintext secrets.
+package webui
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	coreaudit "github.com/jasonchiu/envlock/core/audit"
+	coreauth "github.com/jasonchiu/envlock/core/auth"
+	coreconfig "github.com/jasonchiu/envlock/core/config"
+	"github.com/jasonchiu/envlock/feature/cliauth"
+	"github.com/jasonchiu/envlock/feature/enroll"
+	"github.com/jasonchiu/envlock/feature/enrollment"
+	"github.com/jasonchiu/envlock/feature/projects"
+	"github.com/jasonchiu/envlock/feature/recipients"
+	"github.com/jasonchiu/envlock/feature/secretblobs"
+	"github.com/jasonchiu/envlock/feature/secrets"
+)
+
+//go:embed templates/*.html
+var templateFS embed.FS
+
+var pages = parsePages("projects", "project", "signed_out", "message")
+
+func parsePages(names ...string) map[string]*template.Template {
+	funcs := template.FuncMap{
+		"fmtTime": func(t time.Time) string {
+			if t.IsZero() {
+				return ""
+			}
+			return t.UTC().Format("2006-01-02 15:04 UTC")
+		},
+		"fmtSize": func(n int64) string {
+			if n < 1024 {
+				return fmt.Sprintf("%d B", n)
+			}
+			return fmt.Sprintf("%.1f KiB", float64(n)/1024)
+		},
+	}
+	out := map[string]*template.Template{}
+	for _, name := range names {
+		out[name] = template.Must(template.New(name).Funcs(funcs).ParseFS(templateFS, "templates/layout.html", "templates/"+name+".html"))
+	}
+	return out
+}
+
+// Notices shown after a form post redirects back to the project page.
+var notices = map[string]string{
+	"approved":          "Request approved and the device added as a recipient. An active device must run 'envlock secrets rekey --all' before it can decrypt existing secrets.",
+	"approved-existing": "Request approved; the device was already a recipient.",
+	"rejected":          "Request rejected.",
+	"revoked":           "Device revoked. It can still decrypt existing ciphertext until an active device runs 'envlock secrets rekey --all'.",
+}
+
+// Handler serves the web UI under /ui. Browsers sign in through
+// cliauth.WebLoginPath; every form carries the session's CSRF token.
+//
+//	GET  /ui                                                    projects of the signed-in user
+//	GET  /ui/projects/{projectID}                               requests (admins), devices, secrets
+//	POST /ui/projects/{projectID}/requests/{requestID}/approve  admin; token, code
+//	POST /ui/projects/{projectID}/requests/{requestID}/reject   admin; reason
+//	POST /ui/projects/{projectID}/devices/{fingerprint}/revoke  admin
+//	POST /ui/logout
+type Handler struct {
+	Config     coreconfig.Runtime
+	Sessions   *coreauth.WebSessions
+	Projects   *projects.Store
+	Enrollment *enrollment.Store
+	Secrets    *secretblobs.Store
+	Audit      *coreaudit.Log
+}
+
+func (h *Handler) RegisterRoutes(r chi.Router) {
+	r.Route("/ui", func(r chi.Router) {
+		r.Use(securityHeaders)
+		r.Use(coreauth.RequireWebSession(h.Sessions, cliauth.WebLoginPath))
+		r.Use(requireCSRF)
+		r.Get("/", h.projectList)
+		r.Get("/projects/{projectID}", h.project)
+		r.Post("/projects/{projectID}/requests/{requestID}/approve", h.approve)
+		r.Post("/projects/{projectID}/requests/{requestID}/reject", h.reject)
+		r.Post("/projects/{projectID}/devices/{fingerprint}/revoke", h.revoke)
+		r.Post("/logout", h.logout)
+	})
+}
+
+// page is the data every template sees.
+type page struct {
+	Title  string
+	User   *coreauth.User
+	CSRF   string
+	Notice string
+	Error  string
+}
+
+type projectListPage struct {
+	page
+	Projects []projects.Project
+}
+
+type projectPage struct {
+	page
+	Project projects.Project
+	Role    projects.Role
+	IsAdmin bool
+	Pending []enrollment.Request
+	Devices []recipients.Recipient
+	Secrets []secretRow
+}
+
+type messagePage struct {
+	page
+	Message string
+}
+
+// secretRow is a manifest entry with its rekey status against the current
+// recipients.
+type secretRow struct {
+	secrets.ManifestEntry
+	Status  string
+	Current bool
+}
+
+func (h *Handler) projectList(w http.ResponseWriter, r *http.Request) {
+	p := newPage(r, "Projects")
+	list, err := h.Projects.ListForUser(r.Context(), p.User.ID)
+	if err != nil {
+		h.message(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	render(w, http.StatusOK, "projects", projectListPage{page: p, Projects: list})
+}
+
+func (h *Handler) project(w http.ResponseWriter, r *http.Request) {
+	h.renderProject(w, r, http.StatusOK, notices[r.URL.Query().Get("notice")], "")
+}
+
+func (h *Handler) renderProject(w http.ResponseWriter, r *http.Request, status int, notice, errMsg string) {
+	proj, role, ok := h.member(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	data := projectPage{
+		page:    newPage(r, proj.Name),
+		Project: proj,
+		Role:    role,
+		IsAdmin: role == projects.RoleAdmin,
+	}
+	data.Notice, data.Error = notice, errMsg
+
+	if data.IsAdmin {
+		reqs, err := h.Enrollment.ListRequests(ctx, proj.ID)
+		if err != nil {
+			h.message(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+		for _, req := range reqs {
+			if req.Status == enroll.RequestStatusPending {
+				data.Pending = append(data.Pending, req)
+			}
+		}
+	}
+	rs, err := h.Enrollment.Recipients(ctx, proj.ID)
+	if err != nil {
+		h.message(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data.Devices = rs.Recipients
+	manifest, err := h.Secrets.Manifest(ctx, proj.ID)
+	if err != nil {
+		h.message(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	setHash, active := rs.ActiveSetHash(), rs.ActiveFingerprints()
+	for _, e := range manifest.Secrets {
+		st := secrets.CheckStaleness(e, setHash, active)
+		row := secretRow{ManifestEntry: e, Current: st.Current(), Status: st.Statuses()[0]}
+		data.Secrets = append(data.Secrets, row)
+	}
+	render(w, status, "project", data)
+}
+
+func (h *Handler) approve(w http.ResponseWriter, r *http.Request) {
+	proj, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+	user, _ := coreauth.UserFromContext(r.Context())
+	// The invite token is only needed to check the code; it is not kept.
+	verified, err := h.Enrollment.VerifyJoinCode(r.Context(), proj.ID, chi.URLParam(r, "requestID"), r.PostFormValue("token"), r.PostFormValue("code"))
+	if err != nil {
+		h.renderProject(w, r, actionStatus(err), "", err.Error())
+		return
+	}
+	req, added, err := h.Enrollment.Approve(r.Context(), proj.ID, verified.ID, verified.Fingerprint, user, "approved in web UI", time.Now().UTC())
+	if err != nil {
+		h.renderProject(w, r, actionStatus(err), "", err.Error())
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionEnrollApprove)
+	e.ProjectID = proj.ID
+	e.Target = req.ID
+	e.Detail = req.UserEmail + " " + req.DeviceName + " " + req.Fingerprint + " (web)"
+	h.Audit.TryRecord(r.Context(), e)
+	notice := "approved"
+	if !added {
+		notice = "approved-existing"
+	}
+	redirectToProject(w, r, proj.ID, notice)
+}
+
+func (h *Handler) reject(w http.ResponseWriter, r *http.Request) {
+	proj, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+	user, _ := coreauth.UserFromContext(r.Context())
+	req, err := h.Enrollment.Reject(r.Context(), proj.ID, chi.URLParam(r, "requestID"), user, r.PostFormValue("reason"), time.Now().UTC())
+	if err != nil {
+		h.renderProject(w, r, actionStatus(err), "", err.Error())
+		return
+	}
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionEnrollReject)
+	e.ProjectID = proj.ID
+	e.Target = req.ID
+	e.Detail = req.UserEmail + " " + req.DeviceName + " " + req.Fingerprint + " (web)"
+	h.Audit.TryRecord(r.Context(), e)
+	redirectToProject(w, r, proj.ID, "rejected")
+}
+
+func (h *Handler) revoke(w http.ResponseWriter, r *http.Request) {
+	proj, ok := h.admin(w, r)
+	if !ok {
+		return
+	}
+	user, _ := coreauth.UserFromContext(r.Context())
+	rec, changed, err := h.Enrollment.RevokeRecipient(r.Context(), proj.ID, chi.URLParam(r, "fingerprint"), time.Now().UTC())
+	if err != nil {
+		h.renderProject(w, r, actionStatus(err), "", err.Error())
+		return
+	}
+	if changed {
+		e := coreaudit.FromRequest(r, user, coreaudit.ActionDeviceRevoke)
+		e.ProjectID = proj.ID
+		e.Target = rec.Fingerprint
+		e.Detail = rec.Name + " (web)"
+		h.Audit.TryRecord(r.Context(), e)
+	}
+	redirectToProject(w, r, proj.ID, "revoked")
+}
+
+func (h *Handler) logout(w http.ResponseWriter, r *http.Request) {
+	if c, err := r.Cookie(coreauth.WebSessionCookie); err == nil {
+		if err := h.Sessions.Delete(r.Context(), c.Value); err != nil {
+			h.message(w, r, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	user, _ := coreauth.UserFromContext(r.Context())
+	e := coreaudit.FromRequest(r, user, coreaudit.ActionLogout)
+	e.Detail = "web"
+	h.Audit.TryRecord(r.Context(), e)
+	coreauth.ClearWebSessionCookie(w, h.Config.SecureCookies())
+	render(w, http.StatusOK, "signed_out", messagePage{page: page{Title: "Signed out"}})
+}
+
+// member resolves {projectID} for the signed-in user. Projects they do not
+// belong to look the same as missing ones.
+func (h *Handler) member(w http.ResponseWriter, r *http.Request) (projects.Project, projects.Role, bool) {
+	user, _ := coreauth.UserFromContext(r.Context())
+	id := chi.URLParam(r, "projectID")
+	role, err := h.Projects.Role(r.Context(), id, user.ID)
+	if errors.Is(err, projects.ErrProjectNotFound) || errors.Is(err, projects.ErrNotMember) {
+		h.message(w, r, http.StatusNotFound, projects.ErrProjectNotFound.Error())
+		return projects.Project{}, "", false
+	}
+	if err != nil {
+		h.message(w, r, http.StatusInternalServerError, err.Error())
+		return projects.Project{}, "", false
+	}
+	proj, err := h.Projects.Get(r.Context(), id)
+	if err != nil {
+		h.message(w, r, http.StatusInternalServerError, err.Error())
+		return projects.Project{}, "", false
+	}
+	return proj, role, true
+}
+
+func (h *Handler) admin(w http.ResponseWriter, r *http.Request) (projects.Project, bool) {
+	proj, role, ok := h.member(w, r)
+	if !ok {
+		return projects.Project{}, false
+	}
+	if !role.Allows(projects.RoleAdmin) {
+		h.message(w, r, http.StatusForbidden, "project admin role required")
+		return projects.Project{}, false
+	}
+	return proj, true
+}
+
+func (h *Handler) message(w http.ResponseWriter, r *http.Request, status int, msg string) {
+	render(w, status, "message", messagePage{page: newPage(r, http.StatusText(status)), Message: msg})
+}
+
+func newPage(r *http.Request, title string) page {
+	p := page{Title: title}
+	if sess, ok := coreauth.WebSessionFromContext(r.Context()); ok {
+		p.User = &sess.User
+		p.CSRF = sess.CSRF
+	}
+	return p
+}
+
+func render(w http.ResponseWriter, status int, name string, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := pages[name].ExecuteTemplate(w, "layout", data); err != nil {
+		_, _ = fmt.Fprintf(w, "<p>render error: %s</p>", template.HTMLEscapeString(err.Error()))
+	}
+}
+
+func redirectToProject(w http.ResponseWriter, r *http.Request, projectID, notice string) {
+	http.Redirect(w, r, "/ui/projects/"+projectID+"?notice="+notice, http.StatusSeeOther)
+}
+
+// actionStatus maps enrollment errors to the status of the re-rendered page.
+func actionStatus(err error) int {
+	switch {
+	case errors.Is(err, enroll.ErrInvalidToken), errors.Is(err, enroll.ErrSASMismatch):
+		return http.StatusBadRequest
+	case errors.Is(err, enroll.ErrRequestNotFound), errors.Is(err, enroll.ErrInviteNotFound), errors.Is(err, recipients.ErrRecipientNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, enrollment.ErrNotPending), errors.Is(err, enroll.ErrInviteExpired), errors.Is(err, enroll.ErrInviteUsed), errors.Is(err, enrollment.ErrFingerprintMismatch):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// requireCSRF rejects form posts that do not echo the session's CSRF token.
+func requireCSRF(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			sess, _ := coreauth.WebSessionFromContext(r.Context())
+			if !sess.CheckCSRF(r.PostFormValue("csrf_token")) {
+				http.Error(w, "invalid or missing CSRF token; reload the page and try again", http.StatusForbidden)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func securityHeaders(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		h.Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; form-action 'self'; frame-ancestors 'none'; base-uri 'none'")
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("Referrer-Policy", "same-origin")
+		h.Set("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}